@@ -1,9 +1,10 @@
 package trusera
 
 import (
-	"bufio"
 	"fmt"
-	"regexp"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -26,22 +27,43 @@ const (
 	OpGreaterThanOrEqual PolicyOperator = ">="
 	OpLessThan           PolicyOperator = "<"
 	OpLessThanOrEqual    PolicyOperator = "<="
+	OpAnd                PolicyOperator = "&&"
+	OpOr                 PolicyOperator = "||"
+	OpNot                PolicyOperator = "!"
+	OpIn                 PolicyOperator = "in"
 )
 
-// PolicyRule represents a parsed Cedar-like policy rule
+// PolicyRule represents a parsed Cedar-like policy rule.
+// Rules parsed from policy text evaluate the full condition of Policy.
+// Field, Operator and Value describe the condition when it is a single
+// comparison; hand-built rules with only those fields set are still supported,
+// and a rule with an empty Field and no Policy matches every request.
 type PolicyRule struct {
-	Action   PolicyAction
-	Field    string
-	Operator PolicyOperator
-	Value    any // string, int, or float64
-	Raw      string
+	ID          string // policy ID, from @id or assigned by position
+	Action      PolicyAction
+	Field       string
+	Operator    PolicyOperator
+	Value       any // string, int, or float64
+	Raw         string
+	Annotations map[string]string // policy annotations such as "enforcement"
+	Policy      *Policy           // parsed statement the rule came from, nil for hand-built rules
 }
 
+// Policy decisions
+const (
+	DecisionAllow = "Allow"
+	DecisionDeny  = "Deny"
+)
+
 // PolicyDecision represents the result of policy evaluation
 type PolicyDecision struct {
 	Decision string   // "Allow" or "Deny"
 	Reasons  []string // Human-readable reasons for the decision
 	Matched  []string // Raw policy rules that matched
+
+	// PolicyIDs and Rules identify the matched rules, parallel to Matched
+	PolicyIDs []string
+	Rules     []PolicyRule
 }
 
 // RequestContext contains information about an HTTP request for policy evaluation
@@ -50,144 +72,230 @@ type RequestContext struct {
 	Method   string
 	Hostname string
 	Path     string
-}
-
-var (
-	// Match: forbid ( principal, action == Action::"deploy", resource ) when { ... };
-	rulePattern = regexp.MustCompile(
-		`(?s)(forbid|permit)\s*\(\s*principal\s*,\s*action\s*==\s*Action::"(\w+)"\s*,\s*resource\s*\)\s*when\s*\{([^}]+)\}\s*;`,
-	)
 
-	// Match conditions: resource.field operator "value" or resource.field operator value
-	conditionPattern = regexp.MustCompile(
-		`resource\.(\w+)\s*(==|!=|>=|>|<=|<)\s*(?:"([^"]+)"|([^;"\s]+))`,
-	)
-
-	// Match comments
-	commentPattern = regexp.MustCompile(`//[^\n]*`)
-)
+	// Principal is the agent ID making the request, matched by
+	// principal == Agent::"<id>" in policy heads
+	Principal string
+	// Action is the kind of operation (EventHTTPRequest, EventToolCall,
+	// EventLLMInvoke, EventFileWrite), matched by action == Action::"<name>"
+	Action EventType
+
+	// IP is the address being connected to, exposed to policies as the
+	// ipaddr attribute resource.ip. It is set from the hostname when that is
+	// an IP literal, and to each resolved address when dialing.
+	IP netip.Addr
+
+	// Scheme is the URL scheme, e.g. "https" (resource.scheme)
+	Scheme string
+	// Port is the destination port (resource.port); zero when unknown
+	Port int
+	// Headers are exposed as the record resource.header, with
+	// case-insensitive names and repeated values joined by ", "
+	Headers http.Header
+	// Query holds the URL query parameters, exposed as the record
+	// resource.query with the first value of each parameter
+	Query url.Values
+	// ContentLength is the request body size in bytes (resource.body_size);
+	// negative when unknown
+	ContentLength int64
+	// ContentType is the media type of the body without parameters, e.g.
+	// "application/json" (resource.content_type)
+	ContentType string
+}
 
-// ParseCedarPolicy parses a Cedar-like policy file into rules
+// ParseCedarPolicy parses a Cedar policy file into rules, one per policy
+// statement. Templates are skipped, as they only apply once linked; use
+// ParsePolicies and LinkTemplate for those. Heads scoped to the legacy
+// action == Action::"deploy" placeholder match every action, as in older
+// versions (see MigrateLegacyActionScope).
 func ParseCedarPolicy(policyText string) ([]PolicyRule, error) {
-	var rules []PolicyRule
-
-	// Strip comments
-	cleaned := commentPattern.ReplaceAllString(policyText, "")
-
-	// Find all rule blocks
-	matches := rulePattern.FindAllStringSubmatch(cleaned, -1)
+	policies, err := ParsePolicies(policyText)
+	if err != nil {
+		return nil, err
+	}
+	migrateLegacyActionScope(policies, legacyAction)
 
-	for _, match := range matches {
-		if len(match) < 4 {
+	rules := make([]PolicyRule, 0, len(policies))
+	for _, policy := range policies {
+		if policy.IsTemplate() {
 			continue
 		}
+		rules = append(rules, ruleFromPolicy(policy))
+	}
 
-		action := PolicyAction(match[1])
-		// actionType := match[2] // e.g., "deploy" - not currently used
-		conditionBlock := strings.TrimSpace(match[3])
-		rawRule := strings.TrimSpace(match[0])
-
-		// Parse conditions within the when block
-		scanner := bufio.NewScanner(strings.NewReader(conditionBlock))
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
+	return rules, nil
+}
 
-			condMatches := conditionPattern.FindStringSubmatch(line)
-			if len(condMatches) < 3 {
-				continue
-			}
+// ruleFromPolicy wraps a parsed policy as a rule, filling Field, Operator and
+// Value when the condition is a single "resource.field op literal" comparison
+func ruleFromPolicy(policy *Policy) PolicyRule {
+	rule := PolicyRule{
+		ID:     policy.ID,
+		Action: policy.Effect,
+		Raw:    policy.Raw,
+		Policy: policy,
+	}
+	if len(policy.Annotations) > 0 {
+		rule.Annotations = make(map[string]string, len(policy.Annotations))
+		for _, ann := range policy.Annotations {
+			rule.Annotations[ann.Key] = ann.Value
+		}
+	}
 
-			field := condMatches[1]
-			operator := PolicyOperator(condMatches[2])
-
-			// Get value from either quoted (group 3) or unquoted (group 4)
-			var rawValue string
-			if condMatches[3] != "" {
-				rawValue = condMatches[3] // quoted value
-			} else if len(condMatches) > 4 && condMatches[4] != "" {
-				rawValue = condMatches[4] // unquoted value
-			} else {
-				continue
-			}
+	if len(policy.Conditions) != 1 || policy.Conditions[0].Kind != ConditionWhen || len(policy.Conditions[0].Clauses) != 1 {
+		return rule
+	}
+	bin, ok := policy.Conditions[0].Clauses[0].(*BinaryExpr)
+	if !ok || bin.Op == OpAnd || bin.Op == OpOr {
+		return rule
+	}
+	attr, ok := bin.Left.(*AttrExpr)
+	if !ok {
+		return rule
+	}
+	if v, ok := attr.Object.(*VarExpr); !ok || v.Name != "resource" {
+		return rule
+	}
+	lit, ok := bin.Right.(*LiteralExpr)
+	if !ok {
+		return rule
+	}
+
+	switch v := lit.Value.(type) {
+	case int64:
+		rule.Value = int(v)
+	case float64, string:
+		rule.Value = v
+	default:
+		return rule
+	}
+	rule.Field = attr.Attr
+	rule.Operator = bin.Op
+	return rule
+}
 
-			rawValue = strings.TrimSpace(rawValue)
+// EvalOption configures policy set evaluation
+type EvalOption func(*evalConfig)
 
-			// Parse value type
-			var value any
-			if intVal, err := strconv.ParseInt(rawValue, 10, 64); err == nil {
-				value = int(intVal)
-			} else if floatVal, err := strconv.ParseFloat(rawValue, 64); err == nil {
-				value = floatVal
-			} else {
-				value = rawValue
-			}
+// evalConfig holds policy set evaluation settings
+type evalConfig struct {
+	defaultDecision string
+}
 
-			rules = append(rules, PolicyRule{
-				Action:   action,
-				Field:    field,
-				Operator: operator,
-				Value:    value,
-				Raw:      rawRule,
-			})
+// WithDefaultDecision sets the decision returned when no rule matches.
+// DecisionDeny gives a deny-by-default policy set where only explicitly
+// permitted requests are allowed; any other value keeps the default of DecisionAllow.
+func WithDefaultDecision(decision string) EvalOption {
+	return func(c *evalConfig) {
+		if decision == DecisionDeny {
+			c.defaultDecision = DecisionDeny
+		} else {
+			c.defaultDecision = DecisionAllow
 		}
 	}
-
-	return rules, nil
 }
 
 // EvaluatePolicy evaluates a request context against Cedar policy rules
-func EvaluatePolicy(ctx RequestContext, rules []PolicyRule) PolicyDecision {
-	var forbidReasons []string
-	var forbidMatched []string
-	var permitReasons []string
-	var permitMatched []string
+func EvaluatePolicy(ctx RequestContext, rules []PolicyRule, opts ...EvalOption) PolicyDecision {
+	cfg := evalConfig{defaultDecision: DecisionAllow}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-	for _, rule := range rules {
-		if matches := evaluateCondition(rule, ctx); matches {
-			reason := fmt.Sprintf("%s: resource.%s %s %v (actual: %s)",
-				rule.Action, rule.Field, rule.Operator, rule.Value, getFieldValue(ctx, rule.Field))
+	forbids := PolicyDecision{Decision: DecisionDeny}
+	permits := PolicyDecision{Decision: DecisionAllow}
 
+	for _, rule := range rules {
+		if matches := ruleMatches(rule, ctx); matches {
 			if rule.Action == ActionForbid {
-				forbidReasons = append(forbidReasons, reason)
-				forbidMatched = append(forbidMatched, rule.Raw)
+				forbids.addMatch(rule, ctx)
 			} else if rule.Action == ActionPermit {
-				permitReasons = append(permitReasons, reason)
-				permitMatched = append(permitMatched, rule.Raw)
+				permits.addMatch(rule, ctx)
 			}
 		}
 	}
 
 	// Cedar semantics: any forbid overrides permit
-	if len(forbidReasons) > 0 {
-		return PolicyDecision{
-			Decision: "Deny",
-			Reasons:  forbidReasons,
-			Matched:  forbidMatched,
-		}
+	if len(forbids.Reasons) > 0 {
+		return forbids
 	}
 
 	// If we have explicit permits, allow
-	if len(permitReasons) > 0 {
+	if len(permits.Reasons) > 0 {
+		return permits
+	}
+
+	// Default: fall back to the policy set default if no rules matched
+	if cfg.defaultDecision == DecisionDeny {
 		return PolicyDecision{
-			Decision: "Allow",
-			Reasons:  permitReasons,
-			Matched:  permitMatched,
+			Decision: DecisionDeny,
+			Reasons:  []string{"No matching permit policy (default deny)"},
+			Matched:  []string{},
 		}
 	}
-
-	// Default: allow if no rules matched
 	return PolicyDecision{
-		Decision: "Allow",
+		Decision: DecisionAllow,
 		Reasons:  []string{"No matching policy rules"},
 		Matched:  []string{},
 	}
 }
 
+// addMatch records a matched rule in the decision
+func (d *PolicyDecision) addMatch(rule PolicyRule, ctx RequestContext) {
+	reason := ruleReason(rule, ctx)
+	if rule.ID != "" {
+		reason = "[" + rule.ID + "] " + reason
+	}
+	d.Reasons = append(d.Reasons, reason)
+	d.Matched = append(d.Matched, rule.Raw)
+	d.PolicyIDs = append(d.PolicyIDs, rule.ID)
+	d.Rules = append(d.Rules, rule)
+}
+
+// ruleMatches reports whether a rule applies to the request context
+func ruleMatches(rule PolicyRule, ctx RequestContext) bool {
+	if rule.Policy != nil {
+		ok, err := evalPolicy(rule.Policy, ctx)
+		return err == nil && ok
+	}
+	return evaluateCondition(rule, ctx)
+}
+
+// ruleReason describes why a matching rule applied
+func ruleReason(rule PolicyRule, ctx RequestContext) string {
+	if rule.Field != "" {
+		return fmt.Sprintf("%s: resource.%s %s %v (actual: %s)",
+			rule.Action, rule.Field, rule.Operator, rule.Value, getFieldValue(ctx, rule.Field))
+	}
+	if rule.Policy != nil && len(rule.Policy.Conditions) > 0 {
+		var when, unless []string
+		for _, cond := range rule.Policy.Conditions {
+			for _, clause := range cond.Clauses {
+				if cond.Kind == ConditionUnless {
+					unless = append(unless, ExprString(clause))
+				} else {
+					when = append(when, ExprString(clause))
+				}
+			}
+		}
+		reason := string(rule.Action) + ":"
+		if len(when) > 0 {
+			reason += " " + strings.Join(when, " && ")
+		}
+		if len(unless) > 0 {
+			reason += " unless " + strings.Join(unless, " && ")
+		}
+		return reason
+	}
+	return fmt.Sprintf("%s: unconditional", rule.Action)
+}
+
 // evaluateCondition checks if a rule condition matches the request context
 func evaluateCondition(rule PolicyRule, ctx RequestContext) bool {
+	if rule.Field == "" {
+		return true
+	}
+
 	actual := getFieldValue(ctx, rule.Field)
 	if actual == "" {
 		return false
@@ -227,6 +335,25 @@ func getFieldValue(ctx RequestContext, field string) string {
 		return ctx.Hostname
 	case "path":
 		return ctx.Path
+	case "ip":
+		if ctx.IP.IsValid() {
+			return ctx.IP.Unmap().String()
+		}
+		return ""
+	case "scheme":
+		return ctx.Scheme
+	case "port":
+		if ctx.Port > 0 {
+			return strconv.Itoa(ctx.Port)
+		}
+		return ""
+	case "body_size":
+		if ctx.ContentLength >= 0 {
+			return strconv.FormatInt(ctx.ContentLength, 10)
+		}
+		return ""
+	case "content_type":
+		return ctx.ContentType
 	default:
 		return ""
 	}
@@ -0,0 +1,899 @@
+package trusera
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FindingKind classifies a static analysis finding
+type FindingKind string
+
+const (
+	// FindingShadowedPermit is a permit that never takes effect because a
+	// forbid matches every request it matches
+	FindingShadowedPermit FindingKind = "shadowed_permit"
+	// FindingDuplicate is a policy equivalent to an earlier one, or a
+	// condition repeated within a policy
+	FindingDuplicate FindingKind = "duplicate"
+	// FindingSubsumed is a policy whose requests are all matched by another
+	// policy with the same effect, or a condition implied by another
+	FindingSubsumed FindingKind = "subsumed"
+	// FindingContradiction is a condition that can never hold
+	FindingContradiction FindingKind = "contradiction"
+)
+
+// AnalysisFinding is a problem found by AnalyzePolicies. Findings marshal to
+// JSON so CI jobs can gate policy changes on them
+type AnalysisFinding struct {
+	Kind     FindingKind        `json:"kind"`
+	Severity ValidationSeverity `json:"severity"`
+	PolicyID string             `json:"policy_id"`         // the dead or redundant policy
+	Related  []string           `json:"related,omitempty"` // policies that shadow, duplicate or subsume it
+	Pos      Position           `json:"pos"`
+	Message  string             `json:"message"`
+}
+
+// String formats the finding as "line:col: severity: message (policy id)"
+func (f AnalysisFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", f.Pos, f.Severity, f.Message, f.PolicyID)
+}
+
+// AnalyzePolicy parses policy text and runs AnalyzePolicies over it
+func AnalyzePolicy(policyText string) ([]AnalysisFinding, error) {
+	policies, err := ParsePolicies(policyText)
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzePolicies(policies), nil
+}
+
+// AnalyzePolicies reports shadowed permits, duplicate and subsumed policies
+// and conditions, and contradictory conditions. The analysis understands
+// comparisons of resource attributes with literals, in, contains, like and
+// has; other expressions are compared textually, so findings are never
+// reported for rules it cannot reason about.
+func AnalyzePolicies(policies []*Policy) []AnalysisFinding {
+	var findings []AnalysisFinding
+	analyzed := make([]*analyzedPolicy, 0, len(policies))
+
+	for _, policy := range policies {
+		ap := analyzePolicy(policy)
+		findings = append(findings, ap.conditionFindings()...)
+		if ap.ok && len(ap.terms) == 0 {
+			reason := ap.conflict
+			if reason == "" {
+				reason = "it is always false"
+			}
+			findings = append(findings, AnalysisFinding{
+				Kind:     FindingContradiction,
+				Severity: SeverityError,
+				PolicyID: policy.ID,
+				Pos:      policy.Conditions[0].Pos,
+				Message:  "condition can never hold: " + reason,
+			})
+			continue
+		}
+		// Templates only apply once linked, so only their conditions are checked
+		if policy.IsTemplate() {
+			continue
+		}
+		analyzed = append(analyzed, ap)
+	}
+
+	for i, a := range analyzed {
+		if !a.ok {
+			continue
+		}
+		var shadowing, duplicates, subsuming []string
+		for j, b := range analyzed {
+			if i == j || !b.ok || !a.implies(b) {
+				continue
+			}
+			switch {
+			case a.policy.Effect == ActionPermit && b.policy.Effect == ActionForbid:
+				shadowing = append(shadowing, b.policy.ID)
+			case a.policy.Effect != b.policy.Effect || !sameEnforcement(a.policy, b.policy):
+			case b.implies(a):
+				if j < i {
+					duplicates = append(duplicates, b.policy.ID)
+				}
+			default:
+				subsuming = append(subsuming, b.policy.ID)
+			}
+		}
+
+		id := a.policy.ID
+		if len(shadowing) > 0 {
+			findings = append(findings, AnalysisFinding{
+				Kind: FindingShadowedPermit, Severity: SeverityError, PolicyID: id, Related: shadowing, Pos: a.policy.Pos,
+				Message: fmt.Sprintf("permit %s never takes effect: forbid %s matches every request it matches", id, strings.Join(shadowing, ", ")),
+			})
+		}
+		if len(duplicates) > 0 {
+			findings = append(findings, AnalysisFinding{
+				Kind: FindingDuplicate, Severity: SeverityWarning, PolicyID: id, Related: duplicates, Pos: a.policy.Pos,
+				Message: fmt.Sprintf("%s duplicates %s", id, strings.Join(duplicates, ", ")),
+			})
+		}
+		if len(subsuming) > 0 {
+			findings = append(findings, AnalysisFinding{
+				Kind: FindingSubsumed, Severity: SeverityWarning, PolicyID: id, Related: subsuming, Pos: a.policy.Pos,
+				Message: fmt.Sprintf("%s is redundant: %s matches every request it matches", id, strings.Join(subsuming, ", ")),
+			})
+		}
+	}
+
+	return findings
+}
+
+// sameEnforcement reports whether two policies carry the same @enforcement
+// override; a policy is only redundant if dropping it changes nothing
+func sameEnforcement(a, b *Policy) bool {
+	av, _ := a.Annotation("enforcement")
+	bv, _ := b.Annotation("enforcement")
+	return av == bv
+}
+
+// maxTerms bounds the disjunctive normal form of a condition; larger
+// conditions are left unanalyzed
+const maxTerms = 64
+
+// analyzedPolicy is a policy whose condition is in disjunctive normal form
+type analyzedPolicy struct {
+	policy   *Policy
+	terms    []*term // satisfiable terms, any of which makes the policy match
+	ok       bool    // the condition could be normalized
+	conflict string  // why the last unsatisfiable term cannot hold
+}
+
+func analyzePolicy(policy *Policy) *analyzedPolicy {
+	ap := &analyzedPolicy{policy: policy}
+	dnf := [][]atom{{}}
+	for _, cond := range policy.Conditions {
+		var block [][]atom
+		var ok bool
+		if cond.Kind == ConditionWhen {
+			block, ok = clausesDNF(cond.Clauses, false)
+		} else {
+			block, ok = clausesDNF(cond.Clauses, true)
+		}
+		if !ok {
+			return ap
+		}
+		if dnf, ok = product(dnf, block); !ok {
+			return ap
+		}
+	}
+
+	ap.ok = true
+	for _, atoms := range dnf {
+		t := newTerm(atoms)
+		if t.conflict != "" {
+			ap.conflict = t.conflict
+			continue
+		}
+		ap.terms = append(ap.terms, t)
+	}
+	return ap
+}
+
+// clausesDNF normalizes the conjunction of a block's clauses, or its negation
+func clausesDNF(clauses []Expr, negate bool) ([][]atom, bool) {
+	if negate {
+		// A clause is only reached when the clauses before it held, so its
+		// terms also need their attributes to be present
+		var dnf [][]atom
+		var present []atom
+		for _, clause := range clauses {
+			terms, ok := toDNF(clause, true)
+			if !ok {
+				return nil, false
+			}
+			dnf = append(dnf, requireAll(terms, present)...)
+			if len(dnf) > maxTerms {
+				return nil, false
+			}
+			present = append(present, presenceAtoms(clause)...)
+		}
+		return dnf, true
+	}
+	dnf := [][]atom{{}}
+	for _, clause := range clauses {
+		terms, ok := toDNF(clause, false)
+		if !ok {
+			return nil, false
+		}
+		if dnf, ok = product(dnf, terms); !ok {
+			return nil, false
+		}
+	}
+	return dnf, true
+}
+
+// toDNF converts an expression, or its negation, into disjunctive normal form
+func toDNF(e Expr, negate bool) ([][]atom, bool) {
+	switch e := e.(type) {
+	case *LiteralExpr:
+		if b, ok := e.Value.(bool); ok {
+			if b != negate {
+				return [][]atom{{}}, true
+			}
+			return nil, true
+		}
+	case *UnaryExpr:
+		return toDNF(e.Operand, !negate)
+	case *BinaryExpr:
+		if e.Op == OpAnd || e.Op == OpOr {
+			left, ok := toDNF(e.Left, negate)
+			if !ok {
+				return nil, false
+			}
+			right, ok := toDNF(e.Right, negate)
+			if !ok {
+				return nil, false
+			}
+			// De Morgan: a negated && is an || of the negations
+			if (e.Op == OpAnd) != negate {
+				return product(left, right)
+			}
+			if len(left)+len(right) > maxTerms {
+				return nil, false
+			}
+			// The right operand is only reached after the left one evaluated
+			// without error, so its terms need the left attributes present
+			return append(left, requireAll(right, presenceAtoms(e.Left))...), true
+		}
+	}
+	return [][]atom{{atomFor(e, negate)}}, true
+}
+
+// presenceAtoms returns has atoms for the resource attributes e reads, which
+// must be present for e to evaluate without error
+func presenceAtoms(e Expr) []atom {
+	var atoms []atom
+	walkExpr(e, func(e Expr) {
+		if attr, ok := attributeKey(e); ok {
+			atoms = append(atoms, atom{kind: atomHas, attr: attr})
+		}
+	})
+	return atoms
+}
+
+// requireAll adds atoms to every term
+func requireAll(terms [][]atom, atoms []atom) [][]atom {
+	if len(atoms) == 0 {
+		return terms
+	}
+	out := make([][]atom, len(terms))
+	for i, t := range terms {
+		out[i] = append(append(make([]atom, 0, len(t)+len(atoms)), t...), atoms...)
+	}
+	return out
+}
+
+// product distributes a conjunction of two DNFs
+func product(a, b [][]atom) ([][]atom, bool) {
+	if len(a)*len(b) > maxTerms {
+		return nil, false
+	}
+	out := make([][]atom, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			t := make([]atom, 0, len(x)+len(y))
+			out = append(out, append(append(t, x...), y...))
+		}
+	}
+	return out, true
+}
+
+// atomKind is the constraint an atom places on an attribute
+type atomKind int
+
+const (
+	atomOpaque atomKind = iota // any other expression, compared textually
+	atomEq
+	atomNe
+	atomIn
+	atomNotIn
+	atomLt
+	atomLe
+	atomGt
+	atomGe
+	atomLike
+	atomNotLike
+	atomHas
+	atomNotHas
+)
+
+// atom is a primitive condition, possibly negated
+type atom struct {
+	kind    atomKind
+	attr    string // canonical attribute, e.g. resource.method; the expression text for opaque atoms
+	values  []any  // eq and ne hold one value, in and not-in the set members
+	num     float64
+	pattern []string
+	negated bool // opaque atoms only
+}
+
+// negatedOps maps relational operators to their negations
+var negatedOps = map[PolicyOperator]PolicyOperator{
+	OpEqual:              OpNotEqual,
+	OpNotEqual:           OpEqual,
+	OpLessThan:           OpGreaterThanOrEqual,
+	OpLessThanOrEqual:    OpGreaterThan,
+	OpGreaterThan:        OpLessThanOrEqual,
+	OpGreaterThanOrEqual: OpLessThan,
+}
+
+// flippedOps maps relational operators to their mirror image, for literal op attr
+var flippedOps = map[PolicyOperator]PolicyOperator{
+	OpEqual:              OpEqual,
+	OpNotEqual:           OpNotEqual,
+	OpLessThan:           OpGreaterThan,
+	OpLessThanOrEqual:    OpGreaterThanOrEqual,
+	OpGreaterThan:        OpLessThan,
+	OpGreaterThanOrEqual: OpLessThanOrEqual,
+}
+
+// atomFor classifies an expression as an attribute constraint where possible
+func atomFor(e Expr, negate bool) atom {
+	opaque := atom{kind: atomOpaque, attr: ExprString(e), negated: negate}
+
+	switch e := e.(type) {
+	case *BinaryExpr:
+		if e.Op == OpIn {
+			attr, ok := attributeKey(e.Left)
+			set, sok := literalSet(e.Right)
+			if !ok || !sok {
+				return opaque
+			}
+			if negate {
+				return atom{kind: atomNotIn, attr: attr, values: set}
+			}
+			return atom{kind: atomIn, attr: attr, values: set}
+		}
+
+		op := e.Op
+		attr, ok := attributeKey(e.Left)
+		lit, lok := e.Right.(*LiteralExpr)
+		if !ok || !lok {
+			attr, ok = attributeKey(e.Right)
+			lit, lok = e.Left.(*LiteralExpr)
+			op = flippedOps[op]
+		}
+		if !ok || !lok || op == "" {
+			return opaque
+		}
+		if negate {
+			op = negatedOps[op]
+		}
+		switch op {
+		case OpEqual:
+			return atom{kind: atomEq, attr: attr, values: []any{lit.Value}}
+		case OpNotEqual:
+			return atom{kind: atomNe, attr: attr, values: []any{lit.Value}}
+		}
+		n, ok := numericValue(lit.Value)
+		if !ok {
+			return opaque
+		}
+		kinds := map[PolicyOperator]atomKind{OpLessThan: atomLt, OpLessThanOrEqual: atomLe, OpGreaterThan: atomGt, OpGreaterThanOrEqual: atomGe}
+		return atom{kind: kinds[op], attr: attr, num: n}
+
+	case *MethodCallExpr:
+		if e.Method != "contains" {
+			return opaque
+		}
+		set, sok := literalSet(e.Receiver)
+		attr, ok := attributeKey(e.Args[0])
+		if !ok || !sok {
+			return opaque
+		}
+		if negate {
+			return atom{kind: atomNotIn, attr: attr, values: set}
+		}
+		return atom{kind: atomIn, attr: attr, values: set}
+
+	case *LikeExpr:
+		attr, ok := attributeKey(e.Operand)
+		if !ok {
+			return opaque
+		}
+		if negate {
+			return atom{kind: atomNotLike, attr: attr, pattern: e.Pattern}
+		}
+		return atom{kind: atomLike, attr: attr, pattern: e.Pattern}
+
+	case *HasExpr:
+		attr, ok := attributeKey(&AttrExpr{Object: e.Object, Attr: e.Attr})
+		if !ok {
+			return opaque
+		}
+		if negate {
+			return atom{kind: atomNotHas, attr: attr}
+		}
+		return atom{kind: atomHas, attr: attr}
+	}
+	return opaque
+}
+
+// attributeKey returns the canonical text of an attribute path rooted at resource
+func attributeKey(e Expr) (string, bool) {
+	for obj := e; ; {
+		attr, ok := obj.(*AttrExpr)
+		if !ok {
+			v, ok := obj.(*VarExpr)
+			if !ok || v.Name != "resource" || obj == e {
+				return "", false
+			}
+			if attr, ok := e.(*AttrExpr); ok && isResourceAttr(attr.Object, "header") {
+				// Header names are case-insensitive
+				return ExprString(attr.Object) + "." + strings.ToLower(attr.Attr), true
+			}
+			return ExprString(e), true
+		}
+		obj = attr.Object
+	}
+}
+
+// literalSet returns the members of a set literal made only of literals
+func literalSet(e Expr) ([]any, bool) {
+	set, ok := e.(*SetExpr)
+	if !ok {
+		return nil, false
+	}
+	values := make([]any, len(set.Elems))
+	for i, elem := range set.Elems {
+		lit, ok := elem.(*LiteralExpr)
+		if !ok {
+			return nil, false
+		}
+		values[i] = lit.Value
+	}
+	return values, true
+}
+
+// valueKey normalizes a literal so values the evaluator treats as equal share
+// a key: strings ignore case and numeric strings compare as numbers
+func valueKey(v any) string {
+	switch v := v.(type) {
+	case string:
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return "n:" + strconv.FormatFloat(n, 'g', -1, 64)
+		}
+		return "s:" + strings.ToLower(v)
+	case bool:
+		return "b:" + strconv.FormatBool(v)
+	}
+	if n, ok := numericValue(v); ok {
+		return "n:" + strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// interval is a range of numbers with open or closed ends
+type interval struct {
+	lo, hi         float64
+	loOpen, hiOpen bool
+}
+
+func unbounded() interval {
+	return interval{lo: math.Inf(-1), hi: math.Inf(1)}
+}
+
+// atomInterval returns the numbers satisfying a relational atom
+func atomInterval(a atom) interval {
+	iv := unbounded()
+	switch a.kind {
+	case atomLt:
+		iv.hi, iv.hiOpen = a.num, true
+	case atomLe:
+		iv.hi = a.num
+	case atomGt:
+		iv.lo, iv.loOpen = a.num, true
+	case atomGe:
+		iv.lo = a.num
+	}
+	return iv
+}
+
+// intersect narrows the interval to other
+func (iv *interval) intersect(other interval) {
+	if other.lo > iv.lo || (other.lo == iv.lo && other.loOpen) {
+		iv.lo, iv.loOpen = other.lo, other.loOpen
+	}
+	if other.hi < iv.hi || (other.hi == iv.hi && other.hiOpen) {
+		iv.hi, iv.hiOpen = other.hi, other.hiOpen
+	}
+}
+
+func (iv interval) empty() bool {
+	return iv.lo > iv.hi || (iv.lo == iv.hi && (iv.loOpen || iv.hiOpen))
+}
+
+func (iv interval) contains(n float64) bool {
+	return (n > iv.lo || (n == iv.lo && !iv.loOpen)) && (n < iv.hi || (n == iv.hi && !iv.hiOpen))
+}
+
+// within reports whether every number of iv lies in other
+func (iv interval) within(other interval) bool {
+	loOK := iv.lo > other.lo || (iv.lo == other.lo && (iv.loOpen || !other.loOpen))
+	hiOK := iv.hi < other.hi || (iv.hi == other.hi && (iv.hiOpen || !other.hiOpen))
+	return loOK && hiOK
+}
+
+// domain is the set of values one attribute may take within a term
+type domain struct {
+	present  bool            // some constraint requires the attribute
+	absent   bool            // !(resource has attr)
+	in       map[string]any  // allowed values by key; nil when unconstrained
+	out      map[string]bool // excluded value keys
+	bounds   interval
+	bounded  bool
+	like     [][]string
+	notLike  [][]string
+	conflict bool
+}
+
+// add narrows the domain by an atom
+func (d *domain) add(a atom) {
+	switch a.kind {
+	case atomHas:
+		d.present = true
+		return
+	case atomNotHas:
+		d.absent = true
+		return
+	}
+	d.present = true
+
+	switch a.kind {
+	case atomEq, atomIn:
+		allowed := make(map[string]any, len(a.values))
+		for _, v := range a.values {
+			if k := valueKey(v); d.in == nil || d.in[k] != nil {
+				allowed[k] = v
+			}
+		}
+		d.in = allowed
+	case atomNe, atomNotIn:
+		if d.out == nil {
+			d.out = make(map[string]bool)
+		}
+		for _, v := range a.values {
+			d.out[valueKey(v)] = true
+		}
+	case atomLt, atomLe, atomGt, atomGe:
+		if !d.bounded {
+			d.bounds, d.bounded = unbounded(), true
+		}
+		d.bounds.intersect(atomInterval(a))
+	case atomLike:
+		d.like = append(d.like, a.pattern)
+	case atomNotLike:
+		d.notLike = append(d.notLike, a.pattern)
+	}
+}
+
+// candidates returns the values the attribute can take when it is limited to
+// a finite set, after applying every other constraint
+func (d *domain) candidates() ([]any, bool) {
+	if d.in == nil {
+		return nil, false
+	}
+	var values []any
+	for k, v := range d.in {
+		if !d.out[k] && d.admits(v) {
+			values = append(values, v)
+		}
+	}
+	return values, true
+}
+
+// admits checks a candidate value against the range and pattern constraints
+func (d *domain) admits(v any) bool {
+	if d.bounded {
+		n, ok := numericValue(v)
+		if s, isStr := v.(string); isStr {
+			f, err := strconv.ParseFloat(s, 64)
+			n, ok = f, err == nil
+		}
+		if !ok || !d.bounds.contains(n) {
+			return false
+		}
+	}
+	if len(d.like) == 0 && len(d.notLike) == 0 {
+		return true
+	}
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	for _, p := range d.like {
+		if !matchPattern(s, p) {
+			return false
+		}
+	}
+	for _, p := range d.notLike {
+		if matchPattern(s, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// empty reports whether no value satisfies the domain
+func (d *domain) empty() bool {
+	if d.present && d.absent {
+		return true
+	}
+	if d.bounded && d.bounds.empty() {
+		return true
+	}
+	values, finite := d.candidates()
+	return finite && len(values) == 0
+}
+
+// term is a conjunction of atoms, grouped into per-attribute domains
+type term struct {
+	atoms    []atom
+	domains  map[string]*domain
+	opaque   map[string]bool // expression text -> negated
+	conflict string          // why the term can never hold
+}
+
+func newTerm(atoms []atom) *term {
+	t := &term{atoms: atoms, domains: make(map[string]*domain), opaque: make(map[string]bool)}
+	for _, a := range atoms {
+		if a.kind == atomOpaque {
+			if neg, seen := t.opaque[a.attr]; seen && neg != a.negated {
+				t.conflict = fmt.Sprintf("%s and !(%s)", a.attr, a.attr)
+			}
+			t.opaque[a.attr] = a.negated
+			continue
+		}
+		d := t.domains[a.attr]
+		if d == nil {
+			d = &domain{}
+			t.domains[a.attr] = d
+		}
+		d.add(a)
+	}
+	for attr, d := range t.domains {
+		if d.empty() {
+			t.conflict = "conflicting constraints on " + attr
+		}
+	}
+	return t
+}
+
+// implies reports whether every request satisfying t satisfies other
+func (t *term) implies(other *term) bool {
+	for _, a := range other.atoms {
+		if !t.impliesAtom(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// impliesAtom reports whether the term's constraints guarantee a
+func (t *term) impliesAtom(a atom) bool {
+	if a.kind == atomOpaque {
+		neg, ok := t.opaque[a.attr]
+		return ok && neg == a.negated
+	}
+	d := t.domains[a.attr]
+	if d == nil {
+		return false
+	}
+	values, finite := d.candidates()
+	allValues := func(pred func(any) bool) bool {
+		if !finite {
+			return false
+		}
+		for _, v := range values {
+			if !pred(v) {
+				return false
+			}
+		}
+		return true
+	}
+	excludes := func(v any) bool {
+		k := valueKey(v)
+		if d.out[k] || (finite && allValues(func(c any) bool { return valueKey(c) != k })) {
+			return true
+		}
+		n, ok := numericValue(v)
+		return ok && d.bounded && !d.bounds.contains(n)
+	}
+
+	switch a.kind {
+	case atomHas:
+		return d.present
+	case atomNotHas:
+		return d.absent
+	case atomEq, atomIn:
+		keys := make(map[string]bool, len(a.values))
+		for _, v := range a.values {
+			keys[valueKey(v)] = true
+		}
+		return allValues(func(v any) bool { return keys[valueKey(v)] })
+	case atomNe, atomNotIn:
+		for _, v := range a.values {
+			if !excludes(v) {
+				return false
+			}
+		}
+		return true
+	case atomLt, atomLe, atomGt, atomGe:
+		iv := atomInterval(a)
+		if d.bounded && d.bounds.within(iv) {
+			return true
+		}
+		return allValues(func(v any) bool {
+			n, ok := numericValue(v)
+			return ok && iv.contains(n)
+		})
+	case atomLike, atomNotLike:
+		for _, p := range d.like {
+			if a.kind == atomLike && patternString(p) == patternString(a.pattern) {
+				return true
+			}
+		}
+		for _, p := range d.notLike {
+			if a.kind == atomNotLike && patternString(p) == patternString(a.pattern) {
+				return true
+			}
+		}
+		return allValues(func(v any) bool {
+			s, ok := v.(string)
+			return ok && matchPattern(s, a.pattern) == (a.kind == atomLike)
+		})
+	}
+	return false
+}
+
+// implies reports whether every request a matches is also matched by b
+func (a *analyzedPolicy) implies(b *analyzedPolicy) bool {
+	if !scopeImplies(a.policy.Scope, b.policy.Scope) {
+		return false
+	}
+	for _, ta := range a.terms {
+		covered := false
+		for _, tb := range b.terms {
+			if ta.implies(tb) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeImplies reports whether policy head a is at least as narrow as b
+func scopeImplies(a, b PolicyScope) bool {
+	// resource in Host::"x" also admits subdomains of x, which an ==
+	// constraint on the same host does not
+	if a.Resource.Op == ScopeIn && b.Resource.Op == ScopeEq {
+		return false
+	}
+	return constraintImplies(a.Principal, b.Principal) &&
+		constraintImplies(a.Action, b.Action) &&
+		constraintImplies(a.Resource, b.Resource)
+}
+
+// constraintImplies reports whether every entity a admits is admitted by b
+func constraintImplies(a, b ScopeConstraint) bool {
+	if b.Op == ScopeAny {
+		return true
+	}
+	if a.Op == ScopeAny {
+		return false
+	}
+	for _, ra := range a.Entities {
+		found := false
+		for _, rb := range b.Entities {
+			if ra.ID == rb.ID && (entityTypeMatches(ra.Type, rb.Type) || entityTypeMatches(rb.Type, ra.Type)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionFindings reports repeated and implied conditions within the
+// policy's when blocks
+func (a *analyzedPolicy) conditionFindings() []AnalysisFinding {
+	var conjuncts []Expr
+	for _, cond := range a.policy.Conditions {
+		if cond.Kind != ConditionWhen {
+			continue
+		}
+		for _, clause := range cond.Clauses {
+			conjuncts = appendConjuncts(conjuncts, clause)
+		}
+	}
+
+	type normalized struct {
+		expr  Expr
+		text  string
+		terms []*term
+		ok    bool
+	}
+	norms := make([]normalized, len(conjuncts))
+	for i, c := range conjuncts {
+		n := normalized{expr: c, text: ExprString(c)}
+		if dnf, ok := toDNF(c, false); ok {
+			n.ok = true
+			for _, atoms := range dnf {
+				n.terms = append(n.terms, newTerm(atoms))
+			}
+		}
+		norms[i] = n
+	}
+
+	// implied reports whether every term of x implies some term of y
+	implied := func(x, y normalized) bool {
+		if !x.ok || !y.ok {
+			return false
+		}
+		for _, tx := range x.terms {
+			covered := false
+			for _, ty := range y.terms {
+				if tx.conflict != "" || tx.implies(ty) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+		return true
+	}
+
+	var findings []AnalysisFinding
+	for j, cj := range norms {
+		for i, ci := range norms {
+			if i == j {
+				continue
+			}
+			if ci.text == cj.text || (implied(ci, cj) && implied(cj, ci)) {
+				if i < j {
+					findings = append(findings, AnalysisFinding{
+						Kind: FindingDuplicate, Severity: SeverityWarning, PolicyID: a.policy.ID, Pos: cj.expr.Position(),
+						Message: fmt.Sprintf("condition %s repeats %s", cj.text, ci.text),
+					})
+					break
+				}
+				continue
+			}
+			if implied(ci, cj) {
+				findings = append(findings, AnalysisFinding{
+					Kind: FindingSubsumed, Severity: SeverityWarning, PolicyID: a.policy.ID, Pos: cj.expr.Position(),
+					Message: fmt.Sprintf("condition %s is implied by %s", cj.text, ci.text),
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// appendConjuncts flattens a chain of && into its operands
+func appendConjuncts(out []Expr, e Expr) []Expr {
+	if bin, ok := e.(*BinaryExpr); ok && bin.Op == OpAnd {
+		return appendConjuncts(appendConjuncts(out, bin.Left), bin.Right)
+	}
+	return append(out, e)
+}
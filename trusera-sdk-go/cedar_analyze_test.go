@@ -0,0 +1,231 @@
+package trusera
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// findingsOf filters findings by kind
+func findingsOf(findings []AnalysisFinding, kind FindingKind) []AnalysisFinding {
+	var out []AnalysisFinding
+	for _, f := range findings {
+		if f.Kind == kind {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func TestAnalyzePolicyShadowedPermit(t *testing.T) {
+	findings, err := AnalyzePolicy(`
+@id("no-openai-writes")
+forbid (principal, action, resource)
+when { resource.hostname == "api.openai.com" && resource.method in ["POST", "PUT"] };
+
+@id("allow-openai-post")
+permit (principal, action, resource)
+when { resource.hostname == "API.OpenAI.com" && resource.method == "POST" && resource.path like "/v1/*" };
+
+@id("allow-openai-get")
+permit (principal, action, resource)
+when { resource.hostname == "api.openai.com" && resource.method == "GET" };
+
+@id("allow-billing")
+permit (principal == Agent::"billing-bot", action == Action::"http_request", resource)
+when { resource.port > 8000 };
+
+@id("no-high-ports")
+forbid (principal, action, resource)
+when { resource.port >= 1024 };
+`)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	shadowed := findingsOf(findings, FindingShadowedPermit)
+	if len(shadowed) != 2 {
+		t.Fatalf("expected 2 shadowed permits, got %v", findings)
+	}
+	if shadowed[0].PolicyID != "allow-openai-post" || shadowed[0].Related[0] != "no-openai-writes" {
+		t.Errorf("unexpected finding: %+v", shadowed[0])
+	}
+	if shadowed[1].PolicyID != "allow-billing" || shadowed[1].Related[0] != "no-high-ports" {
+		t.Errorf("unexpected finding: %+v", shadowed[1])
+	}
+	if shadowed[0].Severity != SeverityError || shadowed[0].Pos.Line != 7 {
+		t.Errorf("expected error at line 7, got %s at %s", shadowed[0].Severity, shadowed[0].Pos)
+	}
+}
+
+func TestAnalyzePolicyScopeLimitsShadowing(t *testing.T) {
+	findings, err := AnalyzePolicy(`
+forbid (principal == Agent::"support-bot", action, resource)
+when { resource.method == "DELETE" };
+
+permit (principal, action, resource)
+when { resource.method == "DELETE" };
+
+forbid (principal, action, resource)
+when { resource.header.authorization like "Basic *" || resource.path == "/admin" };
+
+permit (principal, action, resource)
+when { resource.path == "/admin" };
+`)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	// The first forbid only covers one agent; the second forbid only
+	// reaches resource.path when the authorization header is present
+	if shadowed := findingsOf(findings, FindingShadowedPermit); len(shadowed) != 0 {
+		t.Errorf("expected no shadowed permits, got %v", shadowed)
+	}
+}
+
+func TestAnalyzePolicyDuplicatesAndSubsumed(t *testing.T) {
+	findings, err := AnalyzePolicy(`
+@id("a")
+forbid (principal, action, resource) when { resource.method == "DELETE" };
+
+@id("b")
+forbid (principal, action, resource) when { resource.method in ["delete"] };
+
+@id("c")
+forbid (principal, action, resource) when { resource.method == "DELETE" && resource.path == "/users" };
+
+@id("d")
+@enforcement("warn")
+forbid (principal, action, resource) when { resource.method == "DELETE" && resource.path == "/orders" };
+
+@id("e")
+forbid (principal, action, resource) when { resource.body_size > 100 };
+
+@id("f")
+forbid (principal, action, resource) when { resource.body_size > 1000 || resource.body_size >= 500 };
+`)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	dups := findingsOf(findings, FindingDuplicate)
+	if len(dups) != 1 || dups[0].PolicyID != "b" || dups[0].Related[0] != "a" {
+		t.Errorf("expected b to duplicate a, got %v", dups)
+	}
+
+	subsumed := findingsOf(findings, FindingSubsumed)
+	ids := make([]string, len(subsumed))
+	for i, f := range subsumed {
+		ids[i] = f.PolicyID
+	}
+	// d differs in enforcement, so it is not redundant
+	if strings.Join(ids, ",") != "c,f" {
+		t.Errorf("expected c and f to be subsumed, got %v", subsumed)
+	}
+	if len(subsumed) > 0 && strings.Join(subsumed[0].Related, ",") != "a,b" {
+		t.Errorf("expected c to be subsumed by a and b, got %v", subsumed[0].Related)
+	}
+}
+
+func TestAnalyzePolicyContradictions(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		reason string
+	}{
+		{"two methods", `forbid (principal, action, resource) when { resource.method == "GET" && resource.method == "POST" };`, "resource.method"},
+		{"across clauses", `forbid (principal, action, resource) when { resource.method == "GET"; resource.method == "POST" };`, "resource.method"},
+		{"empty range", `forbid (principal, action, resource) when { resource.port > 1024 && resource.port < 80 };`, "resource.port"},
+		{"set and exclusion", `forbid (principal, action, resource) when { resource.method in ["GET", "HEAD"] && !(resource.method in ["get", "head"]) };`, "resource.method"},
+		{"unless", `forbid (principal, action, resource) when { resource.method == "GET" } unless { resource.method != "POST" };`, "resource.method"},
+		{"like", `forbid (principal, action, resource) when { resource.hostname == "example.com" && resource.hostname like "*.openai.com" };`, "resource.hostname"},
+		{"has", `forbid (principal, action, resource) when { resource.header.cookie == "x" && !(resource.header has cookie) };`, "resource.header.cookie"},
+		{"opaque", `forbid (principal, action, resource) when { principal == Agent::"a" && !(principal == Agent::"a") };`, `principal == Agent::"a"`},
+		{"always false", `forbid (principal, action, resource) when { false || false };`, "always false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := AnalyzePolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("analysis failed: %v", err)
+			}
+			contradictions := findingsOf(findings, FindingContradiction)
+			if len(contradictions) != 1 {
+				t.Fatalf("expected a contradiction, got %v", findings)
+			}
+			if !strings.Contains(contradictions[0].Message, tt.reason) {
+				t.Errorf("expected message mentioning %q, got %q", tt.reason, contradictions[0].Message)
+			}
+		})
+	}
+}
+
+func TestAnalyzePolicySatisfiable(t *testing.T) {
+	policies := []string{
+		`forbid (principal, action, resource) when { resource.method == "GET" || resource.method == "POST" };`,
+		`forbid (principal, action, resource) when { resource.port >= 80 && resource.port <= 80 };`,
+		`forbid (principal, action, resource) when { resource.method != "GET" && resource.method != "POST" };`,
+		`forbid (principal, action, resource) when { resource.hostname like "*.openai.com" && resource.hostname like "api.*" };`,
+	}
+	for _, policy := range policies {
+		findings, err := AnalyzePolicy(policy)
+		if err != nil {
+			t.Fatalf("analysis failed: %v", err)
+		}
+		if len(findings) != 0 {
+			t.Errorf("expected no findings for %s, got %v", policy, findings)
+		}
+	}
+}
+
+func TestAnalyzePolicyRedundantConditions(t *testing.T) {
+	findings, err := AnalyzePolicy(`
+forbid (principal, action, resource)
+when {
+    resource.method == "POST" &&
+    resource.body_size > 1000 &&
+    resource.method == "POST" &&
+    resource.body_size > 10 &&
+    resource.method in ["POST", "PUT"]
+};
+`)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+
+	var messages []string
+	for _, f := range findings {
+		messages = append(messages, string(f.Kind)+": "+f.Message)
+	}
+	want := []string{
+		`duplicate: condition resource.method == "POST" repeats resource.method == "POST"`,
+		"subsumed: condition resource.body_size > 10 is implied by resource.body_size > 1000",
+		`subsumed: condition resource.method in ["POST", "PUT"] is implied by resource.method == "POST"`,
+	}
+	if strings.Join(messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected findings:\n%s", strings.Join(messages, "\n"))
+	}
+	if findings[0].Pos.Line != 6 {
+		t.Errorf("expected duplicate reported at line 6, got %s", findings[0].Pos)
+	}
+}
+
+func TestAnalyzePolicyRepositoryPolicies(t *testing.T) {
+	for _, path := range []string{"../.cedar/ai-policy.cedar", "examples/standalone/sample-policy.cedar"} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Logf("skipping %s: %v", path, err)
+			continue
+		}
+		findings, err := AnalyzePolicy(string(content))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, f := range findings {
+			if f.Severity == SeverityError {
+				t.Errorf("%s: unexpected finding %s", path, f)
+			}
+		}
+	}
+}
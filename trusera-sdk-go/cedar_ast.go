@@ -0,0 +1,450 @@
+package trusera
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policy is a parsed Cedar policy statement
+type Policy struct {
+	ID          string // @id annotation, or "policy<N>" by position in the source
+	Effect      PolicyAction
+	Annotations []Annotation
+	Scope       PolicyScope
+	Conditions  []Condition
+	Pos         Position // position of the effect keyword
+	Raw         string   // source text of the whole statement
+
+	// TemplateID and SlotValues are set on policies created by LinkTemplate
+	TemplateID string
+	SlotValues map[SlotID]EntityRef
+}
+
+// Annotation is a policy annotation such as @id("no-delete")
+type Annotation struct {
+	Key   string
+	Value string
+	Pos   Position
+}
+
+// Annotation returns the value of the annotation with the given key
+func (p *Policy) Annotation(key string) (string, bool) {
+	for _, ann := range p.Annotations {
+		if ann.Key == key {
+			return ann.Value, true
+		}
+	}
+	return "", false
+}
+
+// PolicyScope holds the principal, action and resource constraints of a policy head
+type PolicyScope struct {
+	Principal ScopeConstraint
+	Action    ScopeConstraint
+	Resource  ScopeConstraint
+}
+
+// ScopeOp is the operator of a scope constraint
+type ScopeOp string
+
+const (
+	ScopeAny ScopeOp = ""   // unconstrained, e.g. a bare "principal"
+	ScopeEq  ScopeOp = "==" // principal == Agent::"billing-bot"
+	ScopeIn  ScopeOp = "in" // action in [Action::"a", Action::"b"]
+)
+
+// ScopeConstraint constrains one scope variable of a policy head
+type ScopeConstraint struct {
+	Op       ScopeOp
+	Entities []EntityRef // one entity for ==, one or more for in
+	Pos      Position
+
+	// Slot names the template slot standing in for Entities, as in
+	// principal == ?principal; SlotPos is its position
+	Slot    SlotID
+	SlotPos Position
+}
+
+// SlotID names a template slot
+type SlotID string
+
+const (
+	SlotPrincipal SlotID = "?principal"
+	SlotResource  SlotID = "?resource"
+)
+
+// IsTemplate reports whether the policy has slots, which LinkTemplate must
+// fill before it can apply to requests
+func (p *Policy) IsTemplate() bool {
+	return len(p.Slots()) > 0
+}
+
+// Slots lists the template slots of the policy head
+func (p *Policy) Slots() []SlotID {
+	var slots []SlotID
+	for _, sc := range []ScopeConstraint{p.Scope.Principal, p.Scope.Resource} {
+		if sc.Slot != "" {
+			slots = append(slots, sc.Slot)
+		}
+	}
+	return slots
+}
+
+// EntityRef identifies a Cedar entity such as Action::"http_request"
+type EntityRef struct {
+	Type string // possibly namespaced, e.g. "Trusera::Agent"
+	ID   string
+}
+
+// String formats the entity reference in Cedar syntax
+func (e EntityRef) String() string {
+	return e.Type + "::" + quoteString(e.ID)
+}
+
+// ConditionKind distinguishes when and unless clauses
+type ConditionKind string
+
+const (
+	ConditionWhen   ConditionKind = "when"
+	ConditionUnless ConditionKind = "unless"
+)
+
+// Condition is a when or unless block of a policy
+type Condition struct {
+	Kind ConditionKind
+	// Clauses holds the ';'-separated expressions of the block. Standard Cedar
+	// has exactly one; multiple clauses are accepted for older policy files.
+	Clauses []Expr
+	Pos     Position
+}
+
+// Expr is a node of a Cedar condition expression
+type Expr interface {
+	Position() Position
+	exprNode()
+}
+
+// VarExpr references one of the scope variables principal, action, resource or context
+type VarExpr struct {
+	Name string
+	Pos  Position
+}
+
+// AttrExpr accesses an attribute, as in resource.hostname or resource["hostname"]
+type AttrExpr struct {
+	Object Expr
+	Attr   string
+	Pos    Position
+}
+
+// LiteralExpr is a string, integer, decimal or boolean literal
+type LiteralExpr struct {
+	Value any // string, int64, float64 or bool
+	Pos   Position
+}
+
+// EntityExpr is an entity literal such as Agent::"billing-bot"
+type EntityExpr struct {
+	Ref EntityRef
+	Pos Position
+}
+
+// BinaryExpr applies a binary operator such as ==, < or && to two operands
+type BinaryExpr struct {
+	Op    PolicyOperator
+	Left  Expr
+	Right Expr
+	Pos   Position
+}
+
+// UnaryExpr applies a prefix operator such as ! to an operand
+type UnaryExpr struct {
+	Op      PolicyOperator
+	Operand Expr
+	Pos     Position
+}
+
+// LikeExpr matches a string against a pattern, as in resource.hostname like "*.openai.com"
+type LikeExpr struct {
+	Operand Expr
+	// Pattern holds the literal segments between '*' wildcards, so
+	// "*.openai.com" is ["", ".openai.com"]
+	Pattern []string
+	Pos     Position
+}
+
+// HasExpr tests whether an attribute is present, as in resource has path
+type HasExpr struct {
+	Object Expr
+	Attr   string
+	Pos    Position
+}
+
+// SetExpr is a set literal such as ["GET", "HEAD"]
+type SetExpr struct {
+	Elems []Expr
+	Pos   Position
+}
+
+// MethodCallExpr calls a method on a value, as in ["a", "b"].contains(resource.method)
+type MethodCallExpr struct {
+	Receiver Expr
+	Method   string
+	Args     []Expr
+	Pos      Position
+}
+
+// CallExpr calls an extension function, as in ip("10.0.0.0/8")
+type CallExpr struct {
+	Func string
+	Args []Expr
+	Pos  Position
+}
+
+func (e *VarExpr) Position() Position        { return e.Pos }
+func (e *AttrExpr) Position() Position       { return e.Pos }
+func (e *LiteralExpr) Position() Position    { return e.Pos }
+func (e *EntityExpr) Position() Position     { return e.Pos }
+func (e *BinaryExpr) Position() Position     { return e.Pos }
+func (e *UnaryExpr) Position() Position      { return e.Pos }
+func (e *LikeExpr) Position() Position       { return e.Pos }
+func (e *HasExpr) Position() Position        { return e.Pos }
+func (e *SetExpr) Position() Position        { return e.Pos }
+func (e *MethodCallExpr) Position() Position { return e.Pos }
+func (e *CallExpr) Position() Position       { return e.Pos }
+
+func (*VarExpr) exprNode()        {}
+func (*AttrExpr) exprNode()       {}
+func (*LiteralExpr) exprNode()    {}
+func (*EntityExpr) exprNode()     {}
+func (*BinaryExpr) exprNode()     {}
+func (*UnaryExpr) exprNode()      {}
+func (*LikeExpr) exprNode()       {}
+func (*HasExpr) exprNode()        {}
+func (*SetExpr) exprNode()        {}
+func (*MethodCallExpr) exprNode() {}
+func (*CallExpr) exprNode()       {}
+
+// Operator precedence levels used when rendering expressions
+const (
+	precOr = iota + 1
+	precAnd
+	precRelation
+	precUnary
+	precPrimary
+)
+
+// precedence returns the binding strength of the expression's outermost operator
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		switch e.Op {
+		case OpOr:
+			return precOr
+		case OpAnd:
+			return precAnd
+		}
+		return precRelation
+	case *LikeExpr, *HasExpr:
+		return precRelation
+	case *UnaryExpr:
+		return precUnary
+	}
+	return precPrimary
+}
+
+// ExprString renders an expression in Cedar syntax, adding parentheses only where required
+func ExprString(e Expr) string {
+	var sb strings.Builder
+	writeExpr(&sb, e)
+	return sb.String()
+}
+
+// writeOperand renders e, parenthesized if it binds looser than minPrec
+func writeOperand(sb *strings.Builder, e Expr, minPrec int) {
+	if precedence(e) < minPrec {
+		sb.WriteByte('(')
+		writeExpr(sb, e)
+		sb.WriteByte(')')
+		return
+	}
+	writeExpr(sb, e)
+}
+
+func writeExpr(sb *strings.Builder, e Expr) {
+	switch e := e.(type) {
+	case *VarExpr:
+		sb.WriteString(e.Name)
+	case *AttrExpr:
+		writeOperand(sb, e.Object, precPrimary)
+		if isIdentifier(e.Attr) {
+			sb.WriteByte('.')
+			sb.WriteString(e.Attr)
+		} else {
+			sb.WriteByte('[')
+			sb.WriteString(quoteString(e.Attr))
+			sb.WriteByte(']')
+		}
+	case *LiteralExpr:
+		sb.WriteString(literalString(e.Value))
+	case *EntityExpr:
+		sb.WriteString(e.Ref.String())
+	case *UnaryExpr:
+		sb.WriteString(string(e.Op))
+		writeOperand(sb, e.Operand, precUnary)
+	case *LikeExpr:
+		writeOperand(sb, e.Operand, precRelation+1)
+		sb.WriteString(" like ")
+		sb.WriteString(patternString(e.Pattern))
+	case *HasExpr:
+		writeOperand(sb, e.Object, precRelation+1)
+		sb.WriteString(" has ")
+		if isIdentifier(e.Attr) {
+			sb.WriteString(e.Attr)
+		} else {
+			sb.WriteString(quoteString(e.Attr))
+		}
+	case *SetExpr:
+		sb.WriteByte('[')
+		for i, elem := range e.Elems {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeExpr(sb, elem)
+		}
+		sb.WriteByte(']')
+	case *MethodCallExpr:
+		writeOperand(sb, e.Receiver, precPrimary)
+		sb.WriteByte('.')
+		sb.WriteString(e.Method)
+		writeArgs(sb, e.Args)
+	case *CallExpr:
+		sb.WriteString(e.Func)
+		writeArgs(sb, e.Args)
+	case *BinaryExpr:
+		prec := precedence(e)
+		// Relations are non-associative, so nested relations always need parentheses
+		leftPrec, rightPrec := prec, prec+1
+		if prec == precRelation {
+			leftPrec = prec + 1
+		}
+		writeOperand(sb, e.Left, leftPrec)
+		sb.WriteByte(' ')
+		sb.WriteString(string(e.Op))
+		sb.WriteByte(' ')
+		writeOperand(sb, e.Right, rightPrec)
+	}
+}
+
+// writeArgs renders a parenthesized argument list
+func writeArgs(sb *strings.Builder, args []Expr) {
+	sb.WriteByte('(')
+	for i, arg := range args {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		writeExpr(sb, arg)
+	}
+	sb.WriteByte(')')
+}
+
+// walkExpr calls fn for e and every subexpression of e, depth first
+func walkExpr(e Expr, fn func(Expr)) {
+	fn(e)
+	switch e := e.(type) {
+	case *AttrExpr:
+		walkExpr(e.Object, fn)
+	case *BinaryExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *UnaryExpr:
+		walkExpr(e.Operand, fn)
+	case *LikeExpr:
+		walkExpr(e.Operand, fn)
+	case *HasExpr:
+		walkExpr(e.Object, fn)
+	case *SetExpr:
+		for _, elem := range e.Elems {
+			walkExpr(elem, fn)
+		}
+	case *MethodCallExpr:
+		walkExpr(e.Receiver, fn)
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	case *CallExpr:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	}
+}
+
+// literalString renders a literal value in Cedar syntax
+func literalString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return quoteString(v)
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// patternString renders like pattern segments as a Cedar pattern literal
+func patternString(segments []string) string {
+	quoted := make([]string, len(segments))
+	for i, seg := range segments {
+		q := quoteString(seg)
+		quoted[i] = strings.ReplaceAll(q[1:len(q)-1], "*", `\*`)
+	}
+	return `"` + strings.Join(quoted, "*") + `"`
+}
+
+// quoteString renders s as a Cedar string literal
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case 0:
+			sb.WriteString(`\0`)
+		default:
+			if unicode.IsPrint(r) {
+				sb.WriteRune(r)
+			} else {
+				sb.WriteString(`\u{` + strconv.FormatInt(int64(r), 16) + `}`)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// isIdentifier reports whether s can be written as a bare Cedar identifier
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
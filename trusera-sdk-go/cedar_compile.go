@@ -0,0 +1,387 @@
+package trusera
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompiledPolicy is a policy set prepared for fast repeated evaluation.
+// Rules are indexed by action, principal and required hostname, literals are
+// normalised once and like patterns are precompiled, so evaluating a request
+// only visits rules that can apply to it and does not allocate while
+// matching. It is safe for concurrent use.
+type CompiledPolicy struct {
+	rules           []compiledRule
+	forbids         ruleIndex
+	permits         ruleIndex
+	defaultDecision PolicyDecision
+}
+
+// compiledRule is one rule of a compiled policy set
+type compiledRule struct {
+	rule       PolicyRule
+	conditions []compiledCondition
+	interpret  bool // the condition uses features the compiler does not handle, so ruleMatches evaluates it
+
+	// resourceHosts holds the lowercase hosts of a resource scope constraint;
+	// with resourceIn, their subdomains match too
+	resourceScoped bool
+	resourceHosts  []string
+	resourceIn     bool
+
+	// single is the decision when this rule is the only match. When the
+	// rule's reason quotes the request value of Field, the reason is built
+	// from reasonPrefix on each match instead.
+	single       PolicyDecision
+	reasonPrefix string
+}
+
+// compiledCondition is a when or unless block
+type compiledCondition struct {
+	unless  bool
+	clauses []*cnode
+}
+
+// ruleIndex maps action ID, principal ID and lowercase hostname to the
+// positions of the rules that can apply, in policy order. The empty key holds
+// rules that do not constrain that part of the request.
+type ruleIndex map[string]map[string]map[string][]int32
+
+// maxIndexProbes is the number of index lists a request can draw rules from:
+// a specific or wildcard key for each of action, principal and hostname
+const maxIndexProbes = 8
+
+// CompilePolicy prepares rules for repeated evaluation. Evaluate on the result
+// returns the same decisions as EvaluatePolicy with the same options. The
+// rules are copied, so later changes to the slice do not affect the compiled
+// policy.
+func CompilePolicy(rules []PolicyRule, opts ...EvalOption) *CompiledPolicy {
+	cfg := evalConfig{defaultDecision: DecisionAllow}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	cp := &CompiledPolicy{
+		rules:   make([]compiledRule, len(rules)),
+		forbids: ruleIndex{},
+		permits: ruleIndex{},
+	}
+	if cfg.defaultDecision == DecisionDeny {
+		cp.defaultDecision = PolicyDecision{
+			Decision: DecisionDeny,
+			Reasons:  []string{"No matching permit policy (default deny)"},
+			Matched:  []string{},
+		}
+	} else {
+		cp.defaultDecision = PolicyDecision{
+			Decision: DecisionAllow,
+			Reasons:  []string{"No matching policy rules"},
+			Matched:  []string{},
+		}
+	}
+
+	for i, rule := range rules {
+		cr := &cp.rules[i]
+		cr.rule = rule
+		cr.compile()
+
+		switch rule.Action {
+		case ActionForbid:
+			cp.forbids.add(int32(i), rule)
+		case ActionPermit:
+			cp.permits.add(int32(i), rule)
+		}
+	}
+	return cp
+}
+
+// compile translates the rule's conditions, falling back to the interpreter
+// for anything the compiler does not handle
+func (cr *compiledRule) compile() {
+	cr.single.addMatch(cr.rule, RequestContext{})
+	if cr.rule.Action == ActionForbid {
+		cr.single.Decision = DecisionDeny
+	} else {
+		cr.single.Decision = DecisionAllow
+	}
+	if cr.rule.Field != "" {
+		// Same text as addMatch, up to the actual value
+		cr.reasonPrefix = fmt.Sprintf("%s: resource.%s %s %v (actual: ", cr.rule.Action, cr.rule.Field, cr.rule.Operator, cr.rule.Value)
+		if cr.rule.ID != "" {
+			cr.reasonPrefix = "[" + cr.rule.ID + "] " + cr.reasonPrefix
+		}
+		cr.single.Reasons = nil
+	}
+
+	if cr.rule.Policy == nil {
+		cr.interpret = cr.rule.Field != ""
+		return
+	}
+	if sc := cr.rule.Policy.Scope.Resource; sc.Op != ScopeAny {
+		cr.resourceScoped = true
+		cr.resourceIn = sc.Op == ScopeIn
+		for _, ref := range sc.Entities {
+			if entityTypeMatches(ref.Type, resourceEntityType) {
+				cr.resourceHosts = append(cr.resourceHosts, strings.ToLower(ref.ID))
+			}
+		}
+	}
+	for _, cond := range cr.rule.Policy.Conditions {
+		cc := compiledCondition{unless: cond.Kind == ConditionUnless}
+		for _, clause := range cond.Clauses {
+			node, ok := compileExpr(clause)
+			if !ok {
+				cr.interpret = true
+				cr.conditions = nil
+				return
+			}
+			cc.clauses = append(cc.clauses, node)
+		}
+		cr.conditions = append(cr.conditions, cc)
+	}
+}
+
+// matches reports whether the rule applies to the request. The index has
+// already checked the policy scope.
+func (cr *compiledRule) matches(ctx *RequestContext, host []byte) bool {
+	if cr.interpret {
+		return ruleMatches(cr.rule, *ctx)
+	}
+	if cr.resourceScoped && !cr.resourceMatches(host) {
+		return false
+	}
+	for i := range cr.conditions {
+		cond := &cr.conditions[i]
+		holds := true
+		for _, clause := range cond.clauses {
+			v, ok := clause.eval(ctx)
+			if !ok || v.kind != kindBool {
+				return false
+			}
+			if !v.b {
+				holds = false
+				break
+			}
+		}
+		if holds == cond.unless {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceMatches mirrors resourceMatches on the lowercase request hostname
+func (cr *compiledRule) resourceMatches(host []byte) bool {
+	if len(host) == 0 {
+		return false
+	}
+	for _, h := range cr.resourceHosts {
+		if string(host) == h {
+			return true
+		}
+		if cr.resourceIn && len(host) > len(h) && host[len(host)-len(h)-1] == '.' && string(host[len(host)-len(h):]) == h {
+			return true
+		}
+	}
+	return false
+}
+
+// add indexes a rule under every combination of the actions, principals and
+// hostname it can apply to. Rules whose scope names only entities of the
+// wrong type, and unlinked templates, can never apply and are left out.
+func (idx ruleIndex) add(pos int32, rule PolicyRule) {
+	actions, principals, hosts := []string{""}, []string{""}, []string{""}
+	if policy := rule.Policy; policy != nil {
+		actions = scopeKeys(policy.Scope.Action, actionEntityType)
+		principals = scopeKeys(policy.Scope.Principal, principalEntityType)
+		if host, ok := requiredHostname(policy); ok {
+			hosts = []string{host}
+		}
+		if sc := policy.Scope.Resource; sc.Op == ScopeEq {
+			hosts = nil
+			if len(sc.Entities) == 1 && sc.Entities[0].ID != "" && entityTypeMatches(sc.Entities[0].Type, resourceEntityType) {
+				hosts = []string{strings.ToLower(sc.Entities[0].ID)}
+			}
+		}
+	}
+
+	for _, action := range actions {
+		byPrincipal := idx[action]
+		if byPrincipal == nil {
+			byPrincipal = map[string]map[string][]int32{}
+			idx[action] = byPrincipal
+		}
+		for _, principal := range principals {
+			byHost := byPrincipal[principal]
+			if byHost == nil {
+				byHost = map[string][]int32{}
+				byPrincipal[principal] = byHost
+			}
+			for _, host := range hosts {
+				byHost[host] = append(byHost[host], pos)
+			}
+		}
+	}
+}
+
+// scopeKeys returns the entity IDs a scope constraint accepts, or the
+// wildcard key when it is unconstrained
+func scopeKeys(sc ScopeConstraint, entityType string) []string {
+	if sc.Op == ScopeAny {
+		return []string{""}
+	}
+	var keys []string
+	for _, ref := range sc.Entities {
+		// An empty ID never matches: constraints only apply to known values
+		if ref.ID == "" || !entityTypeMatches(ref.Type, entityType) {
+			continue
+		}
+		dup := false
+		for _, k := range keys {
+			dup = dup || k == ref.ID
+		}
+		if !dup {
+			keys = append(keys, ref.ID)
+		}
+	}
+	return keys
+}
+
+// requiredHostname finds a resource.hostname == "literal" comparison that
+// every match must satisfy: a conjunct of a when clause. The hostname is
+// returned lowercased, as string equality ignores case.
+func requiredHostname(policy *Policy) (string, bool) {
+	for _, cond := range policy.Conditions {
+		if cond.Kind != ConditionWhen {
+			continue
+		}
+		for _, clause := range cond.Clauses {
+			for _, c := range appendConjuncts(nil, clause) {
+				bin, ok := c.(*BinaryExpr)
+				if !ok || bin.Op != OpEqual {
+					continue
+				}
+				attr, lit := bin.Left, bin.Right
+				if _, ok := attr.(*LiteralExpr); ok {
+					attr, lit = lit, attr
+				}
+				if !isResourceAttr(attr, "hostname") {
+					continue
+				}
+				if l, ok := lit.(*LiteralExpr); ok {
+					if s, ok := l.Value.(string); ok && s != "" {
+						return strings.ToLower(s), true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// isResourceAttr reports whether e is resource.<name>
+func isResourceAttr(e Expr, name string) bool {
+	attr, ok := e.(*AttrExpr)
+	if !ok || attr.Attr != name {
+		return false
+	}
+	v, ok := attr.Object.(*VarExpr)
+	return ok && v.Name == "resource"
+}
+
+// Evaluate evaluates a request against the compiled policy set. The returned
+// decision may share its slices with the compiled policy and must not be
+// modified.
+func (cp *CompiledPolicy) Evaluate(ctx RequestContext) PolicyDecision {
+	var hostBuf [lowerBufSize]byte
+	host := appendLower(hostBuf[:0], ctx.Hostname)
+
+	// Cedar semantics: any forbid overrides permit, so permits are only
+	// evaluated when no forbid applies
+	if d, ok := cp.evaluateIndex(cp.forbids, &ctx, host, DecisionDeny); ok {
+		return d
+	}
+	if d, ok := cp.evaluateIndex(cp.permits, &ctx, host, DecisionAllow); ok {
+		return d
+	}
+	return cp.defaultDecision
+}
+
+// evaluateIndex evaluates the rules of one index that can apply to the
+// request, in policy order, and builds the decision if any match
+func (cp *CompiledPolicy) evaluateIndex(idx ruleIndex, ctx *RequestContext, host []byte, decision string) (PolicyDecision, bool) {
+	var lists [maxIndexProbes][]int32
+	n := 0
+	for _, action := range [2]string{string(ctx.Action), ""} {
+		byPrincipal := idx[action]
+		for _, principal := range [2]string{ctx.Principal, ""} {
+			byHost := byPrincipal[principal]
+			if len(host) > 0 {
+				if l := byHost[string(host)]; len(l) > 0 {
+					lists[n] = l
+					n++
+				}
+			}
+			if l := byHost[""]; len(l) > 0 {
+				lists[n] = l
+				n++
+			}
+			if ctx.Principal == "" {
+				break
+			}
+		}
+		if ctx.Action == "" {
+			break
+		}
+	}
+
+	var (
+		first   = int32(-1)
+		matched []int32
+	)
+	for {
+		// Merge the sorted lists so rules are visited in policy order; a rule
+		// is indexed under at most one of the probed keys
+		best := -1
+		for i := 0; i < n; i++ {
+			if len(lists[i]) > 0 && (best < 0 || lists[i][0] < lists[best][0]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		pos := lists[best][0]
+		lists[best] = lists[best][1:]
+
+		if !cp.rules[pos].matches(ctx, host) {
+			continue
+		}
+		switch {
+		case first < 0:
+			first = pos
+		case matched == nil:
+			matched = []int32{first, pos}
+		default:
+			matched = append(matched, pos)
+		}
+	}
+
+	if first < 0 {
+		return PolicyDecision{}, false
+	}
+	if matched == nil {
+		cr := &cp.rules[first]
+		d := cr.single
+		if cr.reasonPrefix != "" {
+			d.Reasons = []string{cr.reasonPrefix + getFieldValue(*ctx, cr.rule.Field) + ")"}
+		}
+		return d, true
+	}
+
+	d := PolicyDecision{Decision: decision}
+	for _, pos := range matched {
+		d.addMatch(cp.rules[pos].rule, *ctx)
+	}
+	return d, true
+}
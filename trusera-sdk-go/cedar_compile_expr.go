@@ -0,0 +1,641 @@
+package trusera
+
+import (
+	"bytes"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// lowerBufSize is the size of the stack buffers strings are lowercased into
+// for comparison; longer strings spill to the heap
+const lowerBufSize = 256
+
+// valueKind is the type of a compiled evaluation value
+type valueKind uint8
+
+const (
+	kindString valueKind = iota + 1
+	kindNumber           // long and decimal values, compared as float64 like numericValue
+	kindBool
+	kindEntity
+	kindIP
+	kindSet
+)
+
+// cvalue is an evaluated value of the compiled evaluator. Unlike the any
+// values of evalExpr it is passed by value, so producing one never allocates.
+type cvalue struct {
+	kind  valueKind
+	b     bool
+	num   float64
+	str   string
+	lower []byte // lowercase form of a constant string, nil for request values
+	numOK bool   // a constant string that parses as a number, stored in num
+	ref   EntityRef
+	ip    netip.Prefix
+	set   []cvalue
+}
+
+// nodeOp is the operation of a compiled expression node
+type nodeOp uint8
+
+const (
+	nodeConst nodeOp = iota
+	nodeFail         // always an evaluation error, such as an unknown attribute
+	nodePrincipal
+	nodeAction
+	nodeField    // a string attribute such as resource.hostname
+	nodeIP       // resource.ip
+	nodePort     // resource.port
+	nodeBodySize // resource.body_size
+	nodeHeader   // resource.header.<name>
+	nodeQuery    // resource.query.<name>
+	nodeHas      // resource has <name>
+	nodeHasHeader
+	nodeHasQuery
+	nodeLike
+	nodeAnd
+	nodeOr
+	nodeNot
+	nodeCompare
+	nodeIn
+	nodeMethod
+)
+
+// cnode is a compiled expression. It mirrors evalExpr for the expressions
+// compileExpr accepts, with the same results and error cases.
+type cnode struct {
+	op     nodeOp
+	cmp    PolicyOperator // nodeCompare
+	name   string         // attribute, header, query parameter or method name
+	canon  string         // canonical form of a header name
+	value  cvalue         // nodeConst
+	like   [][]byte       // lowercase like pattern segments
+	args   []*cnode
+	ranges []netip.Prefix // ranges tested by isLoopback and isMulticast
+}
+
+// compileExpr translates an expression into a node, or reports false when it
+// uses something only the interpreter supports, such as records as values
+func compileExpr(expr Expr) (*cnode, bool) {
+	switch e := expr.(type) {
+	case *LiteralExpr:
+		return constNode(e.Value)
+
+	case *EntityExpr:
+		return &cnode{op: nodeConst, value: cvalue{kind: kindEntity, ref: e.Ref}}, true
+
+	case *VarExpr:
+		switch e.Name {
+		case "principal":
+			return &cnode{op: nodePrincipal}, true
+		case "action":
+			return &cnode{op: nodeAction}, true
+		}
+		return &cnode{op: nodeFail}, true
+
+	case *AttrExpr:
+		if v, ok := e.Object.(*VarExpr); ok {
+			if v.Name != "resource" {
+				return &cnode{op: nodeFail}, true
+			}
+			switch e.Attr {
+			case "ip":
+				return &cnode{op: nodeIP}, true
+			case "port":
+				return &cnode{op: nodePort}, true
+			case "body_size":
+				return &cnode{op: nodeBodySize}, true
+			case "header", "query":
+				return nil, false
+			}
+			return &cnode{op: nodeField, name: e.Attr}, true
+		}
+		switch {
+		case isResourceAttr(e.Object, "header"):
+			// Header names are case-insensitive
+			name := strings.ToLower(e.Attr)
+			return &cnode{op: nodeHeader, name: name, canon: textproto.CanonicalMIMEHeaderKey(name)}, true
+		case isResourceAttr(e.Object, "query"):
+			return &cnode{op: nodeQuery, name: e.Attr}, true
+		}
+		return nil, false
+
+	case *HasExpr:
+		if v, ok := e.Object.(*VarExpr); ok && v.Name == "resource" {
+			return &cnode{op: nodeHas, name: e.Attr}, true
+		}
+		switch {
+		case isResourceAttr(e.Object, "header"):
+			name := strings.ToLower(e.Attr)
+			return &cnode{op: nodeHasHeader, name: name, canon: textproto.CanonicalMIMEHeaderKey(name)}, true
+		case isResourceAttr(e.Object, "query"):
+			return &cnode{op: nodeHasQuery, name: e.Attr}, true
+		}
+		return nil, false
+
+	case *LikeExpr:
+		operand, ok := compileExpr(e.Operand)
+		if !ok {
+			return nil, false
+		}
+		n := &cnode{op: nodeLike, args: []*cnode{operand}}
+		for _, seg := range e.Pattern {
+			n.like = append(n.like, appendLower(nil, seg))
+		}
+		return n, true
+
+	case *SetExpr:
+		set := make([]cvalue, 0, len(e.Elems))
+		for _, elem := range e.Elems {
+			n, ok := compileExpr(elem)
+			if !ok || n.op != nodeConst {
+				return nil, false
+			}
+			set = append(set, n.value)
+		}
+		return &cnode{op: nodeConst, value: cvalue{kind: kindSet, set: set}}, true
+
+	case *CallExpr:
+		// ip() of a literal was validated by the parser and is a constant
+		if lit, ok := e.Args[0].(*LiteralExpr); ok && e.Func == "ip" {
+			if s, ok := lit.Value.(string); ok {
+				if ip, err := parseIPAddr(s); err == nil {
+					return &cnode{op: nodeConst, value: cvalue{kind: kindIP, ip: ip}}, true
+				}
+			}
+		}
+		return nil, false
+
+	case *MethodCallExpr:
+		n := &cnode{op: nodeMethod, name: e.Method}
+		switch e.Method {
+		case "isLoopback":
+			n.ranges = loopbackRanges
+		case "isMulticast":
+			n.ranges = multicastRanges
+		}
+		for _, arg := range append([]Expr{e.Receiver}, e.Args...) {
+			a, ok := compileExpr(arg)
+			if !ok {
+				return nil, false
+			}
+			n.args = append(n.args, a)
+		}
+		return n, true
+
+	case *UnaryExpr:
+		operand, ok := compileExpr(e.Operand)
+		if !ok {
+			return nil, false
+		}
+		return &cnode{op: nodeNot, args: []*cnode{operand}}, true
+
+	case *BinaryExpr:
+		left, ok := compileExpr(e.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := compileExpr(e.Right)
+		if !ok {
+			return nil, false
+		}
+		n := &cnode{op: nodeCompare, cmp: e.Op, args: []*cnode{left, right}}
+		switch e.Op {
+		case OpAnd:
+			n.op = nodeAnd
+		case OpOr:
+			n.op = nodeOr
+		case OpIn:
+			n.op = nodeIn
+		}
+		return n, true
+	}
+	return nil, false
+}
+
+// constNode compiles a literal, normalising strings up front
+func constNode(v any) (*cnode, bool) {
+	var c cvalue
+	switch v := v.(type) {
+	case string:
+		c = cvalue{kind: kindString, str: v, lower: appendLower([]byte{}, v)}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			c.num, c.numOK = n, true
+		}
+	case int64:
+		c = cvalue{kind: kindNumber, num: float64(v)}
+	case float64:
+		c = cvalue{kind: kindNumber, num: v}
+	case bool:
+		c = cvalue{kind: kindBool, b: v}
+	default:
+		return nil, false
+	}
+	return &cnode{op: nodeConst, value: c}, true
+}
+
+// eval evaluates the node; false means an evaluation error, which makes the
+// enclosing policy not apply
+func (n *cnode) eval(ctx *RequestContext) (cvalue, bool) {
+	switch n.op {
+	case nodeConst:
+		return n.value, true
+
+	case nodePrincipal:
+		if ctx.Principal == "" {
+			return cvalue{}, false
+		}
+		return cvalue{kind: kindEntity, ref: EntityRef{Type: principalEntityType, ID: ctx.Principal}}, true
+
+	case nodeAction:
+		if ctx.Action == "" {
+			return cvalue{}, false
+		}
+		return cvalue{kind: kindEntity, ref: EntityRef{Type: actionEntityType, ID: string(ctx.Action)}}, true
+
+	case nodeField:
+		s := stringField(ctx, n.name)
+		return cvalue{kind: kindString, str: s}, s != ""
+
+	case nodeIP:
+		if !ctx.IP.IsValid() {
+			return cvalue{}, false
+		}
+		return cvalue{kind: kindIP, ip: addrPrefix(ctx.IP)}, true
+
+	case nodePort:
+		return cvalue{kind: kindNumber, num: float64(ctx.Port)}, ctx.Port > 0
+
+	case nodeBodySize:
+		return cvalue{kind: kindNumber, num: float64(ctx.ContentLength)}, ctx.ContentLength >= 0
+
+	case nodeHeader:
+		s, ok := headerValue(ctx.Headers, n.name, n.canon)
+		return cvalue{kind: kindString, str: s}, ok
+
+	case nodeQuery:
+		values := ctx.Query[n.name]
+		if len(values) == 0 {
+			return cvalue{}, false
+		}
+		return cvalue{kind: kindString, str: values[0]}, true
+
+	case nodeHas:
+		return cvalue{kind: kindBool, b: hasResourceAttribute(ctx, n.name)}, true
+
+	case nodeHasHeader:
+		_, ok := headerValue(ctx.Headers, n.name, n.canon)
+		return cvalue{kind: kindBool, b: ok}, true
+
+	case nodeHasQuery:
+		return cvalue{kind: kindBool, b: len(ctx.Query[n.name]) > 0}, true
+
+	case nodeLike:
+		v, ok := n.args[0].eval(ctx)
+		if !ok || v.kind != kindString {
+			return cvalue{}, false
+		}
+		var buf [lowerBufSize]byte
+		return cvalue{kind: kindBool, b: matchLowered(v.lowered(buf[:0]), n.like)}, true
+
+	case nodeAnd, nodeOr:
+		left, ok := n.args[0].eval(ctx)
+		if !ok || left.kind != kindBool {
+			return cvalue{}, false
+		}
+		if left.b == (n.op == nodeOr) {
+			return left, true
+		}
+		right, ok := n.args[1].eval(ctx)
+		if !ok || right.kind != kindBool {
+			return cvalue{}, false
+		}
+		return right, true
+
+	case nodeNot:
+		v, ok := n.args[0].eval(ctx)
+		if !ok || v.kind != kindBool {
+			return cvalue{}, false
+		}
+		return cvalue{kind: kindBool, b: !v.b}, true
+
+	case nodeCompare, nodeIn:
+		left, ok := n.args[0].eval(ctx)
+		if !ok {
+			return cvalue{}, false
+		}
+		right, ok := n.args[1].eval(ctx)
+		if !ok {
+			return cvalue{}, false
+		}
+		var b bool
+		if n.op == nodeIn {
+			b, ok = cvalueIn(&left, &right)
+		} else {
+			b, ok = compareCValues(n.cmp, &left, &right)
+		}
+		return cvalue{kind: kindBool, b: b}, ok
+
+	case nodeMethod:
+		return n.evalMethod(ctx)
+	}
+	return cvalue{}, false
+}
+
+// evalMethod mirrors evalMethodCall and evalIPMethod
+func (n *cnode) evalMethod(ctx *RequestContext) (cvalue, bool) {
+	recv, ok := n.args[0].eval(ctx)
+	if !ok {
+		return cvalue{}, false
+	}
+
+	if recv.kind == kindIP {
+		switch n.name {
+		case "isInRange":
+			arg, ok := n.args[1].eval(ctx)
+			if !ok || arg.kind != kindIP {
+				return cvalue{}, false
+			}
+			return cvalue{kind: kindBool, b: ipInRange(recv.ip, arg.ip)}, true
+		case "isLoopback", "isMulticast":
+			return cvalue{kind: kindBool, b: ipInAnyRange(recv.ip, n.ranges)}, true
+		case "isIpv4":
+			return cvalue{kind: kindBool, b: recv.ip.Addr().Is4()}, true
+		case "isIpv6":
+			return cvalue{kind: kindBool, b: recv.ip.Addr().Is6()}, true
+		}
+		return cvalue{}, false
+	}
+
+	if recv.kind != kindSet || len(n.args) != 2 {
+		return cvalue{}, false
+	}
+	arg, ok := n.args[1].eval(ctx)
+	if !ok {
+		return cvalue{}, false
+	}
+	switch n.name {
+	case "contains":
+		return cvalue{kind: kindBool, b: cvalueSetContains(recv.set, &arg)}, true
+	case "containsAll", "containsAny":
+		if arg.kind != kindSet {
+			return cvalue{}, false
+		}
+		wantAll := n.name == "containsAll"
+		for i := range arg.set {
+			if cvalueSetContains(recv.set, &arg.set[i]) != wantAll {
+				return cvalue{kind: kindBool, b: !wantAll}, true
+			}
+		}
+		return cvalue{kind: kindBool, b: wantAll}, true
+	}
+	return cvalue{}, false
+}
+
+// stringField reads a string resource attribute like getFieldValue, without
+// formatting the numeric attributes that have nodes of their own
+func stringField(ctx *RequestContext, field string) string {
+	switch field {
+	case "url":
+		return ctx.URL
+	case "method":
+		return ctx.Method
+	case "hostname":
+		return ctx.Hostname
+	case "path":
+		return ctx.Path
+	case "scheme":
+		return ctx.Scheme
+	case "content_type":
+		return ctx.ContentType
+	}
+	return ""
+}
+
+// hasResourceAttribute mirrors the presence check of resourceAttribute
+func hasResourceAttribute(ctx *RequestContext, name string) bool {
+	switch name {
+	case "ip":
+		return ctx.IP.IsValid()
+	case "port":
+		return ctx.Port > 0
+	case "body_size":
+		return ctx.ContentLength >= 0
+	case "header", "query":
+		return true
+	}
+	return stringField(ctx, name) != ""
+}
+
+// headerValue looks up a header the way the resource.header record exposes
+// it: by lowercase name, with repeated values joined by ", "
+func headerValue(h http.Header, name, canon string) (string, bool) {
+	values, ok := h[canon]
+	if !ok {
+		for k, v := range h {
+			if lowerEquals(k, name) {
+				values, ok = v, true
+				break
+			}
+		}
+		if !ok {
+			return "", false
+		}
+	}
+	if len(values) == 1 {
+		return values[0], true
+	}
+	return strings.Join(values, ", "), true
+}
+
+// lowerEquals reports whether strings.ToLower(s) == lower without allocating
+func lowerEquals(s, lower string) bool {
+	for _, r := range s {
+		l, size := utf8.DecodeRuneInString(lower)
+		if size == 0 || unicode.ToLower(r) != l {
+			return false
+		}
+		lower = lower[size:]
+	}
+	return lower == ""
+}
+
+// appendLower appends strings.ToLower(s) to dst
+func appendLower(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			for _, r := range s[i:] {
+				dst = utf8.AppendRune(dst, unicode.ToLower(r))
+			}
+			return dst
+		}
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// lowered returns the lowercase form of a string value, using buf for
+// request values
+func (v *cvalue) lowered(buf []byte) []byte {
+	if v.lower != nil {
+		return v.lower
+	}
+	return appendLower(buf, v.str)
+}
+
+// number converts a string value to a number the way compareValues does
+func (v *cvalue) number() (float64, bool) {
+	if v.lower != nil {
+		return v.num, v.numOK
+	}
+	if !mayParseFloat(v.str) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(v.str, 64)
+	return n, err == nil
+}
+
+// mayParseFloat rejects most strings strconv.ParseFloat would fail on, such
+// as paths and hostnames, without the allocation of its error
+func mayParseFloat(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	switch c := s[0]; {
+	case '0' <= c && c <= '9', c == '.':
+		return true
+	case c == 'i', c == 'I', c == 'n', c == 'N': // inf, infinity, nan
+		return true
+	}
+	return false
+}
+
+// matchLowered mirrors matchPattern on an already lowercased string
+func matchLowered(s []byte, segments [][]byte) bool {
+	if len(segments) == 1 {
+		return bytes.Equal(s, segments[0])
+	}
+
+	first := segments[0]
+	last := segments[len(segments)-1]
+	if !bytes.HasPrefix(s, first) {
+		return false
+	}
+	s = s[len(first):]
+	if len(s) < len(last) || !bytes.HasSuffix(s, last) {
+		return false
+	}
+	s = s[:len(s)-len(last)]
+
+	for _, seg := range segments[1 : len(segments)-1] {
+		i := bytes.Index(s, seg)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(seg):]
+	}
+	return true
+}
+
+// compareCValues mirrors compareValues
+func compareCValues(op PolicyOperator, left, right *cvalue) (bool, bool) {
+	switch {
+	case left.kind == kindNumber && right.kind == kindNumber:
+		return compareNumeric(left.num, right.num, op), true
+
+	case left.kind == kindString && right.kind == kindString:
+		var lbuf, rbuf [lowerBufSize]byte
+		c := bytes.Compare(left.lowered(lbuf[:0]), right.lowered(rbuf[:0]))
+		return compareNumeric(float64(c), 0, op), true
+
+	case left.kind == kindString && right.kind == kindNumber:
+		n, ok := left.number()
+		if !ok {
+			return false, false
+		}
+		return compareNumeric(n, right.num, op), true
+
+	case left.kind == kindNumber && right.kind == kindString:
+		n, ok := right.number()
+		if !ok {
+			return false, false
+		}
+		return compareNumeric(left.num, n, op), true
+	}
+
+	switch op {
+	case OpEqual:
+		return cvaluesEqual(left, right), true
+	case OpNotEqual:
+		return !cvaluesEqual(left, right), true
+	}
+	return false, false
+}
+
+// cvalueIn mirrors evalIn
+func cvalueIn(left, right *cvalue) (bool, bool) {
+	switch right.kind {
+	case kindSet:
+		return cvalueSetContains(right.set, left), true
+	case kindEntity:
+		if left.kind == kindEntity {
+			return cvaluesEqual(left, right), true
+		}
+	}
+	return false, false
+}
+
+// cvalueSetContains mirrors setContains
+func cvalueSetContains(set []cvalue, v *cvalue) bool {
+	for i := range set {
+		if cvaluesEqual(&set[i], v) {
+			return true
+		}
+	}
+	return false
+}
+
+// cvaluesEqual mirrors valuesEqual
+func cvaluesEqual(a, b *cvalue) bool {
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case kindString:
+		return strings.EqualFold(a.str, b.str)
+	case kindNumber:
+		return a.num == b.num
+	case kindBool:
+		return a.b == b.b
+	case kindEntity:
+		return a.ref.ID == b.ref.ID && (entityTypeMatches(a.ref.Type, b.ref.Type) || entityTypeMatches(b.ref.Type, a.ref.Type))
+	case kindIP:
+		return a.ip == b.ip
+	case kindSet:
+		for i := range a.set {
+			if !cvalueSetContains(b.set, &a.set[i]) {
+				return false
+			}
+		}
+		for i := range b.set {
+			if !cvalueSetContains(a.set, &b.set[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+package trusera
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const compileTestPolicy = `
+@id("block-evil")
+forbid (principal, action, resource) when { resource.hostname == "Evil.Example.com" };
+
+@id("billing-payments")
+forbid (principal == Agent::"billing-bot", action == Action::"http_request", resource)
+when { resource.path like "/payments/*" && resource.method in ["POST", "put"] };
+
+@id("no-metadata")
+forbid (principal, action, resource)
+when { resource.ip.isInRange(ip("169.254.0.0/16")) || resource.ip.isLoopback() };
+
+@id("big-uploads")
+forbid (principal, action in [Action::"http_request", Action::"tool_call"], resource)
+when { resource.body_size > 1000 }
+unless { resource.header has "x-upload-token" && resource.header["x-upload-token"] like "tok-*" };
+
+@id("debug-query")
+forbid (principal, action, resource) when { resource.query.debug == "1" || resource.query has trace };
+
+@id("high-ports")
+forbid (principal, action == Action::"deploy", resource) when { resource.port >= "8000" };
+
+@id("no-error-negation")
+forbid (principal, action, resource) when { !(resource.content_type == "text/plain") && resource.scheme == "http" };
+
+@id("legacy-size")
+forbid (principal, action, resource) when { resource.path > 100 };
+
+@id("set-methods")
+forbid (principal, action, resource)
+when { ["delete", "purge"].contains(resource.method) || ["A", "b"].containsAll(["a", "B"]) && resource.hostname == "sets.test" };
+
+@id("entity-in")
+permit (principal, action, resource)
+when { principal in [Agent::"support-bot", Trusera::Agent::"ops-bot"] && (principal in Agent::"ops-bot" || action == Action::"tool_call") };
+
+@id("ops-scope")
+permit (principal in Trusera::Agent::"ops-bot", action, resource) when { resource.method == "GET" };
+
+@id("pay-host")
+forbid (principal, action, resource == Host::"PAY.example.com") when { resource.method == "PUT" };
+
+@id("example-domain")
+permit (principal, action, resource in Host::"example.com") when { resource.scheme == "https" && resource.method != "GET" };
+
+@id("allow-api")
+permit (principal, action, resource) when { resource.hostname == "api.example.com" };
+
+@id("allow-unicode")
+permit (principal, action, resource) when { resource.path like "/Ünï*" };
+
+@id("interpreted")
+permit (principal, action, resource) when { ip(resource.hostname).isIpv4() };
+`
+
+// compileTestContexts returns requests covering the rules of compileTestPolicy
+func compileTestContexts() []RequestContext {
+	base := func(method, rawURL string) RequestContext {
+		req, _ := http.NewRequest(method, rawURL, nil)
+		ctx := newRequestContext(req)
+		ctx.ContentLength = -1
+		return ctx
+	}
+
+	var out []RequestContext
+	for _, principal := range []string{"", "billing-bot", "support-bot", "ops-bot"} {
+		for _, action := range []EventType{"", EventHTTPRequest, EventToolCall} {
+			for _, c := range []RequestContext{
+				base("GET", "https://EVIL.example.com/x"),
+				base("POST", "https://pay.example.com/payments/123"),
+				base("PUT", "https://pay.example.com/PAYMENTS/1"),
+				base("GET", "http://169.254.169.254/latest/meta-data"),
+				base("GET", "http://127.0.0.1:8080/"),
+				base("GET", "https://api.example.com/v1?debug=1"),
+				base("GET", "https://api.example.com/v1?trace="),
+				base("DELETE", "https://api.example.com/users/1"),
+				base("GET", "https://api.example.com:9000/x"),
+				base("GET", "http://plain.test/x"),
+				base("GET", "https://sets.test/x"),
+				base("GET", "https://unicode.test/ünïcode"),
+				base("GET", "https://10.1.2.3/x"),
+				base("GET", "https://numeric.test/250"),
+			} {
+				c.Principal = principal
+				c.Action = action
+				out = append(out, c)
+
+				upload := c
+				upload.ContentLength = 5000
+				upload.Headers = http.Header{"X-Upload-Token": {"TOK-1"}}
+				upload.ContentType = "text/plain"
+				out = append(out, upload)
+
+				upload.Headers = http.Header{"x-upload-token": {"bad"}}
+				upload.ContentType = "application/json"
+				out = append(out, upload)
+			}
+		}
+	}
+	return out
+}
+
+func TestCompiledPolicyMatchesEvaluatePolicy(t *testing.T) {
+	rules, err := ParseCedarPolicy(compileTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	rules = append(rules, PolicyRule{ID: "hand-built", Action: ActionForbid, Field: "method", Operator: OpEqual, Value: "patch"})
+
+	for _, defaultDecision := range []string{DecisionAllow, DecisionDeny} {
+		compiled := CompilePolicy(rules, WithDefaultDecision(defaultDecision))
+		denied := 0
+		for _, ctx := range compileTestContexts() {
+			want := EvaluatePolicy(ctx, rules, WithDefaultDecision(defaultDecision))
+			got := compiled.Evaluate(ctx)
+			if !reflect.DeepEqual(got.Reasons, want.Reasons) || got.Decision != want.Decision ||
+				!reflect.DeepEqual(got.PolicyIDs, want.PolicyIDs) || !reflect.DeepEqual(got.Matched, want.Matched) {
+				t.Errorf("%s %s as %q/%q: compiled %s %v, interpreted %s %v",
+					ctx.Method, ctx.URL, ctx.Principal, ctx.Action, got.Decision, got.Reasons, want.Decision, want.Reasons)
+			}
+			if want.Decision == DecisionDeny {
+				denied++
+			}
+		}
+		if denied == 0 {
+			t.Errorf("expected some denials with default %s", defaultDecision)
+		}
+	}
+}
+
+func TestCompiledPolicyFallsBackToInterpreter(t *testing.T) {
+	rules, err := ParseCedarPolicy(compileTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	compiled := CompilePolicy(rules)
+
+	for _, cr := range compiled.rules {
+		if cr.interpret != (cr.rule.ID == "interpreted") {
+			t.Errorf("rule %s: expected interpret=%t", cr.rule.ID, !cr.interpret)
+		}
+	}
+
+	d := compiled.Evaluate(RequestContext{Method: "GET", Hostname: "10.1.2.3"})
+	if d.Decision != DecisionAllow || len(d.PolicyIDs) != 1 || d.PolicyIDs[0] != "interpreted" {
+		t.Errorf("expected interpreted permit, got %+v", d)
+	}
+}
+
+func TestCompiledPolicyIndex(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal == Agent::"a", action, resource) when { resource.hostname == "X.test" };
+forbid (principal == Other::"a", action, resource);
+forbid (principal, action in [Action::"tool_call", Action::"tool_call"], resource);
+forbid (principal, action == Action::"deploy", resource) when { resource.method == "GET" && resource.hostname == "y.test" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	compiled := CompilePolicy(rules)
+
+	want := ruleIndex{
+		"": {
+			"a": {"x.test": {0}},
+			"":  {"y.test": {3}},
+		},
+		"tool_call": {"": {"": {2}}},
+	}
+	if !reflect.DeepEqual(compiled.forbids, want) {
+		t.Errorf("unexpected index: %v", compiled.forbids)
+	}
+}
+
+func TestCompiledPolicyEvaluateDoesNotAllocate(t *testing.T) {
+	rules, err := ParseCedarPolicy(compileTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	// The interpreted rule allocates like EvaluatePolicy does
+	compiled := CompilePolicy(rules[:len(rules)-1])
+
+	tests := map[string]RequestContext{
+		"no match": {
+			Method: "GET", URL: "https://other.example.com/v1/chat", Hostname: "other.example.com", Path: "/v1/chat",
+			Scheme: "https", Port: 443, ContentLength: 10, Principal: "billing-bot", Action: EventHTTPRequest,
+			IP:      netip.MustParseAddr("10.0.0.1"),
+			Headers: http.Header{"Content-Type": {"application/json"}}, Query: url.Values{"q": {"x"}},
+		},
+		"static reason": {
+			Method: "POST", Hostname: "pay.example.com", Path: "/Payments/1", Port: 443, ContentLength: -1,
+			Principal: "billing-bot", Action: EventHTTPRequest,
+		},
+		"mixed case hostname": {Method: "GET", Hostname: "Other.Example.COM", Path: "/", ContentLength: -1},
+	}
+	for name, ctx := range tests {
+		t.Run(name, func(t *testing.T) {
+			if allocs := testing.AllocsPerRun(100, func() { compiled.Evaluate(ctx) }); allocs != 0 {
+				t.Errorf("expected no allocations, got %.1f", allocs)
+			}
+		})
+	}
+}
+
+// benchmarkRules builds a policy set of n rules in the shape of a large
+// organisation's egress policy: per-host blocks, per-agent allowances and a
+// few broad pattern rules
+func benchmarkRules(b *testing.B, n int) []PolicyRule {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&sb, "forbid (principal, action, resource) when { resource.hostname == \"blocked-%d.example.com\" };\n", i)
+		case 1:
+			fmt.Fprintf(&sb, "permit (principal == Agent::\"agent-%d\", action == Action::\"http_request\", resource) when { resource.hostname == \"api-%d.example.com\" && resource.method in [\"GET\", \"POST\"] };\n", i, i)
+		case 2:
+			fmt.Fprintf(&sb, "forbid (principal == Agent::\"agent-%d\", action, resource) when { resource.path like \"/admin-%d/*\" };\n", i, i)
+		case 3:
+			fmt.Fprintf(&sb, "forbid (principal, action == Action::\"tool_call\", resource) when { resource.name == \"tool-%d\" };\n", i)
+		}
+	}
+	sb.WriteString(`forbid (principal, action, resource) when { resource.hostname like "*.internal" || resource.body_size > 10000000 };` + "\n")
+
+	rules, err := ParseCedarPolicy(sb.String())
+	if err != nil {
+		b.Fatalf("failed to parse policy: %v", err)
+	}
+	return rules
+}
+
+// benchmarkContext is an allowed request that no rule matches
+func benchmarkContext() RequestContext {
+	return RequestContext{
+		URL: "https://api.openai.com/v1/chat/completions", Method: "POST", Hostname: "api.openai.com",
+		Path: "/v1/chat/completions", Scheme: "https", Port: 443, ContentLength: 2048,
+		Principal: "agent-5", Action: EventHTTPRequest,
+	}
+}
+
+func BenchmarkEvaluatePolicy1k(b *testing.B) {
+	rules := benchmarkRules(b, 1000)
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		EvaluatePolicy(ctx, rules)
+	}
+}
+
+func BenchmarkCompiledPolicy1k(b *testing.B) {
+	compiled := CompilePolicy(benchmarkRules(b, 1000))
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Evaluate(ctx)
+	}
+}
+
+func BenchmarkCompiledPolicy1kMatch(b *testing.B) {
+	compiled := CompilePolicy(benchmarkRules(b, 1000))
+	ctx := benchmarkContext()
+	ctx.Hostname = "blocked-400.example.com"
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled.Evaluate(ctx)
+	}
+}
+
+func BenchmarkCompilePolicy1k(b *testing.B) {
+	rules := benchmarkRules(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CompilePolicy(rules)
+	}
+}
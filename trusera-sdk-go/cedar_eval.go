@@ -0,0 +1,557 @@
+package trusera
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// evalPolicy reports whether the policy applies to the request: every when
+// block must hold and no unless block may hold. An evaluation error (missing
+// attribute, type mismatch) means the policy does not apply.
+func evalPolicy(policy *Policy, ctx RequestContext) (bool, error) {
+	if !scopeMatches(policy.Scope, ctx) {
+		return false, nil
+	}
+	for _, cond := range policy.Conditions {
+		holds, err := evalCondition(cond, ctx)
+		if err != nil {
+			return false, err
+		}
+		if holds == (cond.Kind == ConditionUnless) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Entity types of the principal, action and resource scope variables. The
+// resource of a request is the host it is sent to.
+const (
+	principalEntityType = "Agent"
+	actionEntityType    = "Action"
+	resourceEntityType  = "Host"
+)
+
+// scopeMatches reports whether the request falls within the policy head
+func scopeMatches(scope PolicyScope, ctx RequestContext) bool {
+	principal := EntityRef{Type: principalEntityType, ID: ctx.Principal}
+	action := EntityRef{Type: actionEntityType, ID: string(ctx.Action)}
+
+	if !constraintMatches(scope.Principal, principal, ctx.Principal != "") {
+		return false
+	}
+	if !resourceMatches(scope.Resource, ctx.Hostname) {
+		return false
+	}
+	return constraintMatches(scope.Action, action, ctx.Action != "")
+}
+
+// resourceMatches checks the resource scope constraint against the request
+// hostname, ignoring case. Hosts are in their parent domains, so
+// resource in Host::"openai.com" also matches api.openai.com.
+func resourceMatches(sc ScopeConstraint, hostname string) bool {
+	if sc.Op == ScopeAny {
+		return true
+	}
+	if hostname == "" {
+		return false
+	}
+	host := strings.ToLower(hostname)
+	for _, ref := range sc.Entities {
+		if !entityTypeMatches(ref.Type, resourceEntityType) {
+			continue
+		}
+		id := strings.ToLower(ref.ID)
+		if host == id || sc.Op == ScopeIn && strings.HasSuffix(host, "."+id) {
+			return true
+		}
+	}
+	return false
+}
+
+// constraintMatches checks one scope constraint. Without an entity
+// hierarchy, "in" matches the entity itself or any entity of a list.
+func constraintMatches(sc ScopeConstraint, actual EntityRef, known bool) bool {
+	if sc.Op == ScopeAny {
+		return true
+	}
+	if !known {
+		return false
+	}
+	for _, ref := range sc.Entities {
+		if entityTypeMatches(ref.Type, actual.Type) && ref.ID == actual.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// entityTypeMatches compares entity types, ignoring any namespace prefix on the policy side
+func entityTypeMatches(policyType, actualType string) bool {
+	if i := strings.LastIndex(policyType, "::"); i >= 0 {
+		policyType = policyType[i+2:]
+	}
+	return policyType == actualType
+}
+
+// legacyAction is the placeholder action of older policy files, written in
+// every head before actions were scoped
+const legacyAction = "deploy"
+
+// MigrateLegacyActionScope rewrites policy heads scoped to the placeholder
+// action == Action::"<action>" to an unconstrained action, in place, and
+// returns the number rewritten. Older policy files used Action::"deploy" in
+// every head, before actions were scoped. ParseCedarPolicy and the
+// standalone interceptor apply this rewrite on load with a deprecation
+// warning; write the result back with FormatPolicies to migrate a file once.
+func MigrateLegacyActionScope(policies []*Policy, action string) int {
+	n := 0
+	for _, policy := range policies {
+		sc := policy.Scope.Action
+		if sc.Op == ScopeEq && sc.Slot == "" && len(sc.Entities) == 1 &&
+			sc.Entities[0].ID == action && entityTypeMatches(sc.Entities[0].Type, actionEntityType) {
+			policy.Scope.Action = ScopeConstraint{Op: ScopeAny, Pos: sc.Pos}
+			n++
+		}
+	}
+	return n
+}
+
+// migrateLegacyActionScope applies MigrateLegacyActionScope, logging a
+// deprecation warning if any policy used the placeholder
+func migrateLegacyActionScope(policies []*Policy, action string) {
+	if n := MigrateLegacyActionScope(policies, action); n > 0 {
+		log.Printf("[trusera] WARNING: %d policies are scoped to the deprecated placeholder action == Action::%q, treating it as an unconstrained action; migrate them with MigrateLegacyActionScope", n, action)
+	}
+}
+
+// evalCondition reports whether all clauses of a when or unless block hold
+func evalCondition(cond Condition, ctx RequestContext) (bool, error) {
+	for _, clause := range cond.Clauses {
+		ok, err := evalBool(clause, ctx)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// evalBool evaluates an expression that must produce a boolean
+func evalBool(expr Expr, ctx RequestContext) (bool, error) {
+	v, err := evalExpr(expr, ctx)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, evalErrorf(expr, "expected boolean, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+// evalExpr evaluates an expression to a string, int64, float64, bool,
+// EntityRef, netip.Prefix (ipaddr), []any (set) or map[string]any (record)
+func evalExpr(expr Expr, ctx RequestContext) (any, error) {
+	switch e := expr.(type) {
+	case *LiteralExpr:
+		return e.Value, nil
+
+	case *EntityExpr:
+		return e.Ref, nil
+
+	case *VarExpr:
+		switch {
+		case e.Name == "principal" && ctx.Principal != "":
+			return EntityRef{Type: principalEntityType, ID: ctx.Principal}, nil
+		case e.Name == "action" && ctx.Action != "":
+			return EntityRef{Type: actionEntityType, ID: string(ctx.Action)}, nil
+		}
+		return nil, evalErrorf(e, "%s cannot be used as a value", e.Name)
+
+	case *AttrExpr:
+		return evalAttr(e, ctx)
+
+	case *HasExpr:
+		if v, ok := e.Object.(*VarExpr); ok && v.Name == "resource" {
+			_, ok = resourceAttribute(ctx, e.Attr)
+			return ok, nil
+		}
+		obj, err := evalExpr(e.Object, ctx)
+		if err != nil {
+			return nil, err
+		}
+		rec, ok := obj.(map[string]any)
+		if !ok {
+			return nil, evalErrorf(e, "has expects a record, got %s", typeName(obj))
+		}
+		_, ok = rec[recordKey(e.Object, e.Attr)]
+		return ok, nil
+
+	case *LikeExpr:
+		v, err := evalExpr(e.Operand, ctx)
+		if err != nil {
+			return nil, err
+		}
+		str, ok := v.(string)
+		if !ok {
+			return nil, evalErrorf(e, "like expects a string, got %s", typeName(v))
+		}
+		return matchPattern(str, e.Pattern), nil
+
+	case *SetExpr:
+		set := make([]any, 0, len(e.Elems))
+		for _, elem := range e.Elems {
+			v, err := evalExpr(elem, ctx)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, v)
+		}
+		return set, nil
+
+	case *MethodCallExpr:
+		return evalMethodCall(e, ctx)
+
+	case *CallExpr:
+		return evalCall(e, ctx)
+
+	case *UnaryExpr:
+		b, err := evalBool(e.Operand, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+
+	case *BinaryExpr:
+		switch e.Op {
+		case OpAnd:
+			left, err := evalBool(e.Left, ctx)
+			if err != nil || !left {
+				return false, err
+			}
+			return evalBool(e.Right, ctx)
+
+		case OpOr:
+			left, err := evalBool(e.Left, ctx)
+			if err != nil || left {
+				return left, err
+			}
+			return evalBool(e.Right, ctx)
+		}
+
+		left, err := evalExpr(e.Left, ctx)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalExpr(e.Right, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == OpIn {
+			return evalIn(e, left, right)
+		}
+		return compareValues(e, left, right)
+	}
+
+	return nil, evalErrorf(expr, "unsupported expression")
+}
+
+// evalAttr looks up a resource attribute in the request context, or a field
+// of a record such as resource.header
+func evalAttr(e *AttrExpr, ctx RequestContext) (any, error) {
+	if v, ok := e.Object.(*VarExpr); ok {
+		if v.Name != "resource" {
+			return nil, evalErrorf(e, "unsupported attribute access %s", ExprString(e))
+		}
+		actual, ok := resourceAttribute(ctx, e.Attr)
+		if !ok {
+			return nil, evalErrorf(e, "attribute %q is not set", e.Attr)
+		}
+		return actual, nil
+	}
+
+	obj, err := evalExpr(e.Object, ctx)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := obj.(map[string]any)
+	if !ok {
+		return nil, evalErrorf(e, "cannot access attribute %q of %s", e.Attr, typeName(obj))
+	}
+	actual, ok := rec[recordKey(e.Object, e.Attr)]
+	if !ok {
+		return nil, evalErrorf(e, "record has no attribute %q", e.Attr)
+	}
+	return actual, nil
+}
+
+// recordKey returns the key attr is stored under in the record obj. The
+// resource.header record is keyed by lowercase name, as header names are
+// case-insensitive.
+func recordKey(obj Expr, attr string) string {
+	if isResourceAttr(obj, "header") {
+		return strings.ToLower(attr)
+	}
+	return attr
+}
+
+// resourceAttribute returns the value of a resource attribute and whether it is set
+func resourceAttribute(ctx RequestContext, name string) (any, bool) {
+	switch name {
+	case "ip":
+		if !ctx.IP.IsValid() {
+			return nil, false
+		}
+		return addrPrefix(ctx.IP), true
+	case "port":
+		return int64(ctx.Port), ctx.Port > 0
+	case "body_size":
+		return ctx.ContentLength, ctx.ContentLength >= 0
+	case "header":
+		rec := make(map[string]any, len(ctx.Headers))
+		for name, values := range ctx.Headers {
+			rec[strings.ToLower(name)] = strings.Join(values, ", ")
+		}
+		return rec, true
+	case "query":
+		rec := make(map[string]any, len(ctx.Query))
+		for name, values := range ctx.Query {
+			if len(values) > 0 {
+				rec[name] = values[0]
+			}
+		}
+		return rec, true
+	}
+	actual := getFieldValue(ctx, name)
+	return actual, actual != ""
+}
+
+// evalIn tests set membership, or entity equality when the right side is an
+// entity (there is no entity hierarchy to walk)
+func evalIn(e *BinaryExpr, left, right any) (bool, error) {
+	switch r := right.(type) {
+	case []any:
+		return setContains(r, left), nil
+	case EntityRef:
+		if _, ok := left.(EntityRef); ok {
+			return valuesEqual(left, r), nil
+		}
+	}
+	return false, evalErrorf(e, "in expects a set or entity on the right, got %s", typeName(right))
+}
+
+// evalMethodCall evaluates the set methods contains, containsAll and
+// containsAny, and the ipaddr methods
+func evalMethodCall(e *MethodCallExpr, ctx RequestContext) (any, error) {
+	recv, err := evalExpr(e.Receiver, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ip, ok := recv.(netip.Prefix); ok {
+		return evalIPMethod(e, ip, ctx)
+	}
+
+	set, ok := recv.([]any)
+	if !ok {
+		return nil, evalErrorf(e, "%s expects a set receiver, got %s", e.Method, typeName(recv))
+	}
+	if len(e.Args) != 1 {
+		return nil, evalErrorf(e, "%s is not defined for set", e.Method)
+	}
+	arg, err := evalExpr(e.Args[0], ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Method {
+	case "contains":
+		return setContains(set, arg), nil
+	case "containsAll", "containsAny":
+		other, ok := arg.([]any)
+		if !ok {
+			return nil, evalErrorf(e, "%s expects a set argument, got %s", e.Method, typeName(arg))
+		}
+		wantAll := e.Method == "containsAll"
+		for _, v := range other {
+			if setContains(set, v) != wantAll {
+				return !wantAll, nil
+			}
+		}
+		return wantAll, nil
+	}
+	return nil, evalErrorf(e, "unknown method %q", e.Method)
+}
+
+// setContains reports whether any element of set equals v
+func setContains(set []any, v any) bool {
+	for _, elem := range set {
+		if valuesEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches s against like pattern segments separated by '*'
+// wildcards, ignoring case like the other string comparisons
+func matchPattern(s string, segments []string) bool {
+	s = strings.ToLower(s)
+	if len(segments) == 1 {
+		return s == strings.ToLower(segments[0])
+	}
+
+	first := strings.ToLower(segments[0])
+	last := strings.ToLower(segments[len(segments)-1])
+	if !strings.HasPrefix(s, first) {
+		return false
+	}
+	s = s[len(first):]
+	if len(s) < len(last) || !strings.HasSuffix(s, last) {
+		return false
+	}
+	s = s[:len(s)-len(last)]
+
+	for _, seg := range segments[1 : len(segments)-1] {
+		seg = strings.ToLower(seg)
+		i := strings.Index(s, seg)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(seg):]
+	}
+	return true
+}
+
+// compareValues applies a relational operator. Strings compare case-insensitively,
+// and a string compared with a number is parsed as a number.
+func compareValues(e *BinaryExpr, left, right any) (bool, error) {
+	if ln, lok := numericValue(left); lok {
+		if rn, rok := numericValue(right); rok {
+			return compareNumeric(ln, rn, e.Op), nil
+		}
+	}
+
+	switch l := left.(type) {
+	case string:
+		switch r := right.(type) {
+		case string:
+			return compareString(l, r, e.Op), nil
+		case int64, float64:
+			n, err := strconv.ParseFloat(l, 64)
+			if err != nil {
+				return false, evalErrorf(e, "cannot compare %q with number", l)
+			}
+			rn, _ := numericValue(r)
+			return compareNumeric(n, rn, e.Op), nil
+		}
+	case int64, float64:
+		if r, ok := right.(string); ok {
+			n, err := strconv.ParseFloat(r, 64)
+			if err != nil {
+				return false, evalErrorf(e, "cannot compare number with %q", r)
+			}
+			ln, _ := numericValue(l)
+			return compareNumeric(ln, n, e.Op), nil
+		}
+	}
+
+	switch e.Op {
+	case OpEqual:
+		return valuesEqual(left, right), nil
+	case OpNotEqual:
+		return !valuesEqual(left, right), nil
+	}
+	return false, evalErrorf(e, "operator %s is not defined for %s and %s", e.Op, typeName(left), typeName(right))
+}
+
+// valuesEqual compares values of any type; values of different types are never equal
+func valuesEqual(a, b any) bool {
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		return ok && strings.EqualFold(a, b)
+	case EntityRef:
+		b, ok := b.(EntityRef)
+		return ok && a.ID == b.ID && (entityTypeMatches(a.Type, b.Type) || entityTypeMatches(b.Type, a.Type))
+	case bool:
+		b, ok := b.(bool)
+		return ok && a == b
+	case netip.Prefix:
+		b, ok := b.(netip.Prefix)
+		return ok && a == b
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if bv, ok := b[k]; !ok || !valuesEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range a {
+			if !setContains(b, v) {
+				return false
+			}
+		}
+		for _, v := range b {
+			if !setContains(a, v) {
+				return false
+			}
+		}
+		return true
+	}
+	if an, ok := numericValue(a); ok {
+		bn, ok := numericValue(b)
+		return ok && an == bn
+	}
+	return false
+}
+
+// numericValue converts int64 and float64 values to float64
+func numericValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// typeName names a value's Cedar type for error messages
+func typeName(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "long"
+	case float64:
+		return "decimal"
+	case bool:
+		return "bool"
+	case EntityRef:
+		return "entity"
+	case []any:
+		return "set"
+	case netip.Prefix:
+		return "ipaddr"
+	case map[string]any:
+		return "record"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// evalErrorf builds an evaluation error positioned at expr
+func evalErrorf(expr Expr, format string, args ...any) error {
+	return fmt.Errorf("%s: %s", expr.Position(), fmt.Sprintf(format, args...))
+}
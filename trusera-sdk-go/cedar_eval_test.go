@@ -0,0 +1,598 @@
+package trusera
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEvaluatePolicyConjunctiveClauses(t *testing.T) {
+	policy := `
+forbid ( principal, action == Action::"deploy", resource )
+when {
+    resource.method == "POST";
+    resource.hostname == "uploads.example.com";
+};
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		method   string
+		hostname string
+		want     string
+	}{
+		{"POST", "uploads.example.com", "Deny"},
+		{"POST", "api.example.com", "Allow"},
+		{"GET", "uploads.example.com", "Allow"},
+	}
+
+	for _, tt := range tests {
+		decision := EvaluatePolicy(RequestContext{Method: tt.method, Hostname: tt.hostname}, rules)
+		if decision.Decision != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.hostname, tt.want, decision.Decision)
+		}
+	}
+
+	decision := EvaluatePolicy(RequestContext{Method: "POST", Hostname: "uploads.example.com"}, rules)
+	if len(decision.Matched) != 1 || len(decision.Reasons) != 1 {
+		t.Errorf("expected a single match, got %d matches and %d reasons", len(decision.Matched), len(decision.Reasons))
+	}
+}
+
+func TestEvaluatePolicyBooleanOperators(t *testing.T) {
+	policy := `
+forbid ( principal, action, resource )
+when {
+    (resource.method == "POST" || resource.method == "PUT") &&
+    !(resource.hostname == "api.openai.com")
+};
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		method   string
+		hostname string
+		want     string
+	}{
+		{"POST", "pastebin.com", "Deny"},
+		{"PUT", "pastebin.com", "Deny"},
+		{"POST", "api.openai.com", "Allow"},
+		{"GET", "pastebin.com", "Allow"},
+	}
+
+	for _, tt := range tests {
+		decision := EvaluatePolicy(RequestContext{Method: tt.method, Hostname: tt.hostname}, rules)
+		if decision.Decision != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.hostname, tt.want, decision.Decision)
+		}
+	}
+}
+
+func TestEvaluatePolicyOperatorPrecedence(t *testing.T) {
+	// && binds tighter than ||: a || (b && c)
+	policy := `
+forbid ( principal, action, resource )
+when { resource.method == "DELETE" || resource.method == "POST" && resource.path == "/admin" };
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	if got := EvaluatePolicy(RequestContext{Method: "DELETE", Path: "/public"}, rules).Decision; got != "Deny" {
+		t.Errorf("expected DELETE to be denied, got %s", got)
+	}
+	if got := EvaluatePolicy(RequestContext{Method: "POST", Path: "/public"}, rules).Decision; got != "Allow" {
+		t.Errorf("expected POST to /public to be allowed, got %s", got)
+	}
+}
+
+func TestEvaluatePolicyErrorSkipsPolicy(t *testing.T) {
+	// A missing attribute is an evaluation error, so negation must not make the policy apply
+	policy := `
+forbid ( principal, action, resource )
+when { !(resource.path == "/health") };
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	decision := EvaluatePolicy(RequestContext{Method: "GET", Hostname: "example.com"}, rules)
+	if decision.Decision != "Allow" {
+		t.Errorf("expected Allow when attribute is missing, got %s", decision.Decision)
+	}
+}
+
+func TestEvaluatePolicyNonBooleanCondition(t *testing.T) {
+	rules, err := ParseCedarPolicy(`forbid (principal, action, resource) when { resource.method };`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	decision := EvaluatePolicy(RequestContext{Method: "GET"}, rules)
+	if decision.Decision != "Allow" {
+		t.Errorf("expected non-boolean condition not to apply, got %s", decision.Decision)
+	}
+}
+
+func TestExprString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`resource.method == "POST"`, `resource.method == "POST"`},
+		{`(resource.a == 1 || resource.b == 2) && resource.c == 3`, `(resource.a == 1 || resource.b == 2) && resource.c == 3`},
+		{`resource.a == 1 || (resource.b == 2 && resource.c == 3)`, `resource.a == 1 || resource.b == 2 && resource.c == 3`},
+		{`!(resource.x != "y")`, `!(resource.x != "y")`},
+		{`resource["content-type"] == "a\"b"`, `resource["content-type"] == "a\"b"`},
+		{`resource.score >= 75.5`, `resource.score >= 75.5`},
+		{`resource.hostname like "*.openai.com"`, `resource.hostname like "*.openai.com"`},
+		{`resource.path like "/a\*b*"`, `resource.path like "/a\*b*"`},
+		{`resource has "content-type"`, `resource has "content-type"`},
+		{`resource.method in ["GET","HEAD"]`, `resource.method in ["GET", "HEAD"]`},
+		{`["a"].containsAny([resource.path])`, `["a"].containsAny([resource.path])`},
+	}
+
+	for _, tt := range tests {
+		policies, err := ParsePolicies("permit (principal, action, resource) when { " + tt.in + " };")
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.in, err)
+		}
+		got := ExprString(policies[0].Conditions[0].Clauses[0])
+		if got != tt.want {
+			t.Errorf("ExprString(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluatePolicyUnless(t *testing.T) {
+	policy := `
+forbid ( principal, action, resource )
+when { resource.method == "POST" }
+unless { resource.hostname == "api.openai.com" || resource.hostname == "api.anthropic.com" };
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		method   string
+		hostname string
+		want     string
+	}{
+		{"POST", "pastebin.com", "Deny"},
+		{"POST", "api.openai.com", "Allow"},
+		{"POST", "api.anthropic.com", "Allow"},
+		{"GET", "pastebin.com", "Allow"},
+	}
+
+	for _, tt := range tests {
+		decision := EvaluatePolicy(RequestContext{Method: tt.method, Hostname: tt.hostname}, rules)
+		if decision.Decision != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.hostname, tt.want, decision.Decision)
+		}
+	}
+}
+
+func TestEvaluatePolicyDefaultDeny(t *testing.T) {
+	policy := `
+permit ( principal, action, resource )
+when { resource.hostname == "api.openai.com" };
+
+forbid ( principal, action, resource )
+when { resource.method == "DELETE" };
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	deny := WithDefaultDecision(DecisionDeny)
+
+	decision := EvaluatePolicy(RequestContext{Method: "GET", Hostname: "pastebin.com"}, rules, deny)
+	if decision.Decision != DecisionDeny {
+		t.Errorf("expected unmatched request to be denied, got %s", decision.Decision)
+	}
+	if len(decision.Reasons) == 0 || decision.Reasons[0] != "No matching permit policy (default deny)" {
+		t.Errorf("unexpected reasons: %v", decision.Reasons)
+	}
+
+	if got := EvaluatePolicy(RequestContext{Method: "GET", Hostname: "api.openai.com"}, rules, deny).Decision; got != DecisionAllow {
+		t.Errorf("expected permitted host to be allowed, got %s", got)
+	}
+
+	if got := EvaluatePolicy(RequestContext{Method: "DELETE", Hostname: "api.openai.com"}, rules, deny).Decision; got != DecisionDeny {
+		t.Errorf("expected forbid to override permit, got %s", got)
+	}
+
+	if got := EvaluatePolicy(RequestContext{Method: "GET", Hostname: "pastebin.com"}, rules).Decision; got != DecisionAllow {
+		t.Errorf("expected default allow without option, got %s", got)
+	}
+}
+
+func TestEvaluatePolicyPrincipalAndActionScope(t *testing.T) {
+	policy := `
+forbid (principal == Agent::"billing-bot", action == Action::"http_request", resource)
+when { resource.hostname == "api.openai.com" };
+
+forbid (principal, action in [Action::"tool_call", Action::"file_write"], resource);
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal string
+		action    EventType
+		want      string
+	}{
+		{"scoped principal and action", "billing-bot", EventHTTPRequest, "Deny"},
+		{"other principal", "support-bot", EventHTTPRequest, "Allow"},
+		{"unknown principal", "", EventHTTPRequest, "Allow"},
+		{"other action", "billing-bot", EventLLMInvoke, "Allow"},
+		{"action list tool call", "support-bot", EventToolCall, "Deny"},
+		{"action list file write", "", EventFileWrite, "Deny"},
+	}
+
+	for _, tt := range tests {
+		ctx := RequestContext{Hostname: "api.openai.com", Principal: tt.principal, Action: tt.action}
+		if got := EvaluatePolicy(ctx, rules).Decision; got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEvaluatePolicyResourceScope(t *testing.T) {
+	policy := `
+forbid (principal, action, resource == Host::"Pay.Example.com");
+
+permit (principal, action, resource in Trusera::Host::"example.com");
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{"pay.example.com", "Deny"},
+		{"example.com", "Allow"},
+		{"api.EXAMPLE.com", "Allow"},
+		{"notexample.com", "Deny"},
+		{"example.com.evil.test", "Deny"},
+		{"", "Deny"},
+	}
+
+	for _, tt := range tests {
+		ctx := RequestContext{Hostname: tt.hostname}
+		if got := EvaluatePolicy(ctx, rules, WithDefaultDecision(DecisionDeny)).Decision; got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.hostname, tt.want, got)
+		}
+	}
+}
+
+func TestEvaluatePolicyLegacyDeployAction(t *testing.T) {
+	// Action::"deploy" predates action scoping and applies to every action
+	policy := `forbid (principal, action == Action::"deploy", resource) when { resource.method == "DELETE" };`
+	rules, err := ParseCedarPolicy(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	for _, action := range []EventType{"", EventHTTPRequest, EventToolCall} {
+		ctx := RequestContext{Method: "DELETE", Action: action}
+		if got := EvaluatePolicy(ctx, rules).Decision; got != "Deny" {
+			t.Errorf("action %q: expected Deny, got %s", action, got)
+		}
+	}
+
+	// ParsePolicies keeps the head as written, for migrating the file
+	policies, err := ParsePolicies(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	if n := MigrateLegacyActionScope(policies, "deploy"); n != 1 {
+		t.Fatalf("expected 1 policy migrated, got %d", n)
+	}
+	if got := FormatPolicies(policies); !strings.Contains(got, "forbid (principal, action, resource)") {
+		t.Errorf("expected an unconstrained action after migration, got %q", got)
+	}
+}
+
+func TestEvaluatePolicyPrincipalInCondition(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { principal == Agent::"billing-bot" && action == Action::"llm_invoke" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	ctx := RequestContext{Principal: "billing-bot", Action: EventLLMInvoke}
+	if got := EvaluatePolicy(ctx, rules).Decision; got != "Deny" {
+		t.Errorf("expected Deny, got %s", got)
+	}
+
+	ctx.Principal = "support-bot"
+	if got := EvaluatePolicy(ctx, rules).Decision; got != "Allow" {
+		t.Errorf("expected Allow, got %s", got)
+	}
+}
+
+func TestEvaluatePolicyLike(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { resource.hostname like "*.openai.com" || resource.path like "/v*/admin/*" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		hostname string
+		path     string
+		want     string
+	}{
+		{"api.openai.com", "/v1/chat", "Deny"},
+		{"API.OpenAI.com", "/v1/chat", "Deny"},
+		{"openai.com", "/v1/chat", "Allow"},
+		{"api.openai.com.evil.io", "/v1/chat", "Allow"},
+		{"example.com", "/v2/admin/users", "Deny"},
+		{"example.com", "/admin/users", "Allow"},
+	}
+
+	for _, tt := range tests {
+		ctx := RequestContext{Hostname: tt.hostname, Path: tt.path}
+		if got := EvaluatePolicy(ctx, rules).Decision; got != tt.want {
+			t.Errorf("%s%s: expected %s, got %s", tt.hostname, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		s       string
+		pattern string
+		want    bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "a*", true},
+		{"abc", "*c", true},
+		{"abc", "a*b*c", true},
+		{"abc", "*", true},
+		{"", "*", true},
+		{"a", "a*a", false},
+		{"aXa", "a*a", true},
+		{"a*c", `a\*c`, true},
+		{"abc", `a\*c`, false},
+	}
+
+	for _, tt := range tests {
+		toks, err := tokenize(`"` + tt.pattern + `"`)
+		if err != nil {
+			t.Fatalf("tokenize(%q) failed: %v", tt.pattern, err)
+		}
+		if got := matchPattern(tt.s, toks[0].pattern); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.s, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluatePolicySets(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { resource.hostname in ["pastebin.com", "transfer.sh", "api.deepseek.com"] };
+
+forbid (principal, action, resource)
+when { ["DELETE", "PATCH"].contains(resource.method) };
+
+forbid (principal, action, resource)
+when {
+    ["GET", "HEAD", "OPTIONS"].containsAll([resource.method, "HEAD"]) &&
+    [resource.path, resource.hostname].containsAny(["/debug", "debug.internal"])
+};
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  RequestContext
+		want string
+	}{
+		{"blocked host", RequestContext{Method: "GET", Hostname: "Transfer.sh", Path: "/"}, "Deny"},
+		{"allowed host", RequestContext{Method: "GET", Hostname: "api.openai.com", Path: "/"}, "Allow"},
+		{"blocked method", RequestContext{Method: "PATCH", Hostname: "api.openai.com", Path: "/"}, "Deny"},
+		{"debug path", RequestContext{Method: "GET", Hostname: "api.openai.com", Path: "/debug"}, "Deny"},
+		{"debug host", RequestContext{Method: "HEAD", Hostname: "debug.internal", Path: "/"}, "Deny"},
+		{"debug path via POST", RequestContext{Method: "POST", Hostname: "api.openai.com", Path: "/debug"}, "Allow"},
+	}
+
+	for _, tt := range tests {
+		if got := EvaluatePolicy(tt.ctx, rules).Decision; got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEvaluatePolicyHas(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { resource has path && resource.path like "/internal/*" };
+
+forbid (principal, action, resource)
+unless { resource has "hostname" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	if got := EvaluatePolicy(RequestContext{Hostname: "example.com"}, rules).Decision; got != "Allow" {
+		t.Errorf("expected request without path to be allowed, got %s", got)
+	}
+	if got := EvaluatePolicy(RequestContext{Hostname: "example.com", Path: "/internal/x"}, rules).Decision; got != "Deny" {
+		t.Errorf("expected internal path to be denied, got %s", got)
+	}
+	if got := EvaluatePolicy(RequestContext{Path: "/"}, rules).Decision; got != "Deny" {
+		t.Errorf("expected request without hostname to be denied, got %s", got)
+	}
+}
+
+func TestEvaluatePolicyRequestAttributes(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when {
+    resource.method == "POST" &&
+    resource.body_size > 1048576 &&
+    !(resource.hostname in ["api.openai.com", "api.anthropic.com"])
+};
+
+forbid (principal, action, resource)
+when { resource.header has "x-debug" };
+
+forbid (principal, action, resource)
+when { resource.query has token && resource.scheme != "https" };
+
+forbid (principal, action, resource)
+when { resource.port != 443 && resource.header.authorization like "Bearer *" };
+
+forbid (principal, action, resource)
+when { resource.content_type == "multipart/form-data" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	base := func() RequestContext {
+		return RequestContext{
+			Method:   "POST",
+			Hostname: "example.com",
+			Scheme:   "https",
+			Port:     443,
+			Headers:  http.Header{"Content-Type": {"application/json"}},
+			Query:    url.Values{},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*RequestContext)
+		want   string
+	}{
+		{"small body", func(c *RequestContext) { c.ContentLength = 512 }, "Allow"},
+		{"large body", func(c *RequestContext) { c.ContentLength = 2 << 20 }, "Deny"},
+		{"large body to allowlisted host", func(c *RequestContext) { c.ContentLength = 2 << 20; c.Hostname = "api.openai.com" }, "Allow"},
+		{"unknown body size", func(c *RequestContext) { c.ContentLength = -1 }, "Allow"},
+		{"debug header", func(c *RequestContext) { c.Headers.Set("X-Debug", "1") }, "Deny"},
+		{"token over https", func(c *RequestContext) { c.Query.Set("token", "abc") }, "Allow"},
+		{"token over http", func(c *RequestContext) { c.Query.Set("token", "abc"); c.Scheme = "http"; c.Port = 80 }, "Deny"},
+		{"bearer on 443", func(c *RequestContext) { c.Headers.Set("Authorization", "Bearer x") }, "Allow"},
+		{"bearer on 8443", func(c *RequestContext) { c.Headers.Set("Authorization", "Bearer x"); c.Port = 8443 }, "Deny"},
+		{"multipart upload", func(c *RequestContext) { c.ContentType = "multipart/form-data" }, "Deny"},
+	}
+
+	for _, tt := range tests {
+		ctx := base()
+		tt.modify(&ctx)
+		if got := EvaluatePolicy(ctx, rules).Decision; got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEvaluatePolicyHeaderNamesIgnoreCase(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+@id("debug")
+forbid (principal, action, resource) when { resource.header["X-Debug"] == "1" };
+
+@id("trace")
+forbid (principal, action, resource) when { resource.header has "X-Trace-ID" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	compiled := CompilePolicy(rules)
+
+	for header, want := range map[string]string{"X-Debug": "debug", "x-trace-id": "trace", "X-Other": ""} {
+		ctx := RequestContext{Headers: http.Header{}}
+		ctx.Headers.Set(header, "1")
+		for name, decision := range map[string]PolicyDecision{
+			"EvaluatePolicy": EvaluatePolicy(ctx, rules),
+			"CompilePolicy":  compiled.Evaluate(ctx),
+		} {
+			got := strings.Join(decision.PolicyIDs, ",")
+			if got != want {
+				t.Errorf("%s, header %s: expected policies %q, got %q", name, header, want, got)
+			}
+		}
+	}
+}
+
+func TestEvaluatePolicyRecordErrors(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { resource.header["x-missing"] == "1" };
+
+forbid (principal, action, resource)
+when { resource.method.length == 3 };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	// Missing record fields and attribute access on strings make the policy not apply
+	ctx := RequestContext{Method: "GET", Hostname: "example.com"}
+	if got := EvaluatePolicy(ctx, rules).Decision; got != "Allow" {
+		t.Errorf("expected Allow, got %s", got)
+	}
+}
+
+func TestEvaluatePolicyReportsIDs(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+@id("no-delete")
+@enforcement("warn")
+forbid (principal, action, resource) when { resource.method == "DELETE" };
+
+forbid (principal, action, resource) when { resource.path like "/admin*" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	if rules[0].ID != "no-delete" || rules[0].Annotations["enforcement"] != "warn" {
+		t.Errorf("unexpected rule metadata: id=%q annotations=%v", rules[0].ID, rules[0].Annotations)
+	}
+
+	decision := EvaluatePolicy(RequestContext{Method: "DELETE", Hostname: "example.com", Path: "/admin/users"}, rules)
+	if len(decision.PolicyIDs) != 2 || decision.PolicyIDs[0] != "no-delete" || decision.PolicyIDs[1] != "policy1" {
+		t.Errorf("unexpected policy IDs: %v", decision.PolicyIDs)
+	}
+	if len(decision.Rules) != 2 {
+		t.Errorf("expected 2 matched rules, got %d", len(decision.Rules))
+	}
+	if !strings.HasPrefix(decision.Reasons[0], "[no-delete] forbid: ") {
+		t.Errorf("expected reason prefixed with policy id, got %q", decision.Reasons[0])
+	}
+}
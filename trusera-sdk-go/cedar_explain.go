@@ -0,0 +1,399 @@
+package trusera
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Explanation traces how a policy set reached its decision: for every rule,
+// whether its scope matched and how each condition evaluated. It renders as
+// text with String and as JSON with encoding/json.
+type Explanation struct {
+	Decision  string      `json:"decision"`
+	Reasons   []string    `json:"reasons"`
+	PolicyIDs []string    `json:"policy_ids,omitempty"`
+	Default   bool        `json:"default,omitempty"` // no rule matched, so the default decision applied
+	Rules     []RuleTrace `json:"rules"`
+}
+
+// RuleTrace traces the evaluation of one rule
+type RuleTrace struct {
+	PolicyID     string           `json:"policy_id"`
+	Effect       PolicyAction     `json:"effect"`
+	Line         int              `json:"line,omitempty"`
+	Matched      bool             `json:"matched"`
+	ScopeMatched bool             `json:"scope_matched"`
+	ScopeNote    string           `json:"scope_note,omitempty"` // why the policy head did not match
+	Conditions   []ConditionTrace `json:"conditions,omitempty"`
+}
+
+// ConditionTrace traces one when or unless block. Blocks after the one that
+// decided the rule are listed with Evaluated false.
+type ConditionTrace struct {
+	Kind      ConditionKind `json:"kind"`
+	Evaluated bool          `json:"evaluated"`
+	Holds     bool          `json:"holds"`
+	Error     string        `json:"error,omitempty"`
+	Clauses   []*ExprTrace  `json:"clauses,omitempty"`
+}
+
+// ExprTrace traces one subexpression: its value, or the error that stopped
+// evaluation, and the traces of the operands evaluated to produce it.
+// Literal operands are not traced.
+type ExprTrace struct {
+	Expr     string       `json:"expr"`
+	Value    string       `json:"value,omitempty"` // result in Cedar syntax
+	Error    string       `json:"error,omitempty"`
+	Note     string       `json:"note,omitempty"` // short-circuits and string to number conversions
+	Children []*ExprTrace `json:"children,omitempty"`
+}
+
+// Explain evaluates a request context like EvaluatePolicy and returns a trace
+// of the evaluation. It is much slower than EvaluatePolicy and meant for
+// debugging unexpected decisions.
+func Explain(ctx RequestContext, rules []PolicyRule, opts ...EvalOption) *Explanation {
+	decision := EvaluatePolicy(ctx, rules, opts...)
+	x := &Explanation{
+		Decision:  decision.Decision,
+		Reasons:   decision.Reasons,
+		PolicyIDs: decision.PolicyIDs,
+		Default:   len(decision.Rules) == 0,
+		Rules:     make([]RuleTrace, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		x.Rules = append(x.Rules, explainRule(rule, ctx))
+	}
+	return x
+}
+
+// explainRule traces one rule, mirroring ruleMatches
+func explainRule(rule PolicyRule, ctx RequestContext) RuleTrace {
+	trace := RuleTrace{
+		PolicyID: rule.ID,
+		Effect:   rule.Action,
+		Matched:  ruleMatches(rule, ctx),
+	}
+
+	if rule.Policy == nil {
+		trace.ScopeMatched = true
+		if rule.Field != "" {
+			clause := explainLegacyCondition(rule, ctx)
+			trace.Conditions = []ConditionTrace{{
+				Kind:      ConditionWhen,
+				Evaluated: true,
+				Holds:     trace.Matched,
+				Clauses:   []*ExprTrace{clause},
+			}}
+		}
+		return trace
+	}
+
+	policy := rule.Policy
+	trace.Line = policy.Pos.Line
+	trace.ScopeNote = scopeMismatch(policy.Scope, ctx)
+	trace.ScopeMatched = trace.ScopeNote == ""
+
+	decided := !trace.ScopeMatched
+	for _, cond := range policy.Conditions {
+		ct := ConditionTrace{Kind: cond.Kind}
+		if !decided {
+			ct = explainCondition(cond, ctx)
+			decided = ct.Error != "" || ct.Holds == (cond.Kind == ConditionUnless)
+		}
+		trace.Conditions = append(trace.Conditions, ct)
+	}
+	return trace
+}
+
+// scopeMismatch describes why the request falls outside a policy head, or
+// returns "" when it is within it
+func scopeMismatch(scope PolicyScope, ctx RequestContext) string {
+	principal := EntityRef{Type: principalEntityType, ID: ctx.Principal}
+	if !constraintMatches(scope.Principal, principal, ctx.Principal != "") {
+		return constraintMismatch("principal", principal, ctx.Principal != "")
+	}
+	if !resourceMatches(scope.Resource, ctx.Hostname) {
+		resource := EntityRef{Type: resourceEntityType, ID: ctx.Hostname}
+		return constraintMismatch("resource", resource, ctx.Hostname != "")
+	}
+	action := EntityRef{Type: actionEntityType, ID: string(ctx.Action)}
+	if !constraintMatches(scope.Action, action, ctx.Action != "") {
+		return constraintMismatch("action", action, ctx.Action != "")
+	}
+	return ""
+}
+
+// constraintMismatch describes a scope variable that did not match its constraint
+func constraintMismatch(name string, actual EntityRef, known bool) string {
+	if !known {
+		return name + " is not set"
+	}
+	return fmt.Sprintf("%s %s is outside the policy scope", name, actual)
+}
+
+// explainCondition traces a when or unless block, mirroring evalCondition
+func explainCondition(cond Condition, ctx RequestContext) ConditionTrace {
+	ct := ConditionTrace{Kind: cond.Kind, Evaluated: true, Holds: true}
+	for _, clause := range cond.Clauses {
+		trace, v, err := explainExpr(clause, ctx)
+		ct.Clauses = append(ct.Clauses, trace)
+		if err == nil {
+			if b, ok := v.(bool); ok {
+				if b {
+					continue
+				}
+			} else {
+				err = evalErrorf(clause, "expected boolean, got %s", typeName(v))
+				trace.Error = err.Error()
+			}
+		}
+		ct.Holds = false
+		if err != nil {
+			ct.Error = err.Error()
+		}
+		break
+	}
+	return ct
+}
+
+// explainExpr traces an expression and returns its value. The operands are
+// traced in evaluation order, stopping where evaluation stops; the value
+// itself comes from evalExpr so the trace cannot disagree with evaluation.
+func explainExpr(expr Expr, ctx RequestContext) (*ExprTrace, any, error) {
+	trace := &ExprTrace{Expr: ExprString(expr)}
+
+	bin, _ := expr.(*BinaryExpr)
+	var operandValues []any
+	for _, operand := range exprOperands(expr) {
+		var (
+			v   any
+			err error
+		)
+		switch operand.(type) {
+		case *LiteralExpr, *EntityExpr:
+			v, err = evalExpr(operand, ctx)
+		default:
+			var child *ExprTrace
+			child, v, err = explainExpr(operand, ctx)
+			trace.Children = append(trace.Children, child)
+		}
+		if err != nil {
+			break
+		}
+		operandValues = append(operandValues, v)
+
+		if bin != nil && operand == bin.Left && (bin.Op == OpAnd || bin.Op == OpOr) {
+			if b, ok := v.(bool); ok && b == (bin.Op == OpOr) {
+				trace.Note = fmt.Sprintf("short-circuit: left operand is %t, right operand not evaluated", b)
+				break
+			}
+		}
+	}
+
+	if bin != nil && len(operandValues) == 2 && isRelational(bin.Op) {
+		trace.Note = conversionNote(operandValues[0], operandValues[1])
+	}
+
+	v, err := evalExpr(expr, ctx)
+	if err != nil {
+		trace.Error = err.Error()
+		return trace, nil, err
+	}
+	trace.Value = valueString(v)
+	return trace, v, nil
+}
+
+// exprOperands lists the subexpressions evalExpr evaluates for an expression,
+// in order. Attribute lookups on resource read the request context directly.
+func exprOperands(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *AttrExpr:
+		if _, ok := e.Object.(*VarExpr); ok {
+			return nil
+		}
+		return []Expr{e.Object}
+	case *HasExpr:
+		if v, ok := e.Object.(*VarExpr); ok && v.Name == "resource" {
+			return nil
+		}
+		return []Expr{e.Object}
+	case *LikeExpr:
+		return []Expr{e.Operand}
+	case *SetExpr:
+		return e.Elems
+	case *MethodCallExpr:
+		return append([]Expr{e.Receiver}, e.Args...)
+	case *CallExpr:
+		return e.Args
+	case *UnaryExpr:
+		return []Expr{e.Operand}
+	case *BinaryExpr:
+		return []Expr{e.Left, e.Right}
+	}
+	return nil
+}
+
+// isRelational reports whether op is a comparison handled by compareValues
+func isRelational(op PolicyOperator) bool {
+	switch op {
+	case OpEqual, OpNotEqual, OpLessThan, OpLessThanOrEqual, OpGreaterThan, OpGreaterThanOrEqual:
+		return true
+	}
+	return false
+}
+
+// conversionNote describes the implicit conversions compareValues applies
+// to a pair of operands
+func conversionNote(left, right any) string {
+	_, lnum := numericValue(left)
+	_, rnum := numericValue(right)
+	ls, lstr := left.(string)
+	rs, rstr := right.(string)
+	switch {
+	case lstr && rnum:
+		return fmt.Sprintf("string %s compared as a number", quoteString(ls))
+	case lnum && rstr:
+		return fmt.Sprintf("string %s compared as a number", quoteString(rs))
+	}
+	return ""
+}
+
+// explainLegacyCondition traces the Field/Operator/Value condition of a
+// hand-built rule, mirroring evaluateCondition
+func explainLegacyCondition(rule PolicyRule, ctx RequestContext) *ExprTrace {
+	trace := &ExprTrace{
+		Expr:  fmt.Sprintf("resource.%s %s %s", rule.Field, rule.Operator, literalString(rule.Value)),
+		Value: strconv.FormatBool(evaluateCondition(rule, ctx)),
+	}
+	lookup := &ExprTrace{Expr: "resource." + rule.Field}
+	trace.Children = []*ExprTrace{lookup}
+
+	actual := getFieldValue(ctx, rule.Field)
+	if actual == "" {
+		lookup.Error = fmt.Sprintf("attribute %q is not set", rule.Field)
+		return trace
+	}
+	lookup.Value = quoteString(actual)
+
+	switch rule.Value.(type) {
+	case int, float64:
+		if _, err := strconv.ParseFloat(actual, 64); err != nil {
+			trace.Note = fmt.Sprintf("actual value %s is not a number", quoteString(actual))
+		} else {
+			trace.Note = "compared as numbers"
+		}
+	case string:
+	default:
+		trace.Note = fmt.Sprintf("unsupported rule value type %T", rule.Value)
+	}
+	return trace
+}
+
+// valueString renders an evaluated value in Cedar syntax
+func valueString(v any) string {
+	switch v := v.(type) {
+	case EntityRef:
+		return v.String()
+	case netip.Prefix:
+		if v.IsSingleIP() {
+			return `ip("` + v.Addr().String() + `")`
+		}
+		return `ip("` + v.String() + `")`
+	case []any:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			elems[i] = valueString(elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			name := k
+			if !isIdentifier(k) {
+				name = quoteString(k)
+			}
+			fields[i] = name + ": " + valueString(v[k])
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return literalString(v)
+}
+
+// String renders the explanation as indented text
+func (x *Explanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "decision: %s", x.Decision)
+	if x.Default {
+		sb.WriteString(" (default)")
+	}
+	sb.WriteByte('\n')
+	for _, reason := range x.Reasons {
+		fmt.Fprintf(&sb, "reason: %s\n", reason)
+	}
+
+	for _, rule := range x.Rules {
+		status := "not matched"
+		if rule.Matched {
+			status = "matched"
+		}
+		fmt.Fprintf(&sb, "%s %s", rule.Effect, rule.PolicyID)
+		if rule.Line > 0 {
+			fmt.Fprintf(&sb, " (line %d)", rule.Line)
+		}
+		fmt.Fprintf(&sb, ": %s\n", status)
+
+		if !rule.ScopeMatched {
+			fmt.Fprintf(&sb, "  scope: %s\n", rule.ScopeNote)
+		}
+		for _, cond := range rule.Conditions {
+			switch {
+			case !cond.Evaluated:
+				fmt.Fprintf(&sb, "  %s: not evaluated\n", cond.Kind)
+			case cond.Error != "":
+				fmt.Fprintf(&sb, "  %s: error\n", cond.Kind)
+			default:
+				fmt.Fprintf(&sb, "  %s: %t\n", cond.Kind, cond.Holds)
+			}
+			for _, clause := range cond.Clauses {
+				writeExprTrace(&sb, clause, 2)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// writeExprTrace renders an expression trace and its operands, one per line
+func writeExprTrace(sb *strings.Builder, trace *ExprTrace, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(trace.Expr)
+	if trace.Error != "" {
+		sb.WriteString(" => error: ")
+		sb.WriteString(trace.Error)
+	} else {
+		sb.WriteString(" => ")
+		sb.WriteString(trace.Value)
+	}
+	if trace.Note != "" {
+		sb.WriteString(" (")
+		sb.WriteString(trace.Note)
+		sb.WriteByte(')')
+	}
+	sb.WriteByte('\n')
+	for _, child := range trace.Children {
+		writeExprTrace(sb, child, depth+1)
+	}
+}
+
+// JSON renders the explanation as indented JSON
+func (x *Explanation) JSON() ([]byte, error) {
+	return json.MarshalIndent(x, "", "  ")
+}
@@ -0,0 +1,154 @@
+package trusera
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+@id("allow-api")
+permit (principal, action, resource)
+when { resource.hostname == "api.example.com" && resource.port < "1024" };
+
+@id("billing-only")
+forbid (principal == Agent::"billing-bot", action, resource);
+
+@id("no-large-uploads")
+forbid (principal, action, resource)
+when { resource.method == "POST" || resource.body_size > 1000 }
+unless { resource.header has authorization };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	ctx := RequestContext{
+		Method:        "POST",
+		Hostname:      "api.example.com",
+		Principal:     "support-bot",
+		Action:        EventHTTPRequest,
+		Port:          8443,
+		ContentLength: -1,
+	}
+	x := Explain(ctx, rules)
+
+	want := EvaluatePolicy(ctx, rules)
+	if x.Decision != want.Decision || strings.Join(x.Reasons, ";") != strings.Join(want.Reasons, ";") {
+		t.Errorf("explanation disagrees with EvaluatePolicy: %s vs %s", x.Decision, want.Decision)
+	}
+	if len(x.Rules) != 3 {
+		t.Fatalf("expected a trace per rule, got %d", len(x.Rules))
+	}
+
+	allow := x.Rules[0]
+	if allow.Matched || allow.Line != 3 {
+		t.Errorf("unexpected permit trace: %+v", allow)
+	}
+	and := allow.Conditions[0].Clauses[0]
+	if and.Value != "false" || len(and.Children) != 2 {
+		t.Fatalf("expected && to evaluate both operands, got %+v", and)
+	}
+	port := and.Children[1]
+	if port.Value != "false" || port.Note != `string "1024" compared as a number` {
+		t.Errorf("expected numeric conversion note, got %+v", port)
+	}
+	if port.Children[0].Expr != "resource.port" || port.Children[0].Value != "8443" {
+		t.Errorf("expected port lookup, got %+v", port.Children[0])
+	}
+
+	billing := x.Rules[1]
+	if billing.ScopeMatched || billing.ScopeNote != `principal Agent::"support-bot" is outside the policy scope` {
+		t.Errorf("unexpected scope trace: %+v", billing)
+	}
+
+	uploads := x.Rules[2]
+	if !uploads.Matched || len(uploads.Conditions) != 2 {
+		t.Fatalf("expected matched forbid with two conditions, got %+v", uploads)
+	}
+	or := uploads.Conditions[0].Clauses[0]
+	if !strings.HasPrefix(or.Note, "short-circuit") || len(or.Children) != 1 {
+		t.Errorf("expected || to short-circuit, got %+v", or)
+	}
+	if unless := uploads.Conditions[1]; !unless.Evaluated || unless.Holds {
+		t.Errorf("expected unless block to be evaluated and false, got %+v", unless)
+	}
+}
+
+func TestExplainErrorsAndSkippedConditions(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when { resource.body_size > 10 }
+when { resource.method == "PUT" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	x := Explain(RequestContext{Method: "PUT", ContentLength: -1}, rules)
+	rule := x.Rules[0]
+	if rule.Matched {
+		t.Error("expected rule not to match")
+	}
+
+	first := rule.Conditions[0]
+	if first.Error == "" || !strings.Contains(first.Clauses[0].Children[0].Error, `attribute "body_size" is not set`) {
+		t.Errorf("expected missing attribute error, got %+v", first)
+	}
+	if rule.Conditions[1].Evaluated {
+		t.Error("expected second when block to be skipped")
+	}
+	if !x.Default || x.Decision != DecisionAllow {
+		t.Errorf("expected default allow, got %+v", x)
+	}
+}
+
+func TestExplainLegacyRule(t *testing.T) {
+	rules := []PolicyRule{{ID: "size", Action: ActionForbid, Field: "hostname", Operator: OpGreaterThan, Value: 10}}
+
+	x := Explain(RequestContext{Hostname: "example.com"}, rules)
+	clause := x.Rules[0].Conditions[0].Clauses[0]
+	if clause.Value != "false" || clause.Note != `actual value "example.com" is not a number` {
+		t.Errorf("expected numeric parse failure note, got %+v", clause)
+	}
+}
+
+func TestExplainRendering(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+@id("no-delete")
+forbid (principal, action, resource)
+when { resource.method in ["DELETE", "PURGE"] && resource.query.force == "true" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	x := Explain(RequestContext{Method: "DELETE", Query: map[string][]string{"force": {"true"}}}, rules)
+
+	text := x.String()
+	for _, line := range []string{
+		"decision: Deny",
+		"forbid no-delete (line 3): matched",
+		"  when: true",
+		`    resource.method in ["DELETE", "PURGE"] && resource.query.force == "true" => true`,
+		`      resource.method => "DELETE"`,
+		`        resource.query => {force: "true"}`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, text)
+		}
+	}
+
+	data, err := x.JSON()
+	if err != nil {
+		t.Fatalf("failed to marshal explanation: %v", err)
+	}
+	var decoded Explanation
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal explanation: %v", err)
+	}
+	if decoded.String() != text {
+		t.Errorf("JSON round trip changed the explanation:\n%s", decoded.String())
+	}
+}
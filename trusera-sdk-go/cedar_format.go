@@ -0,0 +1,278 @@
+package trusera
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FormatPolicy reformats Cedar policy text in the canonical style: one
+// annotation per line, the scope on the effect line as
+// "permit (principal, action, resource)", each when or unless block on its
+// own line and a blank line between policies. Comments are kept; comments
+// inside a policy statement move above it. Formatting is idempotent and the
+// result parses to the same policies.
+func FormatPolicy(policyText string) (string, error) {
+	policies, err := ParsePolicies(policyText)
+	if err != nil {
+		return "", err
+	}
+	comments := scanComments(policyText)
+
+	var sb strings.Builder
+	next := 0     // next comment to write
+	lastLine := 0 // source line of the last policy or comment written
+	endLine := 0  // source line the previous policy ends on
+	for i, policy := range policies {
+		start := policyStart(policy)
+
+		if i > 0 {
+			// A comment after the previous policy on the same line stays there
+			if next < len(comments) && comments[next].pos.Line == endLine && comments[next].pos.Offset < start.Offset {
+				sb.WriteString(" ")
+				sb.WriteString(comments[next].text)
+				next++
+			}
+			sb.WriteByte('\n')
+		}
+
+		wroteComment := false
+		for ; next < len(comments) && comments[next].pos.Offset < start.Offset; next++ {
+			c := comments[next]
+			if lastLine > 0 && c.pos.Line > lastLine+1 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(c.text)
+			sb.WriteByte('\n')
+			lastLine = c.pos.Line
+			wroteComment = true
+		}
+		if lastLine > 0 && (!wroteComment || start.Line > lastLine+1) {
+			sb.WriteByte('\n')
+		}
+
+		end := start.Offset + len(policy.Raw)
+		for ; next < len(comments) && comments[next].pos.Offset < end; next++ {
+			sb.WriteString(comments[next].text)
+			sb.WriteByte('\n')
+		}
+
+		writePolicy(&sb, policy, policy.ID != positionalID(i))
+		endLine = start.Line + strings.Count(policy.Raw, "\n")
+		lastLine = endLine
+	}
+
+	if len(policies) > 0 {
+		if next < len(comments) && comments[next].pos.Line == endLine {
+			sb.WriteString(" ")
+			sb.WriteString(comments[next].text)
+			next++
+		}
+		sb.WriteByte('\n')
+	}
+	for ; next < len(comments); next++ {
+		c := comments[next]
+		if lastLine > 0 && c.pos.Line > lastLine+1 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(c.text)
+		sb.WriteByte('\n')
+		lastLine = c.pos.Line
+	}
+
+	return sb.String(), nil
+}
+
+// FormatPolicies serializes policies as Cedar text in the canonical style of
+// FormatPolicy. A policy whose ID differs from the one ParsePolicies would
+// assign by position gets an @id annotation, so parsing the result gives
+// back the same IDs.
+func FormatPolicies(policies []*Policy) string {
+	var sb strings.Builder
+	for i, policy := range policies {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		writePolicy(&sb, policy, policy.ID != positionalID(i))
+	}
+	if len(policies) > 0 {
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// FormatRules serializes rules, such as those returned by ParseCedarPolicy,
+// as Cedar text. Rules with a parsed Policy are written from it; hand-built
+// rules are written as a "resource.<Field> <Operator> <Value>" condition, or
+// with no condition when Field is empty.
+func FormatRules(rules []PolicyRule) (string, error) {
+	policies := make([]*Policy, len(rules))
+	for i, rule := range rules {
+		policy, err := policyFromRule(rule)
+		if err != nil {
+			return "", fmt.Errorf("rule %d: %w", i, err)
+		}
+		policies[i] = policy
+	}
+	return FormatPolicies(policies), nil
+}
+
+// policyFromRule returns the policy a rule was parsed from, with the rule's
+// ID, or builds one for a hand-built rule
+func policyFromRule(rule PolicyRule) (*Policy, error) {
+	if rule.Policy != nil {
+		if rule.Policy.ID == rule.ID {
+			return rule.Policy, nil
+		}
+		policy := *rule.Policy
+		policy.ID = rule.ID
+		return &policy, nil
+	}
+
+	if rule.Action != ActionPermit && rule.Action != ActionForbid {
+		return nil, fmt.Errorf("invalid action %q", rule.Action)
+	}
+	policy := &Policy{ID: rule.ID, Effect: rule.Action}
+
+	keys := make([]string, 0, len(rule.Annotations))
+	for key := range rule.Annotations {
+		if key != "id" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		policy.Annotations = append(policy.Annotations, Annotation{Key: key, Value: rule.Annotations[key]})
+	}
+
+	if rule.Field == "" {
+		return policy, nil
+	}
+	switch rule.Operator {
+	case OpEqual, OpNotEqual, OpGreaterThan, OpGreaterThanOrEqual, OpLessThan, OpLessThanOrEqual:
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", rule.Operator)
+	}
+	var value any
+	switch v := rule.Value.(type) {
+	case int:
+		value = int64(v)
+	case int64, float64, string:
+		value = v
+	default:
+		return nil, fmt.Errorf("unsupported value %v of type %T", rule.Value, rule.Value)
+	}
+	policy.Conditions = []Condition{{
+		Kind: ConditionWhen,
+		Clauses: []Expr{&BinaryExpr{
+			Op:    rule.Operator,
+			Left:  &AttrExpr{Object: &VarExpr{Name: "resource"}, Attr: rule.Field},
+			Right: &LiteralExpr{Value: value},
+		}},
+	}}
+	return policy, nil
+}
+
+// writePolicy renders one policy statement without a trailing newline. With
+// writeID, the policy's ID is written as @id even if no annotation holds it.
+func writePolicy(sb *strings.Builder, policy *Policy, writeID bool) {
+	if _, ok := policy.Annotation("id"); !ok && writeID && policy.ID != "" {
+		writeAnnotation(sb, Annotation{Key: "id", Value: policy.ID})
+	}
+	for _, ann := range policy.Annotations {
+		if ann.Key == "id" && policy.ID != "" {
+			ann.Value = policy.ID
+		}
+		writeAnnotation(sb, ann)
+	}
+
+	sb.WriteString(string(policy.Effect))
+	sb.WriteString(" (")
+	writeScopeConstraint(sb, "principal", policy.Scope.Principal)
+	sb.WriteString(", ")
+	writeScopeConstraint(sb, "action", policy.Scope.Action)
+	sb.WriteString(", ")
+	writeScopeConstraint(sb, "resource", policy.Scope.Resource)
+	sb.WriteByte(')')
+
+	for _, cond := range policy.Conditions {
+		sb.WriteByte('\n')
+		sb.WriteString(string(cond.Kind))
+		sb.WriteString(" { ")
+		for i, clause := range cond.Clauses {
+			if i > 0 {
+				sb.WriteString("; ")
+			}
+			writeExpr(sb, clause)
+		}
+		sb.WriteString(" }")
+	}
+	sb.WriteByte(';')
+}
+
+// writeAnnotation renders an annotation on its own line
+func writeAnnotation(sb *strings.Builder, ann Annotation) {
+	sb.WriteByte('@')
+	sb.WriteString(ann.Key)
+	if ann.Value != "" {
+		sb.WriteByte('(')
+		sb.WriteString(quoteString(ann.Value))
+		sb.WriteByte(')')
+	}
+	sb.WriteByte('\n')
+}
+
+// writeScopeConstraint renders a scope variable and its constraint. Action
+// in-constraints are always written as lists, which parse the same as a
+// single entity.
+func writeScopeConstraint(sb *strings.Builder, variable string, sc ScopeConstraint) {
+	sb.WriteString(variable)
+	if sc.Op == ScopeAny {
+		return
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(string(sc.Op))
+	sb.WriteByte(' ')
+
+	switch {
+	case sc.Slot != "":
+		sb.WriteString(string(sc.Slot))
+	case sc.Op == ScopeIn && (variable == "action" || len(sc.Entities) != 1):
+		sb.WriteByte('[')
+		for i, ref := range sc.Entities {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(ref.String())
+		}
+		sb.WriteByte(']')
+	case len(sc.Entities) > 0:
+		sb.WriteString(sc.Entities[0].String())
+	}
+}
+
+// positionalID is the ID ParsePolicies gives the i-th policy without @id
+func positionalID(i int) string {
+	return fmt.Sprintf("policy%d", i)
+}
+
+// policyStart is the position of a policy's first annotation, or of its
+// effect keyword, where its Raw text starts
+func policyStart(policy *Policy) Position {
+	if len(policy.Annotations) > 0 {
+		return policy.Annotations[0].Pos
+	}
+	return policy.Pos
+}
+
+// scanComments returns the // comments of policy text that tokenizes
+// without errors
+func scanComments(src string) []comment {
+	lx := newLexer(src)
+	for {
+		tok, err := lx.next()
+		if err != nil || tok.kind == tokEOF {
+			return lx.comments
+		}
+	}
+}
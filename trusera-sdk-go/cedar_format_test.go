@@ -0,0 +1,228 @@
+package trusera
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatPolicy(t *testing.T) {
+	input := `// Egress rules
+
+// Block deletes
+@id( "no-delete" )   @owner("platform")
+forbid( principal,action,resource )when{resource.method=="DELETE"}; // everywhere
+permit  ( principal == Agent::"ops-bot" ,
+  action in Action::"http_request" , resource in Host::"Example.com" )
+when {
+    // read-only
+    resource.method == "GET";
+    resource.port == 443
+}
+unless { (resource.path like "/admin/*") || !(resource.header has "x-token") } ;
+@id("tpl") @draft
+forbid (principal in ?principal, action in [Action::"tool_call",Action::"llm_invoke"], resource == ?resource);
+
+
+// trailing note
+`
+
+	want := `// Egress rules
+
+// Block deletes
+@id("no-delete")
+@owner("platform")
+forbid (principal, action, resource)
+when { resource.method == "DELETE" }; // everywhere
+
+// read-only
+permit (principal == Agent::"ops-bot", action in [Action::"http_request"], resource in Host::"Example.com")
+when { resource.method == "GET"; resource.port == 443 }
+unless { resource.path like "/admin/*" || !(resource.header has "x-token") };
+
+@id("tpl")
+@draft
+forbid (principal in ?principal, action in [Action::"tool_call", Action::"llm_invoke"], resource == ?resource);
+
+// trailing note
+`
+
+	got, err := FormatPolicy(input)
+	if err != nil {
+		t.Fatalf("failed to format policy: %v", err)
+	}
+	if got != want {
+		t.Errorf("unexpected formatting:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatPolicyErrors(t *testing.T) {
+	if _, err := FormatPolicy(`permit (principal, action, resource`); err == nil {
+		t.Error("expected syntax error")
+	}
+}
+
+func TestFormatPolicyIsStable(t *testing.T) {
+	sources := map[string]string{
+		"compile":  compileTestPolicy,
+		"template": templateTestPolicy,
+		"empty":    "",
+		"comments": "// only\n\n\n// comments\n",
+	}
+	if content, err := os.ReadFile("../.cedar/ai-policy.cedar"); err == nil {
+		sources["repository"] = string(content)
+	}
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			once, err := FormatPolicy(src)
+			if err != nil {
+				t.Fatalf("failed to format policy: %v", err)
+			}
+			twice, err := FormatPolicy(once)
+			if err != nil {
+				t.Fatalf("failed to parse formatted policy: %v\n%s", err, once)
+			}
+			if once != twice {
+				t.Errorf("formatting is not idempotent:\n%s\nthen:\n%s", once, twice)
+			}
+			if strings.Count(once, "//") != strings.Count(src, "//") {
+				t.Errorf("expected comments to be kept:\n%s", once)
+			}
+
+			// The formatted text parses to the same policies
+			before, _ := ParsePolicies(src)
+			after, _ := ParsePolicies(once)
+			if len(before) != len(after) {
+				t.Fatalf("expected %d policies, got %d", len(before), len(after))
+			}
+			for i := range before {
+				if !reflect.DeepEqual(policyShape(before[i]), policyShape(after[i])) {
+					t.Errorf("policy %s changed:\n%v\nthen:\n%v", before[i].ID, policyShape(before[i]), policyShape(after[i]))
+				}
+			}
+		})
+	}
+}
+
+// policyShape renders a policy without source positions, for comparing the
+// result of a round trip
+func policyShape(p *Policy) []string {
+	shape := []string{p.ID, string(p.Effect)}
+	for _, ann := range p.Annotations {
+		shape = append(shape, "@"+ann.Key+"="+ann.Value)
+	}
+	for _, sc := range []ScopeConstraint{p.Scope.Principal, p.Scope.Action, p.Scope.Resource} {
+		shape = append(shape, string(sc.Op)+string(sc.Slot))
+		for _, ref := range sc.Entities {
+			shape = append(shape, ref.String())
+		}
+	}
+	for _, cond := range p.Conditions {
+		shape = append(shape, string(cond.Kind))
+		for _, clause := range cond.Clauses {
+			shape = append(shape, ExprString(clause))
+		}
+	}
+	return shape
+}
+
+func TestFormatPolicyKeepsDecisions(t *testing.T) {
+	formatted, err := FormatPolicy(compileTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to format policy: %v", err)
+	}
+	before, _ := ParseCedarPolicy(compileTestPolicy)
+	after, err := ParseCedarPolicy(formatted)
+	if err != nil {
+		t.Fatalf("failed to parse formatted policy: %v", err)
+	}
+
+	for _, ctx := range compileTestContexts() {
+		want := EvaluatePolicy(ctx, before)
+		got := EvaluatePolicy(ctx, after)
+		if got.Decision != want.Decision || !reflect.DeepEqual(got.PolicyIDs, want.PolicyIDs) {
+			t.Errorf("%s %s: formatted %s %v, original %s %v", ctx.Method, ctx.URL, got.Decision, got.PolicyIDs, want.Decision, want.PolicyIDs)
+		}
+	}
+}
+
+func TestFormatRules(t *testing.T) {
+	parsed, err := ParseCedarPolicy(`
+@id("tpl") permit (principal == ?principal, action, resource);
+forbid (principal, action, resource) when { resource.hostname == "evil.com" };
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	rules := append(parsed,
+		PolicyRule{ID: "big-body", Action: ActionForbid, Field: "body_size", Operator: OpGreaterThan, Value: 1024,
+			Annotations: map[string]string{"enforcement": "warn", "owner": "security"}},
+		PolicyRule{Action: ActionPermit, Field: "x-api-version", Operator: OpGreaterThanOrEqual, Value: 1.5},
+		PolicyRule{ID: "policy3", Action: ActionPermit},
+	)
+
+	text, err := FormatRules(rules)
+	if err != nil {
+		t.Fatalf("failed to format rules: %v", err)
+	}
+	want := `@id("policy1")
+forbid (principal, action, resource)
+when { resource.hostname == "evil.com" };
+
+@id("big-body")
+@enforcement("warn")
+@owner("security")
+forbid (principal, action, resource)
+when { resource.body_size > 1024 };
+
+permit (principal, action, resource)
+when { resource["x-api-version"] >= 1.5 };
+
+permit (principal, action, resource);
+`
+	if text != want {
+		t.Errorf("unexpected text:\n%s\nwant:\n%s", text, want)
+	}
+
+	reparsed, err := ParseCedarPolicy(text)
+	if err != nil {
+		t.Fatalf("failed to parse formatted rules: %v", err)
+	}
+	for i, rule := range reparsed {
+		if i == 2 {
+			// The unnamed rule gets its positional ID
+			rule.ID = ""
+		}
+		orig := rules[i]
+		if rule.ID != orig.ID || rule.Action != orig.Action || rule.Field != orig.Field || rule.Operator != orig.Operator || rule.Value != orig.Value {
+			t.Errorf("rule %d changed: %+v, was %+v", i, rule, orig)
+		}
+	}
+	if reparsed[1].Annotations["enforcement"] != "warn" {
+		t.Errorf("expected annotations to round-trip, got %v", reparsed[1].Annotations)
+	}
+}
+
+func TestFormatRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule PolicyRule
+		msg  string
+	}{
+		{"bad action", PolicyRule{Action: "allow"}, `invalid action "allow"`},
+		{"bad operator", PolicyRule{Action: ActionForbid, Field: "method", Operator: OpAnd, Value: "GET"}, `unsupported operator "&&"`},
+		{"bad value", PolicyRule{Action: ActionForbid, Field: "method", Operator: OpEqual, Value: true}, "unsupported value true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FormatRules([]PolicyRule{tt.rule})
+			if err == nil || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("expected error containing %q, got %v", tt.msg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package trusera
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Cedar ipaddr values are represented as netip.Prefix; a single address is a
+// full-length prefix such as 10.0.0.1/32.
+
+// Well-known ranges backing the isLoopback and isMulticast methods
+var (
+	loopbackRanges  = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	multicastRanges = []netip.Prefix{netip.MustParsePrefix("224.0.0.0/4"), netip.MustParsePrefix("ff00::/8")}
+)
+
+// parseIPAddr parses the argument of ip(): an address such as "10.0.0.1" or
+// a CIDR range such as "10.0.0.0/8"
+func parseIPAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP range %q", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	return addrPrefix(addr), nil
+}
+
+// addrPrefix converts a single address to an ipaddr value. IPv4-mapped IPv6
+// addresses are unmapped so ::ffff:10.0.0.1 falls into 10.0.0.0/8.
+func addrPrefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
+
+// ipInRange reports whether every address of ip lies within r
+func ipInRange(ip, r netip.Prefix) bool {
+	return ip.Addr().Is4() == r.Addr().Is4() && ip.Bits() >= r.Bits() && r.Contains(ip.Addr())
+}
+
+// ipInAnyRange reports whether ip lies within one of ranges
+func ipInAnyRange(ip netip.Prefix, ranges []netip.Prefix) bool {
+	for _, r := range ranges {
+		if ipInRange(ip, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// evalIPMethod evaluates the ipaddr methods isInRange, isLoopback,
+// isMulticast, isIpv4 and isIpv6
+func evalIPMethod(e *MethodCallExpr, ip netip.Prefix, ctx RequestContext) (any, error) {
+	switch e.Method {
+	case "isInRange":
+		arg, err := evalExpr(e.Args[0], ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := arg.(netip.Prefix)
+		if !ok {
+			return nil, evalErrorf(e, "isInRange expects an ipaddr argument, got %s", typeName(arg))
+		}
+		return ipInRange(ip, r), nil
+	case "isLoopback":
+		return ipInAnyRange(ip, loopbackRanges), nil
+	case "isMulticast":
+		return ipInAnyRange(ip, multicastRanges), nil
+	case "isIpv4":
+		return ip.Addr().Is4(), nil
+	case "isIpv6":
+		return ip.Addr().Is6(), nil
+	}
+	return nil, evalErrorf(e, "%s is not defined for ipaddr", e.Method)
+}
+
+// evalCall evaluates an extension function call
+func evalCall(e *CallExpr, ctx RequestContext) (any, error) {
+	if e.Func != "ip" {
+		return nil, evalErrorf(e, "unknown function %q", e.Func)
+	}
+	arg, err := evalExpr(e.Args[0], ctx)
+	if err != nil {
+		return nil, err
+	}
+	str, ok := arg.(string)
+	if !ok {
+		return nil, evalErrorf(e, "ip expects a string, got %s", typeName(arg))
+	}
+	ip, err := parseIPAddr(str)
+	if err != nil {
+		return nil, evalErrorf(e, "%v", err)
+	}
+	return ip, nil
+}
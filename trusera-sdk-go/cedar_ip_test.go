@@ -0,0 +1,115 @@
+package trusera
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestEvaluatePolicyIPRanges(t *testing.T) {
+	rules, err := ParseCedarPolicy(`
+forbid (principal, action, resource)
+when {
+    resource.ip.isInRange(ip("10.0.0.0/8")) ||
+    resource.ip.isInRange(ip("172.16.0.0/12")) ||
+    resource.ip.isInRange(ip("192.168.0.0/16")) ||
+    resource.ip == ip("169.254.169.254") ||
+    resource.ip.isLoopback() ||
+    resource.ip.isMulticast()
+};
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.1.2.3", "Deny"},
+		{"172.31.255.255", "Deny"},
+		{"172.32.0.1", "Allow"},
+		{"192.168.1.1", "Deny"},
+		{"169.254.169.254", "Deny"},
+		{"169.254.169.253", "Allow"},
+		{"127.0.0.1", "Deny"},
+		{"::1", "Deny"},
+		{"::ffff:10.0.0.1", "Deny"},
+		{"239.1.1.1", "Deny"},
+		{"ff02::1", "Deny"},
+		{"93.184.216.34", "Allow"},
+		{"2606:2800:220:1::1", "Allow"},
+	}
+
+	for _, tt := range tests {
+		ctx := RequestContext{Hostname: "example.com", IP: netip.MustParseAddr(tt.ip)}
+		if got := EvaluatePolicy(ctx, rules).Decision; got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.ip, tt.want, got)
+		}
+	}
+
+	// Without an address the ip conditions cannot apply
+	if got := EvaluatePolicy(RequestContext{Hostname: "example.com"}, rules).Decision; got != "Allow" {
+		t.Errorf("expected Allow without resource.ip, got %s", got)
+	}
+}
+
+func TestIPInRange(t *testing.T) {
+	tests := []struct {
+		ip, r string
+		want  bool
+	}{
+		{"10.0.0.1", "10.0.0.0/8", true},
+		{"10.0.0.0/16", "10.0.0.0/8", true},
+		{"10.0.0.0/8", "10.0.0.0/16", false},
+		{"10.0.0.5/8", "10.0.0.0/8", true},
+		{"11.0.0.1", "10.0.0.0/8", false},
+		{"::a00:1", "10.0.0.0/8", false},
+		{"fd00::1", "fc00::/7", true},
+	}
+
+	for _, tt := range tests {
+		ip, err := parseIPAddr(tt.ip)
+		if err != nil {
+			t.Fatalf("parseIPAddr(%q) failed: %v", tt.ip, err)
+		}
+		r, err := parseIPAddr(tt.r)
+		if err != nil {
+			t.Fatalf("parseIPAddr(%q) failed: %v", tt.r, err)
+		}
+		if got := ipInRange(ip, r); got != tt.want {
+			t.Errorf("ipInRange(%s, %s) = %v, want %v", tt.ip, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestParseCedarPolicyIPErrors(t *testing.T) {
+	tests := []struct {
+		policy string
+		msg    string
+	}{
+		{`forbid (principal, action, resource) when { resource.ip.isInRange(ip("10.0.0.0/33")) };`, "invalid IP range"},
+		{`forbid (principal, action, resource) when { resource.ip == ip("localhost") };`, "invalid IP address"},
+		{`forbid (principal, action, resource) when { resource.ip == ip() };`, "expects 1 argument"},
+		{`forbid (principal, action, resource) when { resource.ip == cidr("10.0.0.0/8") };`, `unknown function "cidr"`},
+		{`forbid (principal, action, resource) when { resource.ip.isLoopback(true) };`, "expects 0 argument"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCedarPolicy(tt.policy)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("expected error containing %q, got %v", tt.msg, err)
+		}
+	}
+}
+
+func TestExprStringIP(t *testing.T) {
+	policies, err := ParsePolicies(`forbid (principal, action, resource) when { resource.ip.isInRange(ip("10.0.0.0/8")) && !resource.ip.isLoopback() };`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	want := `resource.ip.isInRange(ip("10.0.0.0/8")) && !resource.ip.isLoopback()`
+	if got := ExprString(policies[0].Conditions[0].Clauses[0]); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,445 @@
+package trusera
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Position identifies a location in Cedar policy source text
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in runes, starting at 1
+}
+
+// String formats the position as line:column
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// PolicySyntaxError reports malformed Cedar policy text
+type PolicySyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+// Error implements the error interface
+func (e *PolicySyntaxError) Error() string {
+	return fmt.Sprintf("cedar syntax error at %s: %s", e.Pos, e.Msg)
+}
+
+// tokenKind classifies lexical tokens
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokDecimal
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokSemi
+	tokDot
+	tokColon
+	tokColonColon
+	tokAt
+	tokEq
+	tokNe
+	tokLt
+	tokLe
+	tokGt
+	tokGe
+	tokMinus
+	tokNot
+	tokAnd
+	tokOr
+	tokSlot
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:        "end of input",
+	tokIdent:      "identifier",
+	tokString:     "string",
+	tokInt:        "integer",
+	tokDecimal:    "decimal",
+	tokLParen:     "'('",
+	tokRParen:     "')'",
+	tokLBrace:     "'{'",
+	tokRBrace:     "'}'",
+	tokLBracket:   "'['",
+	tokRBracket:   "']'",
+	tokComma:      "','",
+	tokSemi:       "';'",
+	tokDot:        "'.'",
+	tokColon:      "':'",
+	tokColonColon: "'::'",
+	tokAt:         "'@'",
+	tokEq:         "'=='",
+	tokNe:         "'!='",
+	tokLt:         "'<'",
+	tokLe:         "'<='",
+	tokGt:         "'>'",
+	tokGe:         "'>='",
+	tokMinus:      "'-'",
+	tokNot:        "'!'",
+	tokAnd:        "'&&'",
+	tokOr:         "'||'",
+	tokSlot:       "template slot",
+}
+
+// String returns a human-readable token kind for error messages
+func (k tokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+// token is a single lexical unit of policy text
+type token struct {
+	kind tokenKind
+	text string // identifier name, decoded string literal or number text
+	pos  Position
+
+	// For strings: the literal split at unescaped '*' wildcards, and whether
+	// it contains a '\*' escape, which is only valid in like patterns
+	pattern    []string
+	starEscape bool
+}
+
+// describe renders the token for error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokIdent:
+		return fmt.Sprintf("identifier %q", t.text)
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	case tokInt, tokDecimal:
+		return fmt.Sprintf("number %s", t.text)
+	case tokSlot:
+		return "slot " + t.text
+	}
+	return t.kind.String()
+}
+
+// lexer splits Cedar policy text into tokens
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+
+	comments []comment // skipped // comments, in source order
+}
+
+// comment is a // comment, with its text up to the end of the line
+type comment struct {
+	pos  Position
+	text string
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+// tokenize lexes the whole input, ending with a tokEOF token
+func tokenize(src string) ([]token, error) {
+	lx := newLexer(src)
+	var toks []token
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (lx *lexer) pos() Position {
+	return Position{Offset: lx.off, Line: lx.line, Column: lx.col}
+}
+
+func (lx *lexer) errorf(pos Position, format string, args ...any) error {
+	return &PolicySyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// peek returns the rune at the current offset without consuming it
+func (lx *lexer) peek() rune {
+	if lx.off >= len(lx.src) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(lx.src[lx.off:])
+	return r
+}
+
+// peekAt returns the byte n positions ahead, or 0 past the end
+func (lx *lexer) peekAt(n int) byte {
+	if lx.off+n >= len(lx.src) {
+		return 0
+	}
+	return lx.src[lx.off+n]
+}
+
+// advance consumes one rune and updates line/column tracking
+func (lx *lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(lx.src[lx.off:])
+	lx.off += size
+	if r == '\n' {
+		lx.line++
+		lx.col = 1
+	} else {
+		lx.col++
+	}
+	return r
+}
+
+// skipSpace skips whitespace and // comments
+func (lx *lexer) skipSpace() {
+	for lx.off < len(lx.src) {
+		r := lx.peek()
+		switch {
+		case unicode.IsSpace(r):
+			lx.advance()
+		case r == '/' && lx.peekAt(1) == '/':
+			start := lx.pos()
+			for lx.off < len(lx.src) && lx.peek() != '\n' {
+				lx.advance()
+			}
+			lx.comments = append(lx.comments, comment{pos: start, text: strings.TrimRightFunc(lx.src[start.Offset:lx.off], unicode.IsSpace)})
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next token
+func (lx *lexer) next() (token, error) {
+	lx.skipSpace()
+	start := lx.pos()
+	if lx.off >= len(lx.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	r := lx.peek()
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		begin := lx.off
+		for lx.off < len(lx.src) {
+			c := lx.peek()
+			if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			lx.advance()
+		}
+		return token{kind: tokIdent, text: lx.src[begin:lx.off], pos: start}, nil
+
+	case r >= '0' && r <= '9':
+		return lx.lexNumber(start)
+
+	case r == '"':
+		return lx.lexString(start)
+	}
+
+	lx.advance()
+	switch r {
+	case '(':
+		return token{kind: tokLParen, pos: start}, nil
+	case ')':
+		return token{kind: tokRParen, pos: start}, nil
+	case '{':
+		return token{kind: tokLBrace, pos: start}, nil
+	case '}':
+		return token{kind: tokRBrace, pos: start}, nil
+	case '[':
+		return token{kind: tokLBracket, pos: start}, nil
+	case ']':
+		return token{kind: tokRBracket, pos: start}, nil
+	case ',':
+		return token{kind: tokComma, pos: start}, nil
+	case ';':
+		return token{kind: tokSemi, pos: start}, nil
+	case '.':
+		return token{kind: tokDot, pos: start}, nil
+	case '@':
+		return token{kind: tokAt, pos: start}, nil
+	case '?':
+		begin := lx.off
+		for lx.off < len(lx.src) {
+			c := lx.peek()
+			if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			lx.advance()
+		}
+		if lx.off == begin {
+			return token{}, lx.errorf(start, "expected slot name after '?'")
+		}
+		return token{kind: tokSlot, text: lx.src[begin-1 : lx.off], pos: start}, nil
+	case '-':
+		return token{kind: tokMinus, pos: start}, nil
+	case ':':
+		if lx.peek() == ':' {
+			lx.advance()
+			return token{kind: tokColonColon, pos: start}, nil
+		}
+		return token{kind: tokColon, pos: start}, nil
+	case '=':
+		if lx.peek() == '=' {
+			lx.advance()
+			return token{kind: tokEq, pos: start}, nil
+		}
+		return token{}, lx.errorf(start, "unexpected '=', did you mean '=='?")
+	case '!':
+		if lx.peek() == '=' {
+			lx.advance()
+			return token{kind: tokNe, pos: start}, nil
+		}
+		return token{kind: tokNot, pos: start}, nil
+	case '&':
+		if lx.peek() == '&' {
+			lx.advance()
+			return token{kind: tokAnd, pos: start}, nil
+		}
+		return token{}, lx.errorf(start, "unexpected '&', did you mean '&&'?")
+	case '|':
+		if lx.peek() == '|' {
+			lx.advance()
+			return token{kind: tokOr, pos: start}, nil
+		}
+		return token{}, lx.errorf(start, "unexpected '|', did you mean '||'?")
+	case '<':
+		if lx.peek() == '=' {
+			lx.advance()
+			return token{kind: tokLe, pos: start}, nil
+		}
+		return token{kind: tokLt, pos: start}, nil
+	case '>':
+		if lx.peek() == '=' {
+			lx.advance()
+			return token{kind: tokGe, pos: start}, nil
+		}
+		return token{kind: tokGt, pos: start}, nil
+	}
+
+	return token{}, lx.errorf(start, "unexpected character %q", r)
+}
+
+// lexNumber lexes an integer or a decimal literal such as 75.5
+func (lx *lexer) lexNumber(start Position) (token, error) {
+	begin := lx.off
+	for lx.off < len(lx.src) && isDigit(lx.src[lx.off]) {
+		lx.advance()
+	}
+	kind := tokInt
+	if lx.peek() == '.' && isDigit(lx.peekAt(1)) {
+		kind = tokDecimal
+		lx.advance()
+		for lx.off < len(lx.src) && isDigit(lx.src[lx.off]) {
+			lx.advance()
+		}
+	}
+	if r := lx.peek(); r == '_' || unicode.IsLetter(r) {
+		return token{}, lx.errorf(lx.pos(), "unexpected character %q in number", r)
+	}
+	return token{kind: kind, text: lx.src[begin:lx.off], pos: start}, nil
+}
+
+// lexString lexes a double-quoted string literal and decodes its escapes
+func (lx *lexer) lexString(start Position) (token, error) {
+	lx.advance() // opening quote
+	var sb, segment strings.Builder
+	tok := token{kind: tokString, pos: start}
+	for {
+		if lx.off >= len(lx.src) {
+			return token{}, lx.errorf(start, "unterminated string literal")
+		}
+		r := lx.advance()
+		switch r {
+		case '"':
+			tok.text = sb.String()
+			tok.pattern = append(tok.pattern, segment.String())
+			return tok, nil
+		case '\n':
+			return token{}, lx.errorf(start, "unterminated string literal")
+		case '*':
+			sb.WriteRune(r)
+			tok.pattern = append(tok.pattern, segment.String())
+			segment.Reset()
+		case '\\':
+			if lx.peek() == '*' {
+				lx.advance()
+				sb.WriteRune('*')
+				segment.WriteRune('*')
+				tok.starEscape = true
+				continue
+			}
+			n := sb.Len()
+			if err := lx.lexEscape(&sb); err != nil {
+				return token{}, err
+			}
+			segment.WriteString(sb.String()[n:])
+		default:
+			sb.WriteRune(r)
+			segment.WriteRune(r)
+		}
+	}
+}
+
+// lexEscape decodes the escape sequence following a backslash
+func (lx *lexer) lexEscape(sb *strings.Builder) error {
+	escPos := lx.pos()
+	if lx.off >= len(lx.src) {
+		return lx.errorf(escPos, "unterminated escape sequence")
+	}
+	r := lx.advance()
+	switch r {
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case '0':
+		sb.WriteByte(0)
+	case '\\', '"', '\'':
+		sb.WriteRune(r)
+	case 'u':
+		if lx.peek() != '{' {
+			return lx.errorf(escPos, `invalid unicode escape, expected \u{...}`)
+		}
+		lx.advance()
+		begin := lx.off
+		for lx.off < len(lx.src) && lx.peek() != '}' {
+			lx.advance()
+		}
+		if lx.off >= len(lx.src) {
+			return lx.errorf(escPos, "unterminated unicode escape")
+		}
+		hex := lx.src[begin:lx.off]
+		lx.advance() // closing brace
+		cp, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) == 0 || len(hex) > 6 || !utf8.ValidRune(rune(cp)) {
+			return lx.errorf(escPos, "invalid unicode escape %q", hex)
+		}
+		sb.WriteRune(rune(cp))
+	default:
+		return lx.errorf(escPos, "invalid escape sequence '\\%c'", r)
+	}
+	return nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,674 @@
+package trusera
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scopeVariables are the variables a policy head and condition may reference
+var scopeVariables = map[string]bool{
+	"principal": true,
+	"action":    true,
+	"resource":  true,
+	"context":   true,
+}
+
+// ParsePolicies parses Cedar policy text into policy ASTs.
+// Malformed input yields a *PolicySyntaxError carrying the offending position.
+// Each policy is identified by its @id annotation, or "policy<N>" by position.
+func ParsePolicies(policyText string) ([]*Policy, error) {
+	toks, err := tokenize(policyText)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: policyText, toks: toks}
+	var policies []*Policy
+	seen := make(map[string]bool)
+	for p.peek().kind != tokEOF {
+		policy, err := p.parsePolicy()
+		if err != nil {
+			return nil, err
+		}
+
+		policy.ID = positionalID(len(policies))
+		pos := policy.Pos
+		for _, ann := range policy.Annotations {
+			if ann.Key == "id" {
+				policy.ID, pos = ann.Value, ann.Pos
+			}
+		}
+		if seen[policy.ID] {
+			return nil, p.errorf(pos, "duplicate policy id %q", policy.ID)
+		}
+		seen[policy.ID] = true
+
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// enforcementAnnotations are the accepted values of @enforcement
+var enforcementAnnotations = map[string]bool{
+	string(EnforcementLog):   true,
+	string(EnforcementWarn):  true,
+	string(EnforcementBlock): true,
+}
+
+// parser is a recursive-descent parser over a token slice
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(pos Position, format string, args ...any) error {
+	return &PolicySyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expect consumes a token of the given kind or fails
+func (p *parser) expect(kind tokenKind, context string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, p.errorf(tok.pos, "expected %s %s, found %s", kind, context, tok.describe())
+	}
+	return tok, nil
+}
+
+// expectKeyword consumes the identifier kw or fails
+func (p *parser) expectKeyword(kw, context string) (token, error) {
+	tok := p.next()
+	if tok.kind != tokIdent || tok.text != kw {
+		return tok, p.errorf(tok.pos, "expected '%s' %s, found %s", kw, context, tok.describe())
+	}
+	return tok, nil
+}
+
+// isKeyword reports whether the next token is the identifier kw
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == kw
+}
+
+// parsePolicy parses: {annotation} effect '(' scope ')' {condition} ';'
+func (p *parser) parsePolicy() (*Policy, error) {
+	policy := &Policy{}
+	start := p.peek().pos
+
+	for p.peek().kind == tokAt {
+		ann, err := p.parseAnnotation()
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := policy.Annotation(ann.Key); dup {
+			return nil, p.errorf(ann.Pos, "duplicate annotation @%s", ann.Key)
+		}
+		switch {
+		case ann.Key == "id" && ann.Value == "":
+			return nil, p.errorf(ann.Pos, "@id requires a non-empty value")
+		case ann.Key == "enforcement" && !enforcementAnnotations[ann.Value]:
+			return nil, p.errorf(ann.Pos, "invalid @enforcement value %q, use \"log\", \"warn\" or \"block\"", ann.Value)
+		}
+		policy.Annotations = append(policy.Annotations, ann)
+	}
+
+	effectTok := p.next()
+	if effectTok.kind != tokIdent || (effectTok.text != string(ActionPermit) && effectTok.text != string(ActionForbid)) {
+		return nil, p.errorf(effectTok.pos, "expected 'permit' or 'forbid', found %s", effectTok.describe())
+	}
+	policy.Effect = PolicyAction(effectTok.text)
+	policy.Pos = effectTok.pos
+
+	if _, err := p.expect(tokLParen, "after effect"); err != nil {
+		return nil, err
+	}
+	scope, err := p.parseScope()
+	if err != nil {
+		return nil, err
+	}
+	policy.Scope = scope
+	if _, err := p.expect(tokRParen, "to close policy scope"); err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(string(ConditionWhen)) || p.isKeyword(string(ConditionUnless)) {
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		policy.Conditions = append(policy.Conditions, cond)
+	}
+
+	end, err := p.expect(tokSemi, "at end of policy")
+	if err != nil {
+		if end.kind == tokIdent {
+			return nil, p.errorf(end.pos, "expected 'when', 'unless' or ';' after policy scope, found %s", end.describe())
+		}
+		return nil, err
+	}
+	policy.Raw = strings.TrimSpace(p.src[start.Offset : end.pos.Offset+1])
+
+	return policy, nil
+}
+
+// parseAnnotation parses: '@' IDENT [ '(' STRING ')' ]
+func (p *parser) parseAnnotation() (Annotation, error) {
+	at := p.next()
+	name, err := p.expect(tokIdent, "after '@'")
+	if err != nil {
+		return Annotation{}, err
+	}
+	ann := Annotation{Key: name.text, Pos: at.pos}
+	if p.peek().kind == tokLParen {
+		p.next()
+		val, err := p.expect(tokString, "as annotation value")
+		if err != nil {
+			return Annotation{}, err
+		}
+		ann.Value = val.text
+		if _, err := p.expect(tokRParen, "to close annotation"); err != nil {
+			return Annotation{}, err
+		}
+	}
+	return ann, nil
+}
+
+// parseScope parses: principal ',' action ',' resource
+func (p *parser) parseScope() (PolicyScope, error) {
+	var scope PolicyScope
+	var err error
+
+	if scope.Principal, err = p.parseScopeConstraint("principal", false); err != nil {
+		return scope, err
+	}
+	if _, err = p.expect(tokComma, "after principal"); err != nil {
+		return scope, err
+	}
+	if scope.Action, err = p.parseScopeConstraint("action", true); err != nil {
+		return scope, err
+	}
+	if _, err = p.expect(tokComma, "after action"); err != nil {
+		return scope, err
+	}
+	if scope.Resource, err = p.parseScopeConstraint("resource", false); err != nil {
+		return scope, err
+	}
+	return scope, nil
+}
+
+// parseScopeConstraint parses: VAR [ ('==' | 'in') entity ], where the action
+// variable additionally accepts 'in' with a list of entities
+func (p *parser) parseScopeConstraint(variable string, allowList bool) (ScopeConstraint, error) {
+	tok, err := p.expectKeyword(variable, "in policy scope")
+	if err != nil {
+		return ScopeConstraint{}, err
+	}
+	sc := ScopeConstraint{Op: ScopeAny, Pos: tok.pos}
+
+	switch {
+	case p.peek().kind == tokEq:
+		p.next()
+		sc.Op = ScopeEq
+		if p.peek().kind == tokSlot {
+			return sc, p.parseSlot(&sc, variable)
+		}
+		ref, err := p.parseEntityRef()
+		if err != nil {
+			return sc, err
+		}
+		sc.Entities = []EntityRef{ref}
+
+	case p.isKeyword("in"):
+		p.next()
+		sc.Op = ScopeIn
+		if p.peek().kind == tokSlot {
+			return sc, p.parseSlot(&sc, variable)
+		}
+		if p.peek().kind == tokLBracket {
+			if !allowList {
+				return sc, p.errorf(p.peek().pos, "entity lists are only allowed for action")
+			}
+			refs, err := p.parseEntityList()
+			if err != nil {
+				return sc, err
+			}
+			sc.Entities = refs
+		} else {
+			ref, err := p.parseEntityRef()
+			if err != nil {
+				return sc, err
+			}
+			sc.Entities = []EntityRef{ref}
+		}
+	}
+
+	return sc, nil
+}
+
+// parseSlot parses the template slot of a scope constraint, which must be
+// named after the variable it constrains: ?principal or ?resource
+func (p *parser) parseSlot(sc *ScopeConstraint, variable string) error {
+	tok := p.next()
+	slot := SlotID(tok.text)
+	if slot != SlotPrincipal && slot != SlotResource {
+		return p.errorf(tok.pos, "unknown slot %s, use ?principal or ?resource", tok.text)
+	}
+	if string(slot) != "?"+variable {
+		return p.errorf(tok.pos, "slot %s cannot constrain %s", slot, variable)
+	}
+	sc.Slot = slot
+	sc.SlotPos = tok.pos
+	return nil
+}
+
+// parseEntityList parses: '[' entity {',' entity} ']'
+func (p *parser) parseEntityList() ([]EntityRef, error) {
+	open := p.next()
+	var refs []EntityRef
+	for p.peek().kind != tokRBracket {
+		ref, err := p.parseEntityRef()
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRBracket, "to close entity list"); err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, p.errorf(open.pos, "entity list must not be empty")
+	}
+	return refs, nil
+}
+
+// parseEntityRef parses: IDENT {'::' IDENT} '::' STRING
+func (p *parser) parseEntityRef() (EntityRef, error) {
+	first, err := p.expect(tokIdent, "as entity type")
+	if err != nil {
+		return EntityRef{}, err
+	}
+	return p.parseEntityRefFrom(first)
+}
+
+// parseEntityRefFrom continues an entity reference after its first path segment
+func (p *parser) parseEntityRefFrom(first token) (EntityRef, error) {
+	path := []string{first.text}
+	for {
+		if _, err := p.expect(tokColonColon, "in entity reference"); err != nil {
+			return EntityRef{}, err
+		}
+		tok := p.next()
+		switch tok.kind {
+		case tokString:
+			return EntityRef{Type: strings.Join(path, "::"), ID: tok.text}, nil
+		case tokIdent:
+			path = append(path, tok.text)
+		default:
+			return EntityRef{}, p.errorf(tok.pos, "expected entity id string, found %s", tok.describe())
+		}
+	}
+}
+
+// parseCondition parses: ('when' | 'unless') '{' expr {';' expr} [';'] '}'
+func (p *parser) parseCondition() (Condition, error) {
+	kw := p.next()
+	cond := Condition{Kind: ConditionKind(kw.text), Pos: kw.pos}
+
+	if _, err := p.expect(tokLBrace, "after '"+kw.text+"'"); err != nil {
+		return cond, err
+	}
+	for p.peek().kind != tokRBrace {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return cond, err
+		}
+		cond.Clauses = append(cond.Clauses, expr)
+
+		if p.peek().kind != tokSemi {
+			break
+		}
+		for p.peek().kind == tokSemi {
+			p.next()
+		}
+	}
+	closing, err := p.expect(tokRBrace, "to close '"+kw.text+"' block")
+	if err != nil {
+		return cond, err
+	}
+	if len(cond.Clauses) == 0 {
+		return cond, p.errorf(closing.pos, "empty '%s' block", kw.text)
+	}
+	return cond, nil
+}
+
+// parseExpr parses a condition expression
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+// parseOr parses: and {'||' and}
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		opTok := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpOr, Left: left, Right: right, Pos: opTok.pos}
+	}
+	return left, nil
+}
+
+// parseAnd parses: relation {'&&' relation}
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		opTok := p.next()
+		right, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpAnd, Left: left, Right: right, Pos: opTok.pos}
+	}
+	return left, nil
+}
+
+var relationOperators = map[tokenKind]PolicyOperator{
+	tokEq: OpEqual,
+	tokNe: OpNotEqual,
+	tokLt: OpLessThan,
+	tokLe: OpLessThanOrEqual,
+	tokGt: OpGreaterThan,
+	tokGe: OpGreaterThanOrEqual,
+}
+
+// parseRelation parses: unary [relop unary | 'in' unary | 'like' PATTERN | 'has' (IDENT | STRING)]
+func (p *parser) parseRelation() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.isKeyword("like"):
+		kw := p.next()
+		pat, err := p.expect(tokString, "as like pattern")
+		if err != nil {
+			return nil, err
+		}
+		return &LikeExpr{Operand: left, Pattern: pat.pattern, Pos: kw.pos}, nil
+
+	case p.isKeyword("has"):
+		kw := p.next()
+		attr := p.next()
+		if attr.kind != tokIdent && attr.kind != tokString {
+			return nil, p.errorf(attr.pos, "expected attribute name after 'has', found %s", attr.describe())
+		}
+		return &HasExpr{Object: left, Attr: attr.text, Pos: kw.pos}, nil
+
+	case p.isKeyword("in"):
+		kw := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: OpIn, Left: left, Right: right, Pos: kw.pos}, nil
+	}
+
+	opTok := p.peek()
+	op, ok := relationOperators[opTok.kind]
+	if !ok {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{Op: op, Left: left, Right: right, Pos: opTok.pos}, nil
+}
+
+// parseUnary parses: {'!'} member
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind == tokNot {
+		opTok := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: OpNot, Operand: operand, Pos: opTok.pos}, nil
+	}
+	return p.parseMember()
+}
+
+// parseMember parses: primary { '.' IDENT | '[' STRING ']' }
+func (p *parser) parseMember() (Expr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokDot:
+			dot := p.next()
+			name, err := p.expect(tokIdent, "after '.'")
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().kind == tokLParen {
+				expr, err = p.parseMethodCall(expr, name)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			expr = &AttrExpr{Object: expr, Attr: name.text, Pos: dot.pos}
+		case tokLBracket:
+			open := p.next()
+			name, err := p.expect(tokString, "as attribute name")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokRBracket, "to close attribute access"); err != nil {
+				return nil, err
+			}
+			expr = &AttrExpr{Object: expr, Attr: name.text, Pos: open.pos}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// methodArity lists the supported methods and their argument counts
+var methodArity = map[string]int{
+	"contains":    1,
+	"containsAll": 1,
+	"containsAny": 1,
+	"isInRange":   1,
+	"isLoopback":  0,
+	"isMulticast": 0,
+	"isIpv4":      0,
+	"isIpv6":      0,
+}
+
+// functionArity lists the supported extension functions and their argument counts
+var functionArity = map[string]int{
+	"ip": 1,
+}
+
+// parseFunctionCall parses the argument list of an extension function call
+// such as ip("10.0.0.0/8"). Literal arguments are checked here so a malformed
+// address fails at load time rather than silently never matching.
+func (p *parser) parseFunctionCall(name token) (Expr, error) {
+	args, err := p.parseExprList(tokLParen, tokRParen, "argument list")
+	if err != nil {
+		return nil, err
+	}
+	if arity := functionArity[name.text]; len(args) != arity {
+		return nil, p.errorf(name.pos, "function %s expects %d argument(s), got %d", name.text, arity, len(args))
+	}
+	if lit, ok := args[0].(*LiteralExpr); ok && name.text == "ip" {
+		str, ok := lit.Value.(string)
+		if !ok {
+			return nil, p.errorf(lit.Pos, "ip expects a string argument")
+		}
+		if _, err := parseIPAddr(str); err != nil {
+			return nil, p.errorf(lit.Pos, "%v", err)
+		}
+	}
+	return &CallExpr{Func: name.text, Args: args, Pos: name.pos}, nil
+}
+
+// parseMethodCall parses the argument list of receiver.name(...)
+func (p *parser) parseMethodCall(receiver Expr, name token) (Expr, error) {
+	arity, ok := methodArity[name.text]
+	if !ok {
+		return nil, p.errorf(name.pos, "unknown method %q", name.text)
+	}
+	args, err := p.parseExprList(tokLParen, tokRParen, "argument list")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != arity {
+		return nil, p.errorf(name.pos, "method %s expects %d argument(s), got %d", name.text, arity, len(args))
+	}
+	return &MethodCallExpr{Receiver: receiver, Method: name.text, Args: args, Pos: name.pos}, nil
+}
+
+// parseExprList parses: open [expr {',' expr} [',']] close
+func (p *parser) parseExprList(open, closing tokenKind, what string) ([]Expr, error) {
+	if _, err := p.expect(open, "to open "+what); err != nil {
+		return nil, err
+	}
+	var exprs []Expr
+	for p.peek().kind != closing {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(closing, "to close "+what); err != nil {
+		return nil, err
+	}
+	return exprs, nil
+}
+
+// parsePrimary parses literals, variables, entity references and parenthesized expressions
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	if tok.kind == tokLBracket {
+		elems, err := p.parseExprList(tokLBracket, tokRBracket, "set literal")
+		if err != nil {
+			return nil, err
+		}
+		return &SetExpr{Elems: elems, Pos: tok.pos}, nil
+	}
+
+	p.next()
+	switch tok.kind {
+	case tokString:
+		if tok.starEscape {
+			return nil, p.errorf(tok.pos, `'\*' is only valid in like patterns`)
+		}
+		return &LiteralExpr{Value: tok.text, Pos: tok.pos}, nil
+
+	case tokInt, tokDecimal:
+		return p.parseNumber(tok, false)
+
+	case tokMinus:
+		num := p.next()
+		if num.kind != tokInt && num.kind != tokDecimal {
+			return nil, p.errorf(num.pos, "expected number after '-', found %s", num.describe())
+		}
+		lit, err := p.parseNumber(num, true)
+		if err != nil {
+			return nil, err
+		}
+		lit.(*LiteralExpr).Pos = tok.pos
+		return lit, nil
+
+	case tokLParen:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "to close parenthesized expression"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+
+	case tokIdent:
+		switch {
+		case tok.text == "true" || tok.text == "false":
+			return &LiteralExpr{Value: tok.text == "true", Pos: tok.pos}, nil
+		case p.peek().kind == tokColonColon:
+			ref, err := p.parseEntityRefFrom(tok)
+			if err != nil {
+				return nil, err
+			}
+			return &EntityExpr{Ref: ref, Pos: tok.pos}, nil
+		case scopeVariables[tok.text]:
+			return &VarExpr{Name: tok.text, Pos: tok.pos}, nil
+		case p.peek().kind == tokLParen:
+			if _, ok := functionArity[tok.text]; !ok {
+				return nil, p.errorf(tok.pos, "unknown function %q", tok.text)
+			}
+			return p.parseFunctionCall(tok)
+		}
+		return nil, p.errorf(tok.pos, "unknown identifier %q (string values must be quoted)", tok.text)
+	}
+
+	if tok.kind == tokSlot {
+		return nil, p.errorf(tok.pos, "slot %s is only allowed in the policy scope", tok.text)
+	}
+	return nil, p.errorf(tok.pos, "expected expression, found %s", tok.describe())
+}
+
+// parseNumber converts an integer or decimal token into a literal
+func (p *parser) parseNumber(tok token, negative bool) (Expr, error) {
+	text := tok.text
+	if negative {
+		text = "-" + text
+	}
+	if tok.kind == tokInt {
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "integer literal %s out of range", text)
+		}
+		return &LiteralExpr{Value: n, Pos: tok.pos}, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf(tok.pos, "invalid decimal literal %s", text)
+	}
+	return &LiteralExpr{Value: f, Pos: tok.pos}, nil
+}
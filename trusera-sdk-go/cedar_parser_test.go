@@ -0,0 +1,321 @@
+package trusera
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	toks, err := tokenize(`forbid (principal, action == Action::"deploy", resource) // trailing
+when { resource.port >= 8080 };`)
+	if err != nil {
+		t.Fatalf("tokenize failed: %v", err)
+	}
+
+	want := []tokenKind{
+		tokIdent, tokLParen, tokIdent, tokComma, tokIdent, tokEq, tokIdent, tokColonColon, tokString,
+		tokComma, tokIdent, tokRParen, tokIdent, tokLBrace, tokIdent, tokDot, tokIdent, tokGe, tokInt,
+		tokRBrace, tokSemi, tokEOF,
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("expected %d tokens, got %d", len(want), len(toks))
+	}
+	for i, kind := range want {
+		if toks[i].kind != kind {
+			t.Errorf("token %d: expected %s, got %s", i, kind, toks[i].kind)
+		}
+	}
+
+	// "when" starts the second line
+	if toks[12].pos.Line != 2 || toks[12].pos.Column != 1 {
+		t.Errorf("expected 'when' at 2:1, got %s", toks[12].pos)
+	}
+}
+
+func TestTokenizeStringEscapes(t *testing.T) {
+	toks, err := tokenize(`"a\"b\\c\n\u{263A}"`)
+	if err != nil {
+		t.Fatalf("tokenize failed: %v", err)
+	}
+	if toks[0].text != "a\"b\\c\n☺" {
+		t.Errorf("unexpected decoded string: %q", toks[0].text)
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policy := `
+@id("deny-billing-deploy")
+forbid (
+    principal == Agent::"billing-bot",
+    action in [Action::"deploy", Action::"http_request"],
+    resource
+)
+when {
+    resource.hostname == "api.example.com";
+    resource["path"] != "/health"
+};
+
+permit (principal, action, resource);
+`
+
+	policies, err := ParsePolicies(policy)
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("expected 2 policies, got %d", len(policies))
+	}
+
+	first := policies[0]
+	if first.Effect != ActionForbid {
+		t.Errorf("expected forbid, got %s", first.Effect)
+	}
+	if len(first.Annotations) != 1 || first.Annotations[0].Key != "id" || first.Annotations[0].Value != "deny-billing-deploy" {
+		t.Errorf("unexpected annotations: %+v", first.Annotations)
+	}
+	if first.Scope.Principal.Op != ScopeEq || first.Scope.Principal.Entities[0] != (EntityRef{Type: "Agent", ID: "billing-bot"}) {
+		t.Errorf("unexpected principal scope: %+v", first.Scope.Principal)
+	}
+	if first.Scope.Action.Op != ScopeIn || len(first.Scope.Action.Entities) != 2 {
+		t.Errorf("unexpected action scope: %+v", first.Scope.Action)
+	}
+	if first.Scope.Resource.Op != ScopeAny {
+		t.Errorf("expected unconstrained resource, got %+v", first.Scope.Resource)
+	}
+	if len(first.Conditions) != 1 || len(first.Conditions[0].Clauses) != 2 {
+		t.Fatalf("expected one when block with 2 clauses, got %+v", first.Conditions)
+	}
+
+	attr, ok := first.Conditions[0].Clauses[1].(*BinaryExpr).Left.(*AttrExpr)
+	if !ok || attr.Attr != "path" {
+		t.Errorf("expected bracket access to resource path, got %#v", first.Conditions[0].Clauses[1])
+	}
+
+	if !strings.HasPrefix(first.Raw, "@id(") || !strings.HasSuffix(first.Raw, "};") {
+		t.Errorf("unexpected raw text: %q", first.Raw)
+	}
+
+	second := policies[1]
+	if second.Effect != ActionPermit || len(second.Conditions) != 0 {
+		t.Errorf("expected unconditional permit, got %+v", second)
+	}
+}
+
+func TestParsePoliciesSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		line   int
+		column int
+		msg    string
+	}{
+		{
+			name:   "missing semicolon",
+			policy: "permit (principal, action, resource)\nforbid (principal, action, resource);",
+			line:   2, column: 1,
+			msg: "expected 'when', 'unless' or ';'",
+		},
+		{
+			name:   "unquoted value",
+			policy: "forbid (principal, action, resource)\nwhen { resource.method == DELETE };",
+			line:   2, column: 27,
+			msg: `unknown identifier "DELETE"`,
+		},
+		{
+			name:   "unterminated string",
+			policy: `forbid (principal, action, resource) when { resource.method == "GET };`,
+			line:   1, column: 64,
+			msg: "unterminated string literal",
+		},
+		{
+			name:   "single equals",
+			policy: `forbid (principal, action, resource) when { resource.method = "GET" };`,
+			line:   1, column: 61,
+			msg: "did you mean '=='",
+		},
+		{
+			name:   "empty when block",
+			policy: "forbid (principal, action, resource) when { };",
+			line:   1, column: 45,
+			msg: "empty 'when' block",
+		},
+		{
+			name:   "unknown method",
+			policy: `forbid (principal, action, resource) when { ["a"].includes(resource.path) };`,
+			line:   1, column: 51,
+			msg: `unknown method "includes"`,
+		},
+		{
+			name:   "star escape outside like",
+			policy: `forbid (principal, action, resource) when { resource.path == "/a\*" };`,
+			line:   1, column: 62,
+			msg: "only valid in like patterns",
+		},
+		{
+			name:   "like without pattern",
+			policy: `forbid (principal, action, resource) when { resource.path like resource.url };`,
+			line:   1, column: 64,
+			msg: "expected string as like pattern",
+		},
+		{
+			name:   "bad effect",
+			policy: "allow (principal, action, resource);",
+			line:   1, column: 1,
+			msg: "expected 'permit' or 'forbid'",
+		},
+		{
+			name:   "missing resource",
+			policy: "permit (principal, action);",
+			line:   1, column: 26,
+			msg: "expected ','",
+		},
+		{
+			name:   "unknown slot",
+			policy: "permit (principal == ?agent, action, resource);",
+			line:   1, column: 22,
+			msg: "unknown slot ?agent",
+		},
+		{
+			name:   "slot on wrong variable",
+			policy: "permit (principal, action, resource == ?principal);",
+			line:   1, column: 40,
+			msg: "slot ?principal cannot constrain resource",
+		},
+		{
+			name:   "slot on action",
+			policy: "permit (principal, action == ?resource, resource);",
+			line:   1, column: 30,
+			msg: "cannot constrain action",
+		},
+		{
+			name:   "slot in condition",
+			policy: "permit (principal, action, resource) when { principal == ?principal };",
+			line:   1, column: 58,
+			msg: "only allowed in the policy scope",
+		},
+		{
+			name:   "bare question mark",
+			policy: "permit (principal == ?, action, resource);",
+			line:   1, column: 22,
+			msg: "expected slot name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicies(tt.policy)
+			if err == nil {
+				t.Fatal("expected syntax error")
+			}
+
+			var syntaxErr *PolicySyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected *PolicySyntaxError, got %T", err)
+			}
+			if syntaxErr.Pos.Line != tt.line || syntaxErr.Pos.Column != tt.column {
+				t.Errorf("expected error at %d:%d, got %s", tt.line, tt.column, syntaxErr.Pos)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.msg) {
+				t.Errorf("expected message containing %q, got %q", tt.msg, syntaxErr.Msg)
+			}
+		})
+	}
+}
+
+func TestParseCedarPolicyRepositoryPolicy(t *testing.T) {
+	content, err := os.ReadFile("../.cedar/ai-policy.cedar")
+	if err != nil {
+		t.Skipf("repository policy not available: %v", err)
+	}
+
+	rules, err := ParseCedarPolicy(string(content))
+	if err != nil {
+		t.Fatalf("failed to parse repository policy: %v", err)
+	}
+
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rules))
+	}
+
+	last := rules[4]
+	if last.Action != ActionPermit || last.Field != "" {
+		t.Errorf("expected trailing unconditional permit, got %+v", last)
+	}
+}
+
+func TestParseCedarPolicyRejectsMalformedPolicy(t *testing.T) {
+	policy := `
+forbid ( principal, action == Action::"deploy", resource )
+when {
+    resource.hostname == "blocked.example.com"
+`
+
+	rules, err := ParseCedarPolicy(policy)
+	if err == nil {
+		t.Fatalf("expected error for malformed policy, got %d rules", len(rules))
+	}
+}
+
+func TestEvaluatePolicyUnconditionalForbid(t *testing.T) {
+	rules, err := ParseCedarPolicy(`forbid (principal, action, resource);`)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	decision := EvaluatePolicy(RequestContext{Method: "GET", Hostname: "example.com"}, rules)
+	if decision.Decision != "Deny" {
+		t.Errorf("expected Deny from unconditional forbid, got %s", decision.Decision)
+	}
+}
+
+func TestParsePoliciesIDs(t *testing.T) {
+	policies, err := ParsePolicies(`
+@id("no-delete")
+@owner("platform-team")
+forbid (principal, action, resource) when { resource.method == "DELETE" };
+
+permit (principal, action, resource);
+`)
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+
+	if policies[0].ID != "no-delete" {
+		t.Errorf("expected id no-delete, got %q", policies[0].ID)
+	}
+	if owner, ok := policies[0].Annotation("owner"); !ok || owner != "platform-team" {
+		t.Errorf("expected owner annotation, got %q", owner)
+	}
+	if policies[1].ID != "policy1" {
+		t.Errorf("expected positional id policy1, got %q", policies[1].ID)
+	}
+}
+
+func TestParsePoliciesAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		msg    string
+	}{
+		{"duplicate id", `@id("a") permit (principal, action, resource); @id("a") forbid (principal, action, resource);`, `duplicate policy id "a"`},
+		{"id collides with position", `permit (principal, action, resource); @id("policy0") forbid (principal, action, resource);`, `duplicate policy id "policy0"`},
+		{"duplicate annotation", `@owner("a") @owner("b") permit (principal, action, resource);`, "duplicate annotation @owner"},
+		{"empty id", `@id("") permit (principal, action, resource);`, "non-empty value"},
+		{"bad enforcement", `@enforcement("loud") forbid (principal, action, resource);`, `invalid @enforcement value "loud"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicies(tt.policy)
+			var syntaxErr *PolicySyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected *PolicySyntaxError, got %v", err)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.msg) {
+				t.Errorf("expected message containing %q, got %q", tt.msg, syntaxErr.Msg)
+			}
+		})
+	}
+}
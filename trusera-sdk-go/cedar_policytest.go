@@ -0,0 +1,294 @@
+package trusera
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// PolicyTestSuite is a set of declarative policy test cases, usually loaded
+// from a JSON file with LoadPolicyTestSuite:
+//
+//	{
+//	  "policy": "egress.cedar",
+//	  "default": "Deny",
+//	  "enforcement": "block",
+//	  "tests": [
+//	    {
+//	      "name": "billing bot cannot delete",
+//	      "request": {"method": "DELETE", "url": "https://api.example.com/users/1", "principal": "billing-bot"},
+//	      "expect": {"decision": "Deny", "policy_ids": ["no-delete"], "enforcement": "blocked"}
+//	    }
+//	  ]
+//	}
+type PolicyTestSuite struct {
+	// Policy is the policy file under test, relative to the suite file
+	Policy string `json:"policy,omitempty"`
+	// Default is the decision when no policy matches, as with
+	// WithPolicyDefault; empty means DecisionAllow
+	Default string `json:"default,omitempty"`
+	// Enforcement is the interceptor's enforcement mode, used to compute the
+	// enforcement action of denied requests; empty means EnforcementLog
+	Enforcement EnforcementAction `json:"enforcement,omitempty"`
+	Tests       []PolicyTestCase  `json:"tests"`
+}
+
+// PolicyTestCase is one request and the outcome it must have
+type PolicyTestCase struct {
+	Name    string            `json:"name"`
+	Request PolicyTestRequest `json:"request"`
+	Expect  PolicyTestExpect  `json:"expect"`
+}
+
+// PolicyTestRequest describes the request of a test case. The URL fills the
+// hostname, path, scheme, port and query as for an intercepted request.
+type PolicyTestRequest struct {
+	Method      string            `json:"method,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Principal   string            `json:"principal,omitempty"`
+	Action      EventType         `json:"action,omitempty"` // defaults to EventHTTPRequest
+	Headers     map[string]string `json:"headers,omitempty"`
+	IP          string            `json:"ip,omitempty"`
+	BodySize    *int64            `json:"body_size,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+}
+
+// PolicyTestExpect is the expected outcome of a test case. Only the fields
+// that are set are checked; an empty policy_ids list expects no matches.
+type PolicyTestExpect struct {
+	Decision  string   `json:"decision,omitempty"`
+	PolicyIDs []string `json:"policy_ids,omitempty"`
+	// Enforcement is the interceptor's action: "allowed", "logged",
+	// "warned" or "blocked"
+	Enforcement string `json:"enforcement,omitempty"`
+}
+
+// PolicyTestResult is the outcome of one test case
+type PolicyTestResult struct {
+	Name        string
+	Decision    PolicyDecision
+	Enforcement string
+	// Diffs lists each expectation that did not hold, as
+	// "<field>: got <actual>, want <expected>"; empty when the case passed
+	Diffs []string
+	// Err is set when the request could not be built
+	Err error
+}
+
+// Passed reports whether the test case met all its expectations
+func (r PolicyTestResult) Passed() bool {
+	return r.Err == nil && len(r.Diffs) == 0
+}
+
+// PolicyTestReport holds the results of a test suite run
+type PolicyTestReport struct {
+	Results []PolicyTestResult
+}
+
+// Failed returns the results of the test cases that did not pass
+func (r *PolicyTestReport) Failed() []PolicyTestResult {
+	var failed []PolicyTestResult
+	for _, result := range r.Results {
+		if !result.Passed() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// String renders the report with one PASS or FAIL line per test case,
+// followed by the diffs of failures
+func (r *PolicyTestReport) String() string {
+	var sb strings.Builder
+	for _, result := range r.Results {
+		if result.Passed() {
+			fmt.Fprintf(&sb, "PASS %s\n", result.Name)
+			continue
+		}
+		fmt.Fprintf(&sb, "FAIL %s\n", result.Name)
+		if result.Err != nil {
+			fmt.Fprintf(&sb, "    error: %v\n", result.Err)
+		}
+		for _, diff := range result.Diffs {
+			fmt.Fprintf(&sb, "    %s\n", diff)
+		}
+		if len(result.Decision.Reasons) > 0 {
+			fmt.Fprintf(&sb, "    reasons: %s\n", strings.Join(result.Decision.Reasons, "; "))
+		}
+	}
+	fmt.Fprintf(&sb, "%d passed, %d failed\n", len(r.Results)-len(r.Failed()), len(r.Failed()))
+	return sb.String()
+}
+
+// LoadPolicyTestSuite reads a test suite from a JSON file. Unknown fields are
+// rejected so that typos in expectations do not silently pass. A relative
+// Policy path is resolved against the suite file's directory.
+func LoadPolicyTestSuite(path string) (*PolicyTestSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy test suite: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var suite PolicyTestSuite
+	if err := dec.Decode(&suite); err != nil {
+		return nil, fmt.Errorf("failed to parse policy test suite %s: %w", path, err)
+	}
+
+	if suite.Policy != "" && !filepath.IsAbs(suite.Policy) {
+		suite.Policy = filepath.Join(filepath.Dir(path), suite.Policy)
+	}
+	return &suite, nil
+}
+
+// RunPolicyTestFile loads a test suite and the policy file it names and runs
+// the suite against it
+func RunPolicyTestFile(path string) (*PolicyTestReport, error) {
+	suite, err := LoadPolicyTestSuite(path)
+	if err != nil {
+		return nil, err
+	}
+	if suite.Policy == "" {
+		return nil, fmt.Errorf("policy test suite %s does not name a policy file", path)
+	}
+
+	content, err := os.ReadFile(suite.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	rules, err := ParseCedarPolicy(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	return suite.Run(rules)
+}
+
+// Run evaluates each test case against rules and compares the outcome with
+// its expectations
+func (s *PolicyTestSuite) Run(rules []PolicyRule) (*PolicyTestReport, error) {
+	defaultDecision := s.Default
+	if defaultDecision == "" {
+		defaultDecision = DecisionAllow
+	}
+	if defaultDecision != DecisionAllow && defaultDecision != DecisionDeny {
+		return nil, fmt.Errorf("invalid default decision %q, use %q or %q", s.Default, DecisionAllow, DecisionDeny)
+	}
+	mode := s.Enforcement
+	if mode == "" {
+		mode = EnforcementLog
+	}
+	if _, ok := enforcementRank[mode]; !ok {
+		return nil, fmt.Errorf("invalid enforcement mode %q", s.Enforcement)
+	}
+
+	report := &PolicyTestReport{Results: make([]PolicyTestResult, 0, len(s.Tests))}
+	for i, tc := range s.Tests {
+		result := PolicyTestResult{Name: tc.Name}
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("test %d", i)
+		}
+
+		ctx, err := tc.Request.context()
+		if err != nil {
+			result.Err = err
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		result.Decision = EvaluatePolicy(ctx, rules, WithDefaultDecision(defaultDecision))
+		result.Enforcement = "allowed"
+		if result.Decision.Decision == DecisionDeny {
+			result.Enforcement = enforcementOutcome(enforcementMode(result.Decision, mode))
+		}
+
+		want := tc.Expect
+		if want.Decision != "" && want.Decision != result.Decision.Decision {
+			result.Diffs = append(result.Diffs, fmt.Sprintf("decision: got %s, want %s", result.Decision.Decision, want.Decision))
+		}
+		if want.PolicyIDs != nil {
+			got := result.Decision.PolicyIDs
+			if got == nil {
+				got = []string{}
+			}
+			if !reflect.DeepEqual(got, want.PolicyIDs) {
+				result.Diffs = append(result.Diffs, fmt.Sprintf("policy_ids: got %v, want %v", got, want.PolicyIDs))
+			}
+		}
+		if want.Enforcement != "" && want.Enforcement != result.Enforcement {
+			result.Diffs = append(result.Diffs, fmt.Sprintf("enforcement: got %s, want %s", result.Enforcement, want.Enforcement))
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// context builds the evaluation context of a test request
+func (r PolicyTestRequest) context() (RequestContext, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, r.URL, nil)
+	if err != nil {
+		return RequestContext{}, fmt.Errorf("invalid request: %w", err)
+	}
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+
+	ctx := newRequestContext(req)
+	ctx.Principal = r.Principal
+	if r.Action != "" {
+		ctx.Action = r.Action
+	}
+	if r.IP != "" {
+		ip, err := netip.ParseAddr(r.IP)
+		if err != nil {
+			return RequestContext{}, fmt.Errorf("invalid ip: %w", err)
+		}
+		ctx.IP = ip.Unmap()
+	}
+	if r.BodySize != nil {
+		ctx.ContentLength = *r.BodySize
+	}
+	return ctx, nil
+}
+
+// PolicyTestingT is the part of *testing.T used by CheckPolicyTests
+type PolicyTestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+}
+
+// CheckPolicyTests runs a policy test suite file from a Go test, reporting
+// each failed case with its diffs:
+//
+//	func TestEgressPolicy(t *testing.T) {
+//		trusera.CheckPolicyTests(t, "testdata/egress_test.json")
+//	}
+func CheckPolicyTests(t PolicyTestingT, path string) {
+	t.Helper()
+	report, err := RunPolicyTestFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+		return
+	}
+	for _, result := range report.Failed() {
+		msg := strings.Join(result.Diffs, "\n    ")
+		if result.Err != nil {
+			msg = result.Err.Error()
+		}
+		t.Errorf("policy test %q failed:\n    %s", result.Name, msg)
+	}
+}
@@ -0,0 +1,188 @@
+package trusera
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const policyTestPolicy = `
+@id("no-delete")
+forbid (principal, action, resource) when { resource.method == "DELETE" };
+
+@id("no-metadata")
+@enforcement("warn")
+forbid (principal, action, resource) when { resource.ip.isInRange(ip("169.254.0.0/16")) };
+
+@id("billing-stripe")
+permit (principal == Agent::"billing-bot", action, resource in Host::"stripe.com");
+`
+
+// writePolicyTestSuite writes the test policy and a suite to a temporary
+// directory and returns the suite path
+func writePolicyTestSuite(t *testing.T, suite string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "policy.cedar"), []byte(policyTestPolicy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	path := filepath.Join(dir, "policy_test.json")
+	if err := os.WriteFile(path, []byte(suite), 0644); err != nil {
+		t.Fatalf("failed to write suite: %v", err)
+	}
+	return path
+}
+
+func TestRunPolicyTestFile(t *testing.T) {
+	path := writePolicyTestSuite(t, `{
+  "policy": "policy.cedar",
+  "default": "Deny",
+  "enforcement": "block",
+  "tests": [
+    {
+      "name": "billing bot reaches stripe",
+      "request": {"method": "POST", "url": "https://api.stripe.com/v1/charges", "principal": "billing-bot"},
+      "expect": {"decision": "Allow", "policy_ids": ["billing-stripe"], "enforcement": "allowed"}
+    },
+    {
+      "name": "deletes are blocked",
+      "request": {"method": "DELETE", "url": "https://api.stripe.com/v1/charges/1", "principal": "billing-bot"},
+      "expect": {"decision": "Deny", "policy_ids": ["no-delete"], "enforcement": "blocked"}
+    },
+    {
+      "name": "metadata only warns",
+      "request": {"url": "http://metadata.internal/latest", "ip": "169.254.169.254"},
+      "expect": {"decision": "Deny", "policy_ids": ["no-metadata"], "enforcement": "warned"}
+    },
+    {
+      "name": "default deny",
+      "request": {"url": "https://example.com/", "principal": "support-bot", "action": "tool_call", "headers": {"X-Test": "1"}, "body_size": 10},
+      "expect": {"decision": "Deny", "policy_ids": []}
+    }
+  ]
+}`)
+
+	report, err := RunPolicyTestFile(path)
+	if err != nil {
+		t.Fatalf("failed to run suite: %v", err)
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(report.Results))
+	}
+	if failed := report.Failed(); len(failed) != 0 {
+		t.Errorf("expected all cases to pass:\n%s", report)
+	}
+	if !strings.HasSuffix(report.String(), "4 passed, 0 failed\n") {
+		t.Errorf("unexpected summary:\n%s", report)
+	}
+}
+
+func TestRunPolicyTestFileReportsDiffs(t *testing.T) {
+	path := writePolicyTestSuite(t, `{
+  "policy": "policy.cedar",
+  "tests": [
+    {
+      "name": "expects block",
+      "request": {"method": "DELETE", "url": "https://example.com/x"},
+      "expect": {"decision": "Deny", "policy_ids": ["no-delete", "other"], "enforcement": "blocked"}
+    },
+    {
+      "request": {"url": "https://example.com/x"},
+      "expect": {"decision": "Deny"}
+    },
+    {
+      "name": "bad ip",
+      "request": {"url": "https://example.com/x", "ip": "not-an-ip"},
+      "expect": {"decision": "Allow"}
+    }
+  ]
+}`)
+
+	report, err := RunPolicyTestFile(path)
+	if err != nil {
+		t.Fatalf("failed to run suite: %v", err)
+	}
+	failed := report.Failed()
+	if len(failed) != 3 {
+		t.Fatalf("expected 3 failures, got %d:\n%s", len(failed), report)
+	}
+
+	wantDiffs := []string{
+		"policy_ids: got [no-delete], want [no-delete other]",
+		"enforcement: got logged, want blocked",
+	}
+	if strings.Join(failed[0].Diffs, "\n") != strings.Join(wantDiffs, "\n") {
+		t.Errorf("unexpected diffs: %q", failed[0].Diffs)
+	}
+	if failed[1].Name != "test 1" || len(failed[1].Diffs) != 1 || failed[1].Diffs[0] != "decision: got Allow, want Deny" {
+		t.Errorf("unexpected result for unnamed case: %+v", failed[1])
+	}
+	if failed[2].Err == nil || !strings.Contains(failed[2].Err.Error(), "invalid ip") {
+		t.Errorf("expected request error, got %v", failed[2].Err)
+	}
+	if s := report.String(); !strings.Contains(s, "FAIL expects block\n    policy_ids:") || !strings.Contains(s, "0 passed, 3 failed") {
+		t.Errorf("unexpected report:\n%s", s)
+	}
+}
+
+func TestLoadPolicyTestSuiteErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		suite string
+		msg   string
+	}{
+		{"unknown field", `{"policy": "policy.cedar", "tests": [{"request": {}, "expect": {"decison": "Deny"}}]}`, `unknown field "decison"`},
+		{"no policy", `{"tests": []}`, "does not name a policy file"},
+		{"bad default", `{"policy": "policy.cedar", "default": "deny", "tests": []}`, `invalid default decision "deny"`},
+		{"bad enforcement", `{"policy": "policy.cedar", "enforcement": "loud", "tests": []}`, `invalid enforcement mode "loud"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RunPolicyTestFile(writePolicyTestSuite(t, tt.suite))
+			if err == nil || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("expected error containing %q, got %v", tt.msg, err)
+			}
+		})
+	}
+}
+
+// recordingT records the failures CheckPolicyTests reports
+type recordingT struct {
+	errors []string
+	fatal  string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Fatalf(format string, args ...any) {
+	r.fatal = fmt.Sprintf(format, args...)
+}
+
+func TestCheckPolicyTests(t *testing.T) {
+	path := writePolicyTestSuite(t, `{
+  "policy": "policy.cedar",
+  "tests": [
+    {"name": "passes", "request": {"method": "DELETE", "url": "https://example.com/"}, "expect": {"decision": "Deny"}},
+    {"name": "fails", "request": {"method": "GET", "url": "https://example.com/"}, "expect": {"decision": "Deny"}}
+  ]
+}`)
+
+	rec := &recordingT{}
+	CheckPolicyTests(rec, path)
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], `policy test "fails" failed`) || !strings.Contains(rec.errors[0], "decision: got Allow, want Deny") {
+		t.Errorf("unexpected failures: %q", rec.errors)
+	}
+
+	rec = &recordingT{}
+	CheckPolicyTests(rec, filepath.Join(t.TempDir(), "missing.json"))
+	if !strings.Contains(rec.fatal, "failed to read policy test suite") {
+		t.Errorf("expected fatal error, got %q", rec.fatal)
+	}
+}
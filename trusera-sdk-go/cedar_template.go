@@ -0,0 +1,94 @@
+package trusera
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LinkTemplate creates a policy from a template by filling each of its slots
+// with an entity: an Agent for ?principal and a Host for ?resource. The
+// linked policy gets the given id and records the template's ID and the slot
+// values; its Raw text is the template text with the slots filled in. The
+// template itself is not modified.
+func LinkTemplate(template *Policy, id string, values map[SlotID]EntityRef) (*Policy, error) {
+	if template == nil || !template.IsTemplate() {
+		return nil, fmt.Errorf("policy is not a template")
+	}
+	if id == "" {
+		return nil, fmt.Errorf("linked policy id must not be empty")
+	}
+
+	slots := template.Slots()
+	for slot := range values {
+		found := false
+		for _, s := range slots {
+			found = found || s == slot
+		}
+		if !found {
+			return nil, fmt.Errorf("template %q has no slot %s", template.ID, slot)
+		}
+	}
+
+	linked := *template
+	linked.ID = id
+	linked.TemplateID = template.ID
+	linked.SlotValues = make(map[SlotID]EntityRef, len(slots))
+	linked.Annotations = nil
+	for _, ann := range template.Annotations {
+		if ann.Key != "id" {
+			linked.Annotations = append(linked.Annotations, ann)
+		}
+	}
+
+	type fill struct {
+		offset int
+		slot   SlotID
+		text   string
+	}
+	var fills []fill
+	for _, sc := range []*ScopeConstraint{&linked.Scope.Principal, &linked.Scope.Resource} {
+		if sc.Slot == "" {
+			continue
+		}
+		ref, ok := values[sc.Slot]
+		if !ok {
+			return nil, fmt.Errorf("missing value for slot %s of template %q", sc.Slot, template.ID)
+		}
+		entityType := principalEntityType
+		if sc.Slot == SlotResource {
+			entityType = resourceEntityType
+		}
+		if ref.ID == "" || !entityTypeMatches(ref.Type, entityType) {
+			return nil, fmt.Errorf("slot %s requires an entity of type %s, got %s", sc.Slot, entityType, ref)
+		}
+
+		linked.SlotValues[sc.Slot] = ref
+		fills = append(fills, fill{offset: sc.SlotPos.Offset, slot: sc.Slot, text: ref.String()})
+		sc.Entities = []EntityRef{ref}
+		sc.Slot = ""
+		sc.SlotPos = Position{}
+	}
+
+	// Substitute from the end so earlier offsets stay valid
+	start := policyStart(template).Offset
+	sort.Slice(fills, func(i, j int) bool { return fills[i].offset > fills[j].offset })
+	raw := template.Raw
+	for _, f := range fills {
+		at := f.offset - start
+		if at < 0 || at+len(f.slot) > len(raw) || raw[at:at+len(f.slot)] != string(f.slot) {
+			// Hand-built template without matching source text
+			raw = strings.ReplaceAll(raw, string(f.slot), f.text)
+			continue
+		}
+		raw = raw[:at] + f.text + raw[at+len(f.slot):]
+	}
+	linked.Raw = raw
+
+	return &linked, nil
+}
+
+// Rule wraps the policy as a rule for EvaluatePolicy and CompilePolicy
+func (p *Policy) Rule() PolicyRule {
+	return ruleFromPolicy(p)
+}
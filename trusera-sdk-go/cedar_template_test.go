@@ -0,0 +1,155 @@
+package trusera
+
+import (
+	"strings"
+	"testing"
+)
+
+const templateTestPolicy = `
+@id("agent-host")
+@owner("platform-team")
+permit (principal == ?principal, action, resource in ?resource)
+when { resource.method == "GET" };
+
+@id("agent-anywhere")
+forbid (principal in ?principal, action == Action::"tool_call", resource);
+
+@id("static")
+forbid (principal, action, resource) when { resource.path like "/admin/*" };
+`
+
+func TestParsePoliciesTemplates(t *testing.T) {
+	policies, err := ParsePolicies(templateTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+
+	tpl := policies[0]
+	if !tpl.IsTemplate() {
+		t.Fatal("expected agent-host to be a template")
+	}
+	if slots := tpl.Slots(); len(slots) != 2 || slots[0] != SlotPrincipal || slots[1] != SlotResource {
+		t.Errorf("expected both slots, got %v", slots)
+	}
+	if sc := tpl.Scope.Resource; sc.Op != ScopeIn || sc.Slot != SlotResource || sc.SlotPos.Line != 4 {
+		t.Errorf("unexpected resource constraint %+v", sc)
+	}
+	if policies[2].IsTemplate() {
+		t.Error("expected static policy not to be a template")
+	}
+
+	rules, err := ParseCedarPolicy(templateTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	if len(rules) != 1 || rules[0].ID != "static" {
+		t.Errorf("expected only the static rule, got %d rules", len(rules))
+	}
+}
+
+func TestLinkTemplate(t *testing.T) {
+	policies, err := ParsePolicies(templateTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+	tpl := policies[0]
+
+	linked, err := LinkTemplate(tpl, "billing-stripe", map[SlotID]EntityRef{
+		SlotPrincipal: {Type: "Agent", ID: "billing-bot"},
+		SlotResource:  {Type: "Trusera::Host", ID: "stripe.com"},
+	})
+	if err != nil {
+		t.Fatalf("failed to link template: %v", err)
+	}
+
+	if linked.ID != "billing-stripe" || linked.TemplateID != "agent-host" || linked.IsTemplate() {
+		t.Errorf("unexpected linked policy %q from %q", linked.ID, linked.TemplateID)
+	}
+	if _, ok := linked.Annotation("id"); ok {
+		t.Error("expected the template's @id annotation to be dropped")
+	}
+	if owner, _ := linked.Annotation("owner"); owner != "platform-team" {
+		t.Errorf("expected other annotations to be kept, got owner %q", owner)
+	}
+	if got := linked.SlotValues[SlotResource].ID; got != "stripe.com" {
+		t.Errorf("expected resource slot value stripe.com, got %q", got)
+	}
+	wantRaw := `permit (principal == Agent::"billing-bot", action, resource in Trusera::Host::"stripe.com")`
+	if !strings.Contains(linked.Raw, wantRaw) {
+		t.Errorf("expected raw text with filled slots, got:\n%s", linked.Raw)
+	}
+	if tpl.Scope.Principal.Slot != SlotPrincipal || len(tpl.Scope.Principal.Entities) != 0 {
+		t.Error("expected the template to be left unchanged")
+	}
+
+	// The linked text parses back to the same scope
+	reparsed, err := ParsePolicies(linked.Raw)
+	if err != nil {
+		t.Fatalf("failed to parse linked raw text: %v", err)
+	}
+	if reparsed[0].IsTemplate() || reparsed[0].Scope.Resource.Entities[0] != linked.Scope.Resource.Entities[0] {
+		t.Errorf("expected reparsed scope to match, got %+v", reparsed[0].Scope.Resource)
+	}
+
+	rules := []PolicyRule{linked.Rule()}
+	compiled := CompilePolicy(rules, WithDefaultDecision(DecisionDeny))
+	tests := []struct {
+		principal, hostname, method string
+		want                        string
+	}{
+		{"billing-bot", "api.stripe.com", "GET", DecisionAllow},
+		{"billing-bot", "stripe.com", "GET", DecisionAllow},
+		{"billing-bot", "stripe.com", "POST", DecisionDeny},
+		{"support-bot", "stripe.com", "GET", DecisionDeny},
+		{"billing-bot", "evil.test", "GET", DecisionDeny},
+	}
+	for _, tt := range tests {
+		ctx := RequestContext{Principal: tt.principal, Hostname: tt.hostname, Method: tt.method}
+		if got := EvaluatePolicy(ctx, rules, WithDefaultDecision(DecisionDeny)).Decision; got != tt.want {
+			t.Errorf("%s %s %s: expected %s, got %s", tt.principal, tt.method, tt.hostname, tt.want, got)
+		}
+		if got := compiled.Evaluate(ctx).Decision; got != tt.want {
+			t.Errorf("%s %s %s: compiled expected %s, got %s", tt.principal, tt.method, tt.hostname, tt.want, got)
+		}
+	}
+
+	explanation := Explain(RequestContext{Principal: "billing-bot", Hostname: "evil.test", Method: "GET"}, rules)
+	if note := explanation.Rules[0].ScopeNote; !strings.Contains(note, `resource Host::"evil.test" is outside the policy scope`) {
+		t.Errorf("expected resource scope note, got %q", note)
+	}
+}
+
+func TestLinkTemplateErrors(t *testing.T) {
+	policies, err := ParsePolicies(templateTestPolicy)
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+	agentHost, agentAnywhere, static := policies[0], policies[1], policies[2]
+	agent := EntityRef{Type: "Agent", ID: "billing-bot"}
+	host := EntityRef{Type: "Host", ID: "stripe.com"}
+
+	tests := []struct {
+		name     string
+		template *Policy
+		id       string
+		values   map[SlotID]EntityRef
+		msg      string
+	}{
+		{"not a template", static, "x", map[SlotID]EntityRef{SlotPrincipal: agent}, "not a template"},
+		{"empty id", agentAnywhere, "", map[SlotID]EntityRef{SlotPrincipal: agent}, "must not be empty"},
+		{"missing slot", agentHost, "x", map[SlotID]EntityRef{SlotPrincipal: agent}, "missing value for slot ?resource"},
+		{"extra slot", agentAnywhere, "x", map[SlotID]EntityRef{SlotPrincipal: agent, SlotResource: host}, "has no slot ?resource"},
+		{"wrong principal type", agentAnywhere, "x", map[SlotID]EntityRef{SlotPrincipal: host}, "requires an entity of type Agent"},
+		{"wrong resource type", agentHost, "x", map[SlotID]EntityRef{SlotPrincipal: agent, SlotResource: agent}, "requires an entity of type Host"},
+		{"empty entity id", agentAnywhere, "x", map[SlotID]EntityRef{SlotPrincipal: {Type: "Agent"}}, "requires an entity of type Agent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LinkTemplate(tt.template, tt.id, tt.values)
+			if err == nil || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("expected error containing %q, got %v", tt.msg, err)
+			}
+		})
+	}
+}
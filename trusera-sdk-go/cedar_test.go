@@ -313,14 +313,20 @@ when {
 		t.Fatalf("failed to parse policy: %v", err)
 	}
 
-	if len(rules) != 3 {
-		t.Errorf("expected 3 rules, got %d", len(rules))
+	// All clauses of one when block belong to a single rule
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(rules))
+	}
+
+	clauses := rules[0].Policy.Conditions[0].Clauses
+	if len(clauses) != 3 {
+		t.Fatalf("expected 3 clauses, got %d", len(clauses))
 	}
 
 	expectedOps := []PolicyOperator{OpNotEqual, OpGreaterThanOrEqual, OpLessThanOrEqual}
 	for i, expected := range expectedOps {
-		if rules[i].Operator != expected {
-			t.Errorf("rule %d: expected operator %s, got %s", i, expected, rules[i].Operator)
+		if op := clauses[i].(*BinaryExpr).Op; op != expected {
+			t.Errorf("clause %d: expected operator %s, got %s", i, expected, op)
 		}
 	}
 }
@@ -0,0 +1,433 @@
+package trusera
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AttributeType names the Cedar type of a schema attribute
+type AttributeType string
+
+const (
+	AttrString  AttributeType = "string"
+	AttrLong    AttributeType = "long"
+	AttrDecimal AttributeType = "decimal"
+	AttrBool    AttributeType = "bool"
+	AttrEntity  AttributeType = "entity"
+	AttrIPAddr  AttributeType = "ipaddr"
+	AttrSet     AttributeType = "set"
+	AttrRecord  AttributeType = "record" // record of string values, such as resource.header
+)
+
+// Schema declares the entity types policies may name and, per action, the
+// resource attributes requests with that action carry
+type Schema struct {
+	PrincipalType string                              // entity type of principals, "Agent"
+	Actions       map[string]map[string]AttributeType // action ID -> attribute name -> type
+}
+
+// requestAttributes are the resource attributes resourceAttribute resolves
+// from a RequestContext
+var requestAttributes = map[string]AttributeType{
+	"url":          AttrString,
+	"method":       AttrString,
+	"hostname":     AttrString,
+	"path":         AttrString,
+	"scheme":       AttrString,
+	"port":         AttrLong,
+	"ip":           AttrIPAddr,
+	"header":       AttrRecord,
+	"query":        AttrRecord,
+	"body_size":    AttrLong,
+	"content_type": AttrString,
+}
+
+// schemaActions are the actions a RequestContext can carry
+var schemaActions = []EventType{EventHTTPRequest, EventToolCall, EventLLMInvoke, EventFileWrite}
+
+// DefaultSchema returns the schema of the attributes the evaluator resolves.
+// Every action is evaluated against a RequestContext, so every action
+// carries the same resource attributes.
+func DefaultSchema() *Schema {
+	actions := make(map[string]map[string]AttributeType, len(schemaActions))
+	for _, action := range schemaActions {
+		attrs := make(map[string]AttributeType, len(requestAttributes))
+		for name, t := range requestAttributes {
+			attrs[name] = t
+		}
+		actions[string(action)] = attrs
+	}
+	return &Schema{PrincipalType: principalEntityType, Actions: actions}
+}
+
+// ValidationSeverity distinguishes problems that break a rule from suspicious constructs
+type ValidationSeverity string
+
+const (
+	SeverityError   ValidationSeverity = "error"
+	SeverityWarning ValidationSeverity = "warning"
+)
+
+// ValidationIssue is a problem found by ValidatePolicy
+type ValidationIssue struct {
+	PolicyID string
+	Pos      Position
+	Severity ValidationSeverity
+	Message  string
+}
+
+// String formats the issue as "line:col: severity: message (policy id)"
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", i.Pos, i.Severity, i.Message, i.PolicyID)
+}
+
+// PolicyValidationError reports the validation errors that prevented a policy from loading
+type PolicyValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e *PolicyValidationError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue.String()
+	}
+	return "cedar policy validation failed: " + strings.Join(msgs, "; ")
+}
+
+// ValidatePolicy parses policy text and checks it against a schema (nil for
+// DefaultSchema), reporting unknown attributes, entity types and actions,
+// type mismatches and rules that can never match. Syntax errors are returned
+// as the error. Policies are checked as ParseCedarPolicy loads them, with
+// the legacy Action::"deploy" placeholder matching every action.
+func ValidatePolicy(policyText string, schema *Schema) ([]ValidationIssue, error) {
+	policies, err := ParsePolicies(policyText)
+	if err != nil {
+		return nil, err
+	}
+	migrateLegacyActionScope(policies, legacyAction)
+	return validatePolicies(policies, schema), nil
+}
+
+// validatePolicies checks parsed policies against a schema, nil for DefaultSchema
+func validatePolicies(policies []*Policy, schema *Schema) []ValidationIssue {
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	var issues []ValidationIssue
+	for _, policy := range policies {
+		v := &validator{schema: schema, policy: policy}
+		v.validate()
+		issues = append(issues, v.issues...)
+	}
+	return issues
+}
+
+// validationErrors returns the issues of error severity
+func validationErrors(issues []ValidationIssue) []ValidationIssue {
+	var errs []ValidationIssue
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue)
+		}
+	}
+	return errs
+}
+
+// validator type-checks one policy
+type validator struct {
+	schema  *Schema
+	policy  *Policy
+	actions []string // actions the policy can apply to
+	issues  []ValidationIssue
+}
+
+func (v *validator) report(pos Position, severity ValidationSeverity, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{
+		PolicyID: v.policy.ID,
+		Pos:      pos,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate() {
+	if !v.validateScope() {
+		return
+	}
+	for _, cond := range v.policy.Conditions {
+		for _, clause := range cond.Clauses {
+			if t := v.check(clause); t != "" && t != AttrBool {
+				v.report(clause.Position(), SeverityError, "condition must be boolean, got %s", t)
+			}
+			if b, ok := constBool(clause); ok && b == (cond.Kind == ConditionUnless) {
+				v.report(clause.Position(), SeverityWarning, "%s clause is always %v, so the policy can never match", cond.Kind, b)
+			}
+		}
+	}
+}
+
+// validateScope checks the policy head and collects the actions it applies to.
+// It reports false when the head can never match, skipping condition checks.
+func (v *validator) validateScope() bool {
+	scope := v.policy.Scope
+	reachable := true
+
+	if scope.Principal.Op != ScopeAny && scope.Principal.Slot == "" {
+		matches := 0
+		for _, ref := range scope.Principal.Entities {
+			if entityTypeMatches(ref.Type, v.schema.PrincipalType) {
+				matches++
+			} else {
+				v.report(scope.Principal.Pos, SeverityError, "unknown principal entity type %q, expected %s", ref.Type, v.schema.PrincipalType)
+			}
+		}
+		reachable = matches > 0
+	}
+
+	if scope.Resource.Op != ScopeAny && scope.Resource.Slot == "" {
+		for _, ref := range scope.Resource.Entities {
+			if !entityTypeMatches(ref.Type, resourceEntityType) {
+				v.report(scope.Resource.Pos, SeverityError, "unknown resource entity type %q, expected %s", ref.Type, resourceEntityType)
+				reachable = false
+			}
+		}
+	}
+
+	if scope.Action.Op == ScopeAny {
+		v.actions = v.schema.actionIDs()
+		return reachable
+	}
+	for _, ref := range scope.Action.Entities {
+		switch {
+		case !entityTypeMatches(ref.Type, actionEntityType):
+			v.report(scope.Action.Pos, SeverityError, "unknown action entity type %q, expected %s", ref.Type, actionEntityType)
+		case v.schema.Actions[ref.ID] == nil && ref.ID == legacyAction:
+			// The placeholder of policy files from before action scoping,
+			// loaded as written with WithLegacyActionScope("")
+			v.report(scope.Action.Pos, SeverityError, "unknown action %q; older policy files used it to match every action, use an unconstrained action instead (see MigrateLegacyActionScope)", ref.ID)
+		case v.schema.Actions[ref.ID] == nil:
+			v.report(scope.Action.Pos, SeverityError, "unknown action %q", ref.ID)
+		default:
+			v.actions = append(v.actions, ref.ID)
+		}
+	}
+	return reachable && len(v.actions) > 0
+}
+
+// actionIDs lists the schema's actions in a stable order
+func (s *Schema) actionIDs() []string {
+	ids := make([]string, 0, len(s.Actions))
+	for id := range s.Actions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// resourceAttr resolves a resource attribute across the policy's actions,
+// reporting it when no action defines it
+func (v *validator) resourceAttr(pos Position, name string) (AttributeType, bool) {
+	var found AttributeType
+	for _, action := range v.actions {
+		t, ok := v.schema.Actions[action][name]
+		if !ok {
+			continue
+		}
+		if found != "" && found != t {
+			return "", true // conflicting types across actions
+		}
+		found = t
+	}
+	if found == "" {
+		if len(v.actions) == 1 {
+			v.report(pos, SeverityError, "unknown attribute resource.%s for action %q", name, v.actions[0])
+		} else {
+			v.report(pos, SeverityError, "unknown attribute resource.%s", name)
+		}
+		return "", false
+	}
+	return found, true
+}
+
+// check infers the type of an expression, reporting problems along the way.
+// An empty type means unknown, and suppresses follow-on reports.
+func (v *validator) check(e Expr) AttributeType {
+	switch e := e.(type) {
+	case *LiteralExpr:
+		switch e.Value.(type) {
+		case string:
+			return AttrString
+		case int64:
+			return AttrLong
+		case float64:
+			return AttrDecimal
+		case bool:
+			return AttrBool
+		}
+
+	case *EntityExpr:
+		return AttrEntity
+
+	case *VarExpr:
+		switch e.Name {
+		case "principal", "action":
+			return AttrEntity
+		}
+		v.report(e.Pos, SeverityError, "%s cannot be used as a value", e.Name)
+
+	case *AttrExpr:
+		return v.checkAttr(e.Object, e.Attr, e.Pos)
+
+	case *HasExpr:
+		v.checkAttr(e.Object, e.Attr, e.Pos)
+		return AttrBool
+
+	case *LikeExpr:
+		v.expect(e.Operand, "like", AttrString)
+		return AttrBool
+
+	case *SetExpr:
+		for _, elem := range e.Elems {
+			v.check(elem)
+		}
+		return AttrSet
+
+	case *CallExpr:
+		v.expect(e.Args[0], e.Func, AttrString)
+		return AttrIPAddr
+
+	case *MethodCallExpr:
+		return v.checkMethod(e)
+
+	case *UnaryExpr:
+		v.expect(e.Operand, string(e.Op), AttrBool)
+		return AttrBool
+
+	case *BinaryExpr:
+		v.checkBinary(e)
+		return AttrBool
+	}
+	return ""
+}
+
+// checkAttr resolves obj.attr, for resource attributes and fields of records
+func (v *validator) checkAttr(obj Expr, attr string, pos Position) AttributeType {
+	if vr, ok := obj.(*VarExpr); ok {
+		if vr.Name != "resource" {
+			v.report(pos, SeverityError, "%s has no attributes", vr.Name)
+			return ""
+		}
+		t, _ := v.resourceAttr(pos, attr)
+		return t
+	}
+
+	switch t := v.check(obj); t {
+	case "":
+		return ""
+	case AttrRecord:
+		return AttrString
+	default:
+		v.report(pos, SeverityError, "cannot access attribute %q of %s", attr, t)
+		return ""
+	}
+}
+
+// checkMethod checks a method call against the receiver type
+func (v *validator) checkMethod(e *MethodCallExpr) AttributeType {
+	switch e.Method {
+	case "contains":
+		v.expect(e.Receiver, e.Method, AttrSet)
+		v.check(e.Args[0])
+	case "containsAll", "containsAny":
+		v.expect(e.Receiver, e.Method, AttrSet)
+		v.expect(e.Args[0], e.Method, AttrSet)
+	case "isInRange":
+		v.expect(e.Receiver, e.Method, AttrIPAddr)
+		v.expect(e.Args[0], e.Method, AttrIPAddr)
+	default:
+		v.expect(e.Receiver, e.Method, AttrIPAddr)
+	}
+	return AttrBool
+}
+
+// checkBinary checks the operand types of a binary operator
+func (v *validator) checkBinary(e *BinaryExpr) {
+	switch e.Op {
+	case OpAnd, OpOr:
+		v.expect(e.Left, string(e.Op), AttrBool)
+		v.expect(e.Right, string(e.Op), AttrBool)
+
+	case OpIn:
+		left := v.check(e.Left)
+		switch right := v.check(e.Right); right {
+		case "", AttrSet:
+		case AttrEntity:
+			if left != "" && left != AttrEntity {
+				v.report(e.Pos, SeverityError, "in with an entity on the right expects an entity on the left, got %s", left)
+			}
+		default:
+			v.report(e.Pos, SeverityError, "in expects a set or entity on the right, got %s", right)
+		}
+
+	case OpEqual, OpNotEqual:
+		left, right := v.check(e.Left), v.check(e.Right)
+		switch {
+		case left == "" || right == "" || left == right:
+		case isNumericType(left) && isNumericType(right):
+		case isNumericType(left) && right == AttrString, left == AttrString && isNumericType(right):
+			v.report(e.Pos, SeverityWarning, "compares %s with %s; the string is parsed as a number", left, right)
+		default:
+			v.report(e.Pos, SeverityError, "compares %s with %s, which is never equal", left, right)
+		}
+
+	default:
+		left, right := v.check(e.Left), v.check(e.Right)
+		for _, t := range []AttributeType{left, right} {
+			if t != "" && !isNumericType(t) {
+				v.report(e.Pos, SeverityError, "%s is not defined for %s, expected numbers", e.Op, t)
+				break
+			}
+		}
+	}
+}
+
+// expect checks e and reports it unless its type is want
+func (v *validator) expect(e Expr, what string, want AttributeType) {
+	if got := v.check(e); got != "" && got != want {
+		v.report(e.Position(), SeverityError, "%s expects %s, got %s", what, want, got)
+	}
+}
+
+// isNumericType reports whether t is long or decimal
+func isNumericType(t AttributeType) bool {
+	return t == AttrLong || t == AttrDecimal
+}
+
+// constBool folds boolean literals combined with !, && and ||
+func constBool(e Expr) (bool, bool) {
+	switch e := e.(type) {
+	case *LiteralExpr:
+		b, ok := e.Value.(bool)
+		return b, ok
+	case *UnaryExpr:
+		b, ok := constBool(e.Operand)
+		return !b, ok
+	case *BinaryExpr:
+		left, lok := constBool(e.Left)
+		right, rok := constBool(e.Right)
+		switch e.Op {
+		case OpAnd:
+			if (lok && !left) || (rok && !right) {
+				return false, true
+			}
+			return true, lok && rok
+		case OpOr:
+			if (lok && left) || (rok && right) {
+				return true, true
+			}
+			return false, lok && rok
+		}
+	}
+	return false, false
+}
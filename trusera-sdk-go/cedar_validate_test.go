@@ -0,0 +1,250 @@
+package trusera
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		severity ValidationSeverity
+		line     int
+		column   int
+		msg      string
+	}{
+		{
+			name:     "unknown attribute",
+			policy:   "forbid (principal, action, resource)\nwhen { resource.hostnme == \"pastebin.com\" };",
+			severity: SeverityError,
+			line:     2, column: 16,
+			msg: "unknown attribute resource.hostnme",
+		},
+		{
+			name:     "attribute the evaluator never provides",
+			policy:   `forbid (principal, action == Action::"llm_invoke", resource) when { resource.model == "gpt-4" };`,
+			severity: SeverityError,
+			line:     1, column: 77,
+			msg: `unknown attribute resource.model for action "llm_invoke"`,
+		},
+		{
+			name:     "string compared with >",
+			policy:   `forbid (principal, action, resource) when { resource.method > "GET" };`,
+			severity: SeverityError,
+			line:     1, column: 61,
+			msg: "> is not defined for string, expected numbers",
+		},
+		{
+			name:     "never equal",
+			policy:   `forbid (principal, action, resource) when { resource.ip == "10.0.0.1" };`,
+			severity: SeverityError,
+			line:     1, column: 57,
+			msg: "compares ipaddr with string",
+		},
+		{
+			name:     "coerced comparison",
+			policy:   `forbid (principal, action, resource) when { resource.port == "8080" };`,
+			severity: SeverityWarning,
+			line:     1, column: 59,
+			msg: "the string is parsed as a number",
+		},
+		{
+			name:     "like on a number",
+			policy:   `forbid (principal, action, resource) when { resource.port like "80*" };`,
+			severity: SeverityError,
+			line:     1, column: 53,
+			msg: "like expects string, got long",
+		},
+		{
+			name:     "method on wrong type",
+			policy:   `forbid (principal, action, resource) when { resource.hostname.isLoopback() };`,
+			severity: SeverityError,
+			line:     1, column: 53,
+			msg: "isLoopback expects ipaddr, got string",
+		},
+		{
+			name:     "non-boolean condition",
+			policy:   `forbid (principal, action, resource) when { resource.path };`,
+			severity: SeverityError,
+			line:     1, column: 53,
+			msg: "condition must be boolean",
+		},
+		{
+			name:     "unknown action",
+			policy:   `forbid (principal, action == Action::"http_reqest", resource);`,
+			severity: SeverityError,
+			line:     1, column: 20,
+			msg: `unknown action "http_reqest"`,
+		},
+		{
+			name:     "unknown principal type",
+			policy:   `forbid (principal == User::"alice", action, resource);`,
+			severity: SeverityError,
+			line:     1, column: 9,
+			msg: `unknown principal entity type "User"`,
+		},
+		{
+			name:     "unreachable condition",
+			policy:   `forbid (principal, action, resource) when { false && resource.method == "GET" };`,
+			severity: SeverityWarning,
+			line:     1, column: 51,
+			msg: "can never match",
+		},
+		{
+			name:     "unreachable unless",
+			policy:   `forbid (principal, action, resource) unless { !false };`,
+			severity: SeverityWarning,
+			line:     1, column: 47,
+			msg: "can never match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := ValidatePolicy(tt.policy, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(issues) != 1 {
+				t.Fatalf("expected 1 issue, got %v", issues)
+			}
+			issue := issues[0]
+			if issue.Severity != tt.severity {
+				t.Errorf("expected %s, got %s", tt.severity, issue.Severity)
+			}
+			if issue.Pos.Line != tt.line || issue.Pos.Column != tt.column {
+				t.Errorf("expected issue at %d:%d, got %s", tt.line, tt.column, issue.Pos)
+			}
+			if !strings.Contains(issue.Message, tt.msg) {
+				t.Errorf("expected message containing %q, got %q", tt.msg, issue.Message)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyClean(t *testing.T) {
+	policy := `
+@id("ssrf")
+forbid (principal == Agent::"billing-bot", action == Action::"http_request", resource)
+when {
+    resource.ip.isInRange(ip("10.0.0.0/8")) ||
+    (resource.method in ["POST", "PUT"] && resource.body_size > 1048576) ||
+    resource.header has "x-debug" ||
+    resource.query.token like "sk-*"
+};
+
+forbid (principal, action in [Action::"llm_invoke", Action::"file_write"], resource)
+unless { resource.hostname == "api.openai.com" };
+
+forbid (principal, action == Action::"tool_call", resource)
+when { resource.port > 1024 && resource.content_type == "application/json" };
+`
+
+	issues, err := ValidatePolicy(policy, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("expected no issues, got %v", issues)
+	}
+}
+
+func TestValidatePolicyRepositoryPolicies(t *testing.T) {
+	// The AI-BOM gate evaluates discovered components, not requests
+	gateSchema := &Schema{
+		PrincipalType: principalEntityType,
+		Actions: map[string]map[string]AttributeType{
+			"scan": {
+				"name":           AttrString,
+				"severity":       AttrString,
+				"provider":       AttrString,
+				"component_type": AttrString,
+				"risk_score":     AttrLong,
+			},
+		},
+	}
+	schemas := map[string]*Schema{
+		"../.cedar/ai-policy.cedar":               gateSchema,
+		"examples/standalone/sample-policy.cedar": nil,
+	}
+	for path, schema := range schemas {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Logf("skipping %s: %v", path, err)
+			continue
+		}
+		issues, err := ValidatePolicy(string(content), schema)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(issues) != 0 {
+			t.Errorf("%s: expected no issues, got %v", path, issues)
+		}
+	}
+}
+
+func TestDefaultSchemaAttributesResolve(t *testing.T) {
+	ctx := RequestContext{
+		URL:           "https://api.example.com:8443/v1/chat?model=x",
+		Method:        "POST",
+		Hostname:      "api.example.com",
+		Path:          "/v1/chat",
+		IP:            netip.MustParseAddr("203.0.113.7"),
+		Scheme:        "https",
+		Port:          8443,
+		Headers:       http.Header{"Content-Type": {"application/json"}},
+		Query:         url.Values{"model": {"x"}},
+		ContentLength: 42,
+		ContentType:   "application/json",
+	}
+	schema := DefaultSchema()
+	for _, action := range []EventType{EventHTTPRequest, EventToolCall, EventLLMInvoke, EventFileWrite} {
+		attrs, ok := schema.Actions[string(action)]
+		if !ok {
+			t.Errorf("expected action %q in the default schema", action)
+			continue
+		}
+		ctx.Action = action
+		for name, want := range attrs {
+			v, ok := resourceAttribute(ctx, name)
+			if !ok {
+				t.Errorf("%s: resource.%s does not resolve", action, name)
+			} else if got := typeName(v); got != string(want) {
+				t.Errorf("%s: resource.%s resolves to %s, schema says %s", action, name, got, want)
+			}
+		}
+	}
+}
+
+func TestValidatePolicyCustomSchema(t *testing.T) {
+	schema := &Schema{
+		PrincipalType: "Agent",
+		Actions: map[string]map[string]AttributeType{
+			"deploy_model": {"model": AttrString, "replicas": AttrLong},
+		},
+	}
+
+	issues, err := ValidatePolicy(`forbid (principal, action == Action::"deploy_model", resource) when { resource.replicas > 10 };`, schema)
+	if err != nil || len(issues) != 0 {
+		t.Errorf("expected clean validation, got %v, %v", issues, err)
+	}
+
+	issues, _ = ValidatePolicy(`forbid (principal, action, resource) when { resource.hostname == "x" };`, schema)
+	if len(issues) != 1 || !strings.Contains(issues[0].Message, "unknown attribute") {
+		t.Errorf("expected unknown attribute, got %v", issues)
+	}
+}
+
+func TestValidatePolicySyntaxError(t *testing.T) {
+	_, err := ValidatePolicy(`forbid (principal, action, resource) when { };`, nil)
+	var syntaxErr *PolicySyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected *PolicySyntaxError, got %v", err)
+	}
+}
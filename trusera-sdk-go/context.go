@@ -0,0 +1,155 @@
+package trusera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TraceContext identifies the trace and span an event belongs to
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+}
+
+type traceContextKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying tc, for TrackContext to
+// copy into events
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext returns the trace context stored by ContextWithTrace
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok && tc.TraceID != ""
+}
+
+// TraceExtractor reads the trace context of ctx, reporting false if it has
+// none
+type TraceExtractor func(ctx context.Context) (TraceContext, bool)
+
+// WithTraceExtractor sets how TrackContext finds trace IDs in a context,
+// for tracing libraries that store them under their own keys. For
+// OpenTelemetry:
+//
+//	trusera.WithTraceExtractor(func(ctx context.Context) (trusera.TraceContext, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return trusera.TraceContext{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String()}, sc.IsValid()
+//	})
+//
+// The default reads the trace context stored by ContextWithTrace.
+func WithTraceExtractor(fn TraceExtractor) Option {
+	return func(c *Client) {
+		if fn != nil {
+			c.traceExtractor = fn
+		}
+	}
+}
+
+// TrackContext queues an event like Track, adding the trace and span IDs
+// found in ctx to its metadata as trace_id and span_id unless the event
+// already has them
+func (c *Client) TrackContext(ctx context.Context, event Event) {
+	if tc, ok := c.traceExtractor(ctx); ok {
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]any)
+		} else {
+			// Do not write to a map the caller may share with other events
+			metadata := make(map[string]any, len(event.Metadata)+2)
+			for k, v := range event.Metadata {
+				metadata[k] = v
+			}
+			event.Metadata = metadata
+		}
+		if _, ok := event.Metadata["trace_id"]; !ok {
+			event.Metadata["trace_id"] = tc.TraceID
+		}
+		if _, ok := event.Metadata["span_id"]; !ok && tc.SpanID != "" {
+			event.Metadata["span_id"] = tc.SpanID
+		}
+	}
+	c.Track(event)
+}
+
+// ErrClientClosed is returned by FlushContext after Shutdown
+var ErrClientClosed = errors.New("client is shut down")
+
+// ShutdownError is returned by Shutdown when queued events could not be
+// sent in time
+type ShutdownError struct {
+	Unsent []Event // the events left in the queue
+	Err    error   // why the final flush failed
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("%d events left unsent: %v", len(e.Unsent), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error { return e.Err }
+
+// Shutdown stops the client's background goroutines, cancelling their
+// in-flight requests, then flushes the queued events within ctx's deadline.
+// If some could not be sent to the API, or ctx ends before the goroutines
+// stop, it returns a *ShutdownError listing the events left in the queue;
+// with a spool they also stay on disk to be replayed by the next client.
+// Exporters added with WithExporter are flushed and shut down after
+// the API, and their errors joined to the result. Events tracked after
+// Shutdown are dropped, and FlushContext returns ErrClientClosed. Calls
+// after the first do nothing and return the first call's result.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.shutdownOnce.Do(func() {
+		c.shutdownErr = c.shutdown(ctx)
+	})
+	return c.shutdownErr
+}
+
+func (c *Client) shutdown(ctx context.Context) error {
+	c.ticker.Stop()
+	close(c.done)
+	c.cancel()
+
+	// Background sends are cancelled, but an exporter may not return at once
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = fmt.Errorf("background flushes did not stop: %w", ctx.Err())
+	}
+	c.closed.Store(true)
+
+	if err == nil {
+		err = c.flush(ctx, 0)
+	}
+	if c.spool != nil {
+		if serr := c.spool.close(); err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		c.mu.Lock()
+		unsent := append([]Event(nil), c.events...)
+		c.mu.Unlock()
+		if len(unsent) > 0 {
+			err = &ShutdownError{Unsent: unsent, Err: err}
+		}
+	}
+
+	for _, p := range c.exporters {
+		if perr := p.shutdown(ctx); perr != nil {
+			err = errors.Join(err, perr)
+		}
+	}
+	if c.exporter != nil {
+		if serr := c.exporter.Shutdown(ctx); serr != nil {
+			err = errors.Join(err, serr)
+		}
+	}
+	return err
+}
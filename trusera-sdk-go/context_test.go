@@ -0,0 +1,262 @@
+package trusera
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackContextPropagatesTrace(t *testing.T) {
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL))
+	defer client.Close()
+
+	ctx := ContextWithTrace(context.Background(), TraceContext{TraceID: "trace-1", SpanID: "span-1"})
+	shared := map[string]any{"span_id": "explicit"}
+	client.TrackContext(ctx, NewEvent(EventToolCall, "traced"))
+	client.TrackContext(ctx, Event{Name: "explicit", Metadata: shared})
+	client.TrackContext(context.Background(), NewEvent(EventToolCall, "untraced"))
+
+	client.mu.Lock()
+	events := append([]Event(nil), client.events...)
+	client.mu.Unlock()
+	if got := events[0].Metadata; got["trace_id"] != "trace-1" || got["span_id"] != "span-1" {
+		t.Errorf("expected trace IDs in metadata, got %v", got)
+	}
+	if got := events[1].Metadata; got["trace_id"] != "trace-1" || got["span_id"] != "explicit" {
+		t.Errorf("expected existing span_id to be kept, got %v", got)
+	}
+	if _, ok := shared["trace_id"]; ok {
+		t.Error("expected the caller's metadata map not to be modified")
+	}
+	if _, ok := events[2].Metadata["trace_id"]; ok {
+		t.Error("expected no trace_id without a trace context")
+	}
+}
+
+func TestWithTraceExtractor(t *testing.T) {
+	type key struct{}
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL),
+		WithTraceExtractor(func(ctx context.Context) (TraceContext, bool) {
+			id, ok := ctx.Value(key{}).(string)
+			return TraceContext{TraceID: id}, ok
+		}))
+	defer client.Close()
+
+	client.TrackContext(context.WithValue(context.Background(), key{}, "custom"), NewEvent(EventToolCall, "tool"))
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if got := client.events[0].Metadata; got["trace_id"] != "custom" {
+		t.Errorf("expected trace_id from the extractor, got %v", got)
+	}
+	if _, ok := client.events[0].Metadata["span_id"]; ok {
+		t.Error("expected no span_id when the extractor has none")
+	}
+}
+
+func TestInterceptorPropagatesRequestTrace(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL))
+	defer client.Close()
+
+	httpClient := CreateInterceptedClient(client, InterceptorOptions{Enforcement: ModeLog})
+	ctx := ContextWithTrace(context.Background(), TraceContext{TraceID: "trace-req"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, backend.URL, nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, e := range client.events {
+		if e.Metadata["trace_id"] != "trace-req" {
+			t.Errorf("expected event %q to carry the request's trace_id, got %v", e.Name, e.Metadata)
+		}
+	}
+}
+
+func TestFlushContextRequeuesOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient("test-key", WithBaseURL(server.URL))
+	defer client.Close()
+	defer close(release)
+
+	client.Track(NewEvent(EventToolCall, "tool"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.FlushContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if stats := client.QueueStats(); stats.Queued != 1 || stats.Dropped() != 0 {
+		t.Errorf("expected the event re-queued, got %+v", stats)
+	}
+}
+
+func TestRegisterAgentContextCancelled(t *testing.T) {
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL))
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.RegisterAgentContext(ctx, "agent", "custom"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	fs := newFlakyServer(t)
+	client := NewClient("test-key", WithBaseURL(fs.URL))
+	client.Track(NewEvent(EventToolCall, "tool"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, received := fs.stats(); len(received) != 1 {
+		t.Errorf("expected 1 event sent, got %d", len(received))
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("expected a second shutdown to be a no-op, got %v", err)
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	fs := newFlakyServer(t)
+	var shutdowns int
+	exp := &countingExporter{shutdown: func() { shutdowns++ }}
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithSpool(SpoolConfig{Dir: t.TempDir()}),
+		WithExporter(exp, ExporterConfig{}))
+	client.Track(NewEvent(EventToolCall, "tool"))
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected a second shutdown to return the first result, got %v", err)
+	}
+	if _, received := fs.stats(); len(received) != 1 {
+		t.Errorf("expected 1 event sent, got %d", len(received))
+	}
+	if shutdowns != 1 {
+		t.Errorf("expected the exporter to be shut down once, got %d", shutdowns)
+	}
+
+	// A failed shutdown reports the same error again
+	failing := NewClient("test-key", WithBaseURL(newFlakyServer(t, 400).URL))
+	failing.Track(NewEvent(EventToolCall, "tool"))
+	first := failing.Shutdown(context.Background())
+	if first == nil {
+		t.Fatal("expected the rejected batch to fail shutdown")
+	}
+	if second := failing.Shutdown(context.Background()); second != first {
+		t.Errorf("expected %v again, got %v", first, second)
+	}
+}
+
+// blockingExporter holds every export until release is closed, ignoring
+// cancellation
+type blockingExporter struct {
+	release chan struct{}
+}
+
+func (e *blockingExporter) Export(ctx context.Context, events []Event) error {
+	<-e.release
+	return nil
+}
+
+func (e *blockingExporter) Shutdown(ctx context.Context) error { return nil }
+
+func TestShutdownDeadlineWhileFlushing(t *testing.T) {
+	exp := &blockingExporter{release: make(chan struct{})}
+	defer close(exp.release)
+	client := NewClient("test-key", WithPrimaryExporter(exp), WithBatchSize(1))
+
+	// The first event is taken by a flush worker, which never returns
+	client.Track(NewEvent(EventToolCall, "in-flight"))
+	waitFor(t, "the flush to start", func() bool { return client.QueueStats().Queued == 0 })
+	client.Track(NewEvent(EventToolCall, "queued"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected shutdown to return at the deadline, took %v", elapsed)
+	}
+
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("expected a *ShutdownError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline as the cause, got %v", shutdownErr.Err)
+	}
+	if len(shutdownErr.Unsent) != 1 || shutdownErr.Unsent[0].Name != "queued" {
+		t.Errorf("expected the queued event reported unsent, got %+v", shutdownErr.Unsent)
+	}
+}
+
+func TestTrackAfterShutdown(t *testing.T) {
+	fs := newFlakyServer(t)
+	dir := t.TempDir()
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithSpool(SpoolConfig{Dir: dir}))
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	client.Track(NewEvent(EventToolCall, "late"))
+	if stats := client.QueueStats(); stats.Queued != 0 || stats.DroppedClosed != 1 || stats.Dropped() != 1 {
+		t.Errorf("expected the late event to be dropped, got %+v", stats)
+	}
+	if err := client.FlushContext(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", err)
+	}
+	if _, received := fs.stats(); len(received) != 0 {
+		t.Errorf("expected no events sent, got %d", len(received))
+	}
+
+	// Nor was the late event spooled for the next client
+	next := NewClient("test-key", WithBaseURL(fs.URL), WithSpool(SpoolConfig{Dir: dir}))
+	defer next.Close()
+	if queued := next.QueueStats().Queued; queued != 0 {
+		t.Errorf("expected nothing replayed, got %d events", queued)
+	}
+}
+
+func TestShutdownReportsUnsentEvents(t *testing.T) {
+	fs := newFlakyServer(t, 500, 500, 500, 500, 500, 500, 500, 500)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(10, 20*time.Millisecond, 20*time.Millisecond))
+	client.Track(NewEvent(EventToolCall, "a"))
+	client.Track(NewEvent(EventToolCall, "b"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected shutdown to respect the deadline, took %s", elapsed)
+	}
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("expected ShutdownError, got %v", err)
+	}
+	if len(shutdownErr.Unsent) != 2 || shutdownErr.Unsent[0].Name != "a" {
+		t.Errorf("expected both events reported unsent, got %d", len(shutdownErr.Unsent))
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 500 {
+		t.Errorf("expected the last API error to be wrapped, got %v", err)
+	}
+}
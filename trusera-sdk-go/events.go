@@ -10,12 +10,13 @@ import (
 type EventType string
 
 const (
-	EventToolCall   EventType = "tool_call"
-	EventLLMInvoke  EventType = "llm_invoke"
-	EventDataAccess EventType = "data_access"
-	EventAPICall    EventType = "api_call"
-	EventFileWrite  EventType = "file_write"
-	EventDecision   EventType = "decision"
+	EventToolCall    EventType = "tool_call"
+	EventLLMInvoke   EventType = "llm_invoke"
+	EventDataAccess  EventType = "data_access"
+	EventAPICall     EventType = "api_call"
+	EventFileWrite   EventType = "file_write"
+	EventDecision    EventType = "decision"
+	EventHTTPRequest EventType = "http_request"
 )
 
 // Event represents an agent action tracked by Trusera
@@ -118,6 +118,7 @@ func TestEventTypes(t *testing.T) {
 		EventAPICall,
 		EventFileWrite,
 		EventDecision,
+		EventHTTPRequest,
 	}
 
 	for _, eventType := range types {
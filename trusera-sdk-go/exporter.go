@@ -0,0 +1,370 @@
+package trusera
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter sends batches of events to a destination. Export may be called
+// concurrently, and is not called after Shutdown.
+type Exporter interface {
+	// Export sends a batch, returning an error if it was not delivered.
+	// Errors for which IsRetryable reports true are retried.
+	Export(ctx context.Context, events []Event) error
+	// Shutdown releases the exporter's resources
+	Shutdown(ctx context.Context) error
+}
+
+// HTTPExporter posts batches as JSON to an HTTP endpoint, in the format of
+// the Trusera events API: {"agent_id": ..., "events": [...]}. The client's
+// own exporter is an HTTPExporter for {baseURL}/v1/events.
+type HTTPExporter struct {
+	URL        string
+	APIKey     string        // sent as a Bearer token if set
+	HTTPClient *http.Client  // default http.DefaultClient
+	AgentID    func() string // the agent_id of each batch, if set
+}
+
+// Export posts the batch; error responses are returned as *APIError
+func (e *HTTPExporter) Export(ctx context.Context, events []Event) error {
+	agentID := ""
+	if e.AgentID != nil {
+		agentID = e.AgentID()
+	}
+
+	payload := map[string]interface{}{
+		"agent_id": agentID,
+		"events":   events,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &encodeError{fmt.Errorf("failed to marshal events: %w", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return &encodeError{fmt.Errorf("failed to create request: %w", err)}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send events: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain body to allow connection reuse
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= 400 {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+// Shutdown does nothing; the HTTP client is not owned by the exporter
+func (e *HTTPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// WithPrimaryExporter replaces the exporter behind the client's own queue,
+// by default an HTTPExporter for the Trusera API. The queue options,
+// retries and spool apply to it. With nil the client has no queue, and
+// events only go to the exporters added with WithExporter.
+func WithPrimaryExporter(exp Exporter) Option {
+	return func(c *Client) {
+		c.exporter = exp
+		c.exporterSet = true
+	}
+}
+
+// ExporterConfig configures an exporter added with WithExporter
+type ExporterConfig struct {
+	// Name identifies the exporter in logs and ExporterStats; default
+	// "exporter-N" for the Nth exporter added. A name already in use gets a
+	// numeric suffix, e.g. "siem-2".
+	Name string
+	// BatchSize is the number of events that triggers an export; default 100
+	BatchSize int
+	// FlushInterval is how often queued events are exported regardless of
+	// BatchSize; default 30s
+	FlushInterval time.Duration
+	// QueueSize is the most events held for this exporter; further events
+	// are dropped until it catches up. Default 10000.
+	QueueSize int
+	// MaxAttempts is how often a batch is attempted, with the client's
+	// backoff between attempts, before it is dropped; default 3
+	MaxAttempts int
+}
+
+// WithExporter sends every tracked event to exp as well as to the Trusera
+// API (see WithPrimaryExporter). Each exporter has its own queue, batching
+// and retries, so a slow or failing exporter does not hold back the API or
+// other exporters. The client's queue options, retries and spool apply to
+// the API only.
+func WithExporter(exp Exporter, cfg ExporterConfig) Option {
+	return func(c *Client) {
+		base := cfg.Name
+		if base == "" {
+			base = fmt.Sprintf("exporter-%d", len(c.exporters)+1)
+		}
+		// Names key ExporterStats, so each must be unique
+		cfg.Name = base
+		for i := 2; c.hasExporter(cfg.Name); i++ {
+			cfg.Name = fmt.Sprintf("%s-%d", base, i)
+		}
+		if cfg.Name != base {
+			log.Printf("[trusera] WARNING: exporter name %q is already in use, using %q", base, cfg.Name)
+		}
+		if cfg.BatchSize <= 0 {
+			cfg.BatchSize = defaultBatchSize
+		}
+		if cfg.FlushInterval <= 0 {
+			cfg.FlushInterval = defaultFlushInterval
+		}
+		if cfg.QueueSize <= 0 {
+			cfg.QueueSize = defaultQueueSize
+		}
+		if cfg.MaxAttempts <= 0 {
+			cfg.MaxAttempts = defaultRetryAttempts
+		}
+		c.exporters = append(c.exporters, &exportPipeline{
+			client:   c,
+			exporter: exp,
+			cfg:      cfg,
+			ready:    make(chan struct{}, 1),
+		})
+	}
+}
+
+// hasExporter reports whether an exporter named name was added
+func (c *Client) hasExporter(name string) bool {
+	for _, p := range c.exporters {
+		if p.cfg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ExporterStats counts the work of an exporter added with WithExporter
+type ExporterStats struct {
+	Exported uint64 // events delivered
+	Failed   uint64 // events dropped after their batch failed
+	Dropped  uint64 // events dropped because the exporter's queue was full or it was shut down
+}
+
+// ExporterStats returns the counters of each exporter added with
+// WithExporter, by name
+func (c *Client) ExporterStats() map[string]ExporterStats {
+	stats := make(map[string]ExporterStats, len(c.exporters))
+	for _, p := range c.exporters {
+		stats[p.cfg.Name] = ExporterStats{
+			Exported: p.exported.Load(),
+			Failed:   p.failed.Load(),
+			Dropped:  p.dropped.Load(),
+		}
+	}
+	return stats
+}
+
+// exportPipeline queues events for one exporter and exports them in batches
+type exportPipeline struct {
+	client   *Client
+	exporter Exporter
+	cfg      ExporterConfig
+
+	mu     sync.Mutex
+	events []Event
+	closed bool // set by shutdown; later events are dropped
+	ready  chan struct{}
+
+	exported atomic.Uint64
+	failed   atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// add queues an event, dropping it if the queue is full or the pipeline is
+// shut down
+func (p *exportPipeline) add(event Event) {
+	p.mu.Lock()
+	if p.closed || len(p.events) >= p.cfg.QueueSize {
+		p.mu.Unlock()
+		p.dropped.Add(1)
+		return
+	}
+	p.events = append(p.events, event)
+	ready := len(p.events) >= p.cfg.BatchSize
+	p.mu.Unlock()
+
+	if ready {
+		select {
+		case p.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes up to max queued events from the front of the queue
+func (p *exportPipeline) take(max int) []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.events)
+	if n > max {
+		n = max
+	}
+	if n == 0 {
+		return nil
+	}
+	events := make([]Event, n)
+	copy(events, p.events)
+	p.events = append(p.events[:0], p.events[n:]...)
+	return events
+}
+
+// requeue puts an interrupted batch back in front of the queue
+func (p *exportPipeline) requeue(events []Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(events, p.events...)
+}
+
+func (p *exportPipeline) queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.events)
+}
+
+// run exports full batches when signalled and everything queued on each
+// tick, until the client is closed
+func (p *exportPipeline) run() {
+	c := p.client
+	defer c.wg.Done()
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ready:
+			// Failed batches are dropped, so keep going until the queue
+			// is below a batch
+			for p.queued() >= p.cfg.BatchSize && c.ctx.Err() == nil {
+				if err := p.exportBatch(c.ctx); err != nil {
+					log.Printf("[trusera] exporter %s: %v", p.cfg.Name, err)
+				}
+			}
+		case <-ticker.C:
+			if err := p.flush(c.ctx); err != nil {
+				log.Printf("[trusera] exporter %s: %v", p.cfg.Name, err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// flush exports all queued events in batches, returning the first error
+func (p *exportPipeline) flush(ctx context.Context) error {
+	var first error
+	for p.queued() > 0 && ctx.Err() == nil {
+		if err := p.exportBatch(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	if first == nil && p.queued() > 0 {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// exportBatch exports one batch, retrying retryable failures with backoff.
+// A batch that still fails is dropped, so that it cannot block the events
+// behind it; one interrupted by ctx is put back for the next flush.
+func (p *exportPipeline) exportBatch(ctx context.Context) error {
+	events := p.take(p.cfg.BatchSize)
+	if len(events) == 0 {
+		return nil
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = p.export(ctx, events); err == nil {
+			p.exported.Add(uint64(len(events)))
+			return nil
+		}
+		if !IsRetryable(err) || attempt >= p.cfg.MaxAttempts {
+			break
+		}
+		// As in Client.sendWithRetry, closing without a deadline retries
+		// without waiting
+		var closing <-chan struct{}
+		if _, ok := ctx.Deadline(); !ok {
+			closing = p.client.done
+		}
+		timer := time.NewTimer(p.client.backoff(attempt))
+		select {
+		case <-timer.C:
+			continue
+		case <-closing:
+			timer.Stop()
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		break
+	}
+
+	if ctx.Err() != nil {
+		p.requeue(events)
+		return err
+	}
+	p.failed.Add(uint64(len(events)))
+	return fmt.Errorf("dropped %d events: %w", len(events), err)
+}
+
+// export calls the exporter, turning a panic into an error so that a faulty
+// exporter cannot bring down the client
+func (p *exportPipeline) export(ctx context.Context, events []Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &encodeError{fmt.Errorf("exporter panicked: %v", r)}
+		}
+	}()
+	return p.exporter.Export(ctx, events)
+}
+
+// shutdown exports the remaining events within ctx's deadline and shuts
+// the exporter down. Events added afterwards are dropped, as nothing would
+// export them.
+func (p *exportPipeline) shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	err := p.flush(ctx)
+	if serr := p.exporter.Shutdown(ctx); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return fmt.Errorf("exporter %s: %w", p.cfg.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package trusera
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterExporter writes each event as a line of JSON to an io.Writer
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // closed on Shutdown, if set
+}
+
+// NewWriterExporter creates an exporter writing JSON lines to w. Shutdown
+// does not close w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewStdoutExporter creates an exporter writing JSON lines to standard output
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// NewFileExporter creates an exporter appending JSON lines to the file at
+// path, creating it if needed. Shutdown closes the file.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export file: %w", err)
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+// Export writes the batch in a single write, so batches from concurrent
+// calls do not interleave
+func (e *WriterExporter) Export(ctx context.Context, events []Event) error {
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return &encodeError{fmt.Errorf("failed to marshal event: %w", err)}
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.w == nil {
+		return &encodeError{fmt.Errorf("exporter is shut down")}
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	return nil
+}
+
+// Shutdown closes the file of a file exporter; later exports fail
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w = nil
+	if e.closer == nil {
+		return nil
+	}
+	err := e.closer.Close()
+	e.closer = nil
+	return err
+}
+
+// MemoryExporter keeps exported events in memory, for tests and debugging
+type MemoryExporter struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemoryExporter creates an empty in-memory exporter
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export appends the batch to the exported events
+func (e *MemoryExporter) Export(ctx context.Context, events []Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, events...)
+	return nil
+}
+
+// Shutdown does nothing; the events stay available
+func (e *MemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Events returns a copy of the exported events, in export order
+func (e *MemoryExporter) Events() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Event(nil), e.events...)
+}
+
+// Reset discards the exported events
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = nil
+}
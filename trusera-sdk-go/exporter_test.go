@@ -0,0 +1,269 @@
+package trusera
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// funcExporter adapts a function to Exporter
+type funcExporter func(ctx context.Context, events []Event) error
+
+func (f funcExporter) Export(ctx context.Context, events []Event) error { return f(ctx, events) }
+func (f funcExporter) Shutdown(ctx context.Context) error               { return nil }
+
+// countingExporter discards events and calls shutdown on Shutdown
+type countingExporter struct {
+	shutdown func()
+}
+
+func (e *countingExporter) Export(ctx context.Context, events []Event) error { return nil }
+func (e *countingExporter) Shutdown(ctx context.Context) error {
+	e.shutdown()
+	return nil
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExportersReceiveEveryEvent(t *testing.T) {
+	fs := newFlakyServer(t)
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("failed to create file exporter: %v", err)
+	}
+	memory := NewMemoryExporter()
+
+	client := NewClient("test-key", WithBaseURL(fs.URL),
+		WithExporter(file, ExporterConfig{Name: "file"}),
+		WithExporter(memory, ExporterConfig{}))
+	for _, name := range []string{"a", "b", "c"} {
+		client.Track(NewEvent(EventToolCall, name))
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	if _, received := fs.stats(); len(received) != 3 {
+		t.Errorf("expected 3 events sent to the API, got %d", len(received))
+	}
+	if got := memory.Events(); len(got) != 3 || got[0].Name != "a" || got[2].Name != "c" {
+		t.Errorf("expected 3 events in memory in order, got %d", len(got))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("expected 3 lines in the export file, got %d", lines)
+	}
+
+	stats := client.ExporterStats()
+	if stats["file"].Exported != 3 || stats["exporter-2"].Exported != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if err := file.Export(context.Background(), nil); err == nil {
+		t.Error("expected export after shutdown to fail")
+	}
+}
+
+func TestExporterFailureIsolation(t *testing.T) {
+	fs := newFlakyServer(t)
+	memory := NewMemoryExporter()
+	failing := funcExporter(func(ctx context.Context, events []Event) error {
+		return errors.New("siem unavailable")
+	})
+	panicking := funcExporter(func(ctx context.Context, events []Event) error {
+		panic("bug")
+	})
+
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(2, time.Millisecond, time.Millisecond),
+		WithExporter(failing, ExporterConfig{Name: "siem", BatchSize: 2}),
+		WithExporter(panicking, ExporterConfig{Name: "buggy", BatchSize: 2}),
+		WithExporter(memory, ExporterConfig{BatchSize: 2}))
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		client.Track(NewEvent(EventToolCall, "tool"))
+	}
+	waitFor(t, "memory exporter", func() bool { return len(memory.Events()) == 4 })
+	waitFor(t, "failing exporters", func() bool {
+		stats := client.ExporterStats()
+		return stats["siem"].Failed == 4 && stats["buggy"].Failed == 4
+	})
+
+	if err := client.Flush(); err != nil {
+		t.Errorf("expected flush to succeed with empty exporter queues, got %v", err)
+	}
+	if _, received := fs.stats(); len(received) != 4 {
+		t.Errorf("expected 4 events sent to the API, got %d", len(received))
+	}
+}
+
+func TestSlowExporterDropsInsteadOfBlocking(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	slow := funcExporter(func(ctx context.Context, events []Event) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	memory := NewMemoryExporter()
+
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL),
+		WithExporter(slow, ExporterConfig{Name: "slow", BatchSize: 1, QueueSize: 2}),
+		WithExporter(memory, ExporterConfig{BatchSize: 1}))
+	defer client.Close()
+	defer once.Do(func() { close(release) })
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		client.Track(NewEvent(EventToolCall, "tool"))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected Track not to wait for exporters, took %s", elapsed)
+	}
+	waitFor(t, "memory exporter", func() bool { return len(memory.Events()) == 10 })
+	if dropped := client.ExporterStats()["slow"].Dropped; dropped == 0 {
+		t.Error("expected the slow exporter to drop events")
+	}
+}
+
+func TestPrimaryExporter(t *testing.T) {
+	fs := newFlakyServer(t)
+	primary := NewMemoryExporter()
+
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithPrimaryExporter(primary))
+	client.Track(NewEvent(EventToolCall, "tool"))
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if len(primary.Events()) != 1 {
+		t.Errorf("expected the event in the primary exporter, got %d", len(primary.Events()))
+	}
+	if attempts, _ := fs.stats(); attempts != 0 {
+		t.Errorf("expected nothing sent to the API, got %d requests", attempts)
+	}
+
+	// Without a primary exporter events only go to the added exporters
+	memory := NewMemoryExporter()
+	client = NewClient("test-key", WithBaseURL(fs.URL), WithPrimaryExporter(nil),
+		WithExporter(memory, ExporterConfig{}))
+	client.Track(NewEvent(EventToolCall, "tool"))
+	if queued := client.QueueStats().Queued; queued != 0 {
+		t.Errorf("expected no events queued for the API, got %d", queued)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if len(memory.Events()) != 1 {
+		t.Errorf("expected the event in the exporter, got %d", len(memory.Events()))
+	}
+	if attempts, _ := fs.stats(); attempts != 0 {
+		t.Errorf("expected nothing sent to the API, got %d requests", attempts)
+	}
+}
+
+func TestExporterDropsEventsAfterShutdown(t *testing.T) {
+	memory := NewMemoryExporter()
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL),
+		WithExporter(memory, ExporterConfig{Name: "memory"}))
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	client.Track(NewEvent(EventToolCall, "late"))
+	if stats := client.ExporterStats()["memory"]; stats.Dropped != 1 {
+		t.Errorf("expected the late event to be dropped, got %+v", stats)
+	}
+	if len(memory.Events()) != 0 {
+		t.Errorf("expected no events exported, got %d", len(memory.Events()))
+	}
+}
+
+func TestExporterNamesAreUnique(t *testing.T) {
+	first, second, third := NewMemoryExporter(), NewMemoryExporter(), NewMemoryExporter()
+	client := NewClient("test-key", WithPrimaryExporter(nil),
+		WithExporter(first, ExporterConfig{Name: "memory"}),
+		WithExporter(second, ExporterConfig{Name: "memory", QueueSize: 1}),
+		WithExporter(third, ExporterConfig{Name: "exporter-2"}))
+	defer client.Close()
+
+	// The second exporter's queue overflows without touching the first's stats
+	client.Track(NewEvent(EventToolCall, "a"))
+	client.Track(NewEvent(EventToolCall, "b"))
+	stats := client.ExporterStats()
+	if len(stats) != 3 {
+		t.Fatalf("expected stats for 3 exporters, got %v", stats)
+	}
+	if stats["memory"].Dropped != 0 || stats["memory-2"].Dropped != 1 {
+		t.Errorf("expected only memory-2 to drop an event, got %v", stats)
+	}
+	if _, ok := stats["exporter-2"]; !ok {
+		t.Errorf("expected the third exporter to keep its name, got %v", stats)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var auth string
+	var payload struct {
+		AgentID string  `json:"agent_id"`
+		Events  []Event `json:"events"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	exp := &HTTPExporter{URL: server.URL + "/ingest", APIKey: "siem-key", AgentID: func() string { return "agent-7" }}
+	if err := exp.Export(context.Background(), []Event{NewEvent(EventToolCall, "tool")}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if auth != "Bearer siem-key" || payload.AgentID != "agent-7" || len(payload.Events) != 1 {
+		t.Errorf("unexpected request: auth %q, payload %+v", auth, payload)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	exp.URL = failing.URL
+	var apiErr *APIError
+	if err := exp.Export(context.Background(), nil); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected APIError 400, got %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package trusera
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -62,7 +61,23 @@ func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, er
 	}
 
 	// Check if URL matches block patterns (for enforcement)
-	blocked := t.isBlocked(req.URL.String())
+	pattern, blocked := t.blockPattern(req.URL.String())
+	mode := t.opts.Enforcement
+
+	// Evaluate remote Cedar policies when policy sync is enabled; a denied
+	// request is enforced like a block pattern, with the stricter mode winning
+	var decision *PolicyDecision
+	if cache := t.client.PolicyCache(); cache != nil {
+		d := cache.Evaluate(t.requestContext(req))
+		decision = &d
+		if d.Decision == DecisionDeny {
+			policyMode := EnforcementMode(enforcementMode(d, EnforcementAction(t.opts.Enforcement)))
+			if !blocked || enforcementRank[EnforcementAction(policyMode)] > enforcementRank[EnforcementAction(mode)] {
+				mode = policyMode
+			}
+			blocked = true
+		}
+	}
 
 	// Read and restore request body for logging (bounded read to prevent OOM)
 	var bodySnippet string
@@ -93,27 +108,37 @@ func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, er
 		event = event.WithPayload("body_snippet", bodySnippet)
 	}
 
+	if decision != nil {
+		event = event.WithPayload("policy_decision", decision.Decision).
+			WithPayload("policy_reasons", decision.Reasons).
+			WithPayload("policy_ids", decision.PolicyIDs)
+	}
+
 	// Handle enforcement modes
 	if blocked {
 		event = event.WithPayload("enforcement_action", "blocked")
 
-		switch t.opts.Enforcement {
+		switch mode {
 		case ModeBlock:
-			t.client.Track(event)
-			return nil, errors.New("request blocked by Trusera policy")
+			t.client.TrackContext(req.Context(), event)
+			return nil, t.violation(req, decision, pattern)
 
 		case ModeWarn:
-			event = event.WithMetadata("warning", "URL matches block pattern but allowed in warn mode")
-			t.client.Track(event)
+			if decision != nil && decision.Decision == DecisionDeny {
+				event = event.WithMetadata("warning", "Request denied by Cedar policy but allowed in warn mode")
+			} else {
+				event = event.WithMetadata("warning", "URL matches block pattern but allowed in warn mode")
+			}
+			t.client.TrackContext(req.Context(), event)
 			// Continue with request
 
 		case ModeLog:
 			// Just record, no action
-			t.client.Track(event)
+			t.client.TrackContext(req.Context(), event)
 		}
 	} else {
 		event = event.WithPayload("enforcement_action", "allowed")
-		t.client.Track(event)
+		t.client.TrackContext(req.Context(), event)
 	}
 
 	// Forward request to base transport
@@ -124,7 +149,7 @@ func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, er
 			WithPayload("method", req.Method).
 			WithPayload("url", req.URL.String()).
 			WithPayload("error", err.Error())
-		t.client.Track(errorEvent)
+		t.client.TrackContext(req.Context(), errorEvent)
 		return resp, err
 	}
 
@@ -134,7 +159,7 @@ func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, er
 		WithPayload("url", req.URL.String()).
 		WithPayload("status_code", resp.StatusCode).
 		WithPayload("status", resp.Status)
-	t.client.Track(responseEvent)
+	t.client.TrackContext(req.Context(), responseEvent)
 
 	return resp, nil
 }
@@ -149,14 +174,36 @@ func (t *interceptingTransport) shouldExclude(url string) bool {
 	return false
 }
 
-// isBlocked checks if URL matches any block patterns
-func (t *interceptingTransport) isBlocked(url string) bool {
+// blockPattern returns the first block pattern the URL matches
+func (t *interceptingTransport) blockPattern(url string) (string, bool) {
 	for _, pattern := range t.opts.BlockPatterns {
 		if strings.Contains(url, pattern) {
-			return true
+			return pattern, true
 		}
 	}
-	return false
+	return "", false
+}
+
+// violation builds the error for a blocked request, from the policy
+// decision when a policy denied it and from the block pattern otherwise
+func (t *interceptingTransport) violation(req *http.Request, decision *PolicyDecision, pattern string) *PolicyViolationError {
+	d := PolicyDecision{Decision: DecisionDeny}
+	if decision != nil && decision.Decision == DecisionDeny {
+		d = *decision
+	} else {
+		d.Reasons = []string{fmt.Sprintf("URL matches block pattern %q", pattern)}
+	}
+	return newPolicyViolation("Trusera policy", t.requestContext(req), d, EnforcementBlock)
+}
+
+// requestContext describes a request for policy evaluation, as the client's
+// agent
+func (t *interceptingTransport) requestContext(req *http.Request) RequestContext {
+	ctx := newRequestContext(req)
+	t.client.mu.Lock()
+	ctx.Principal = t.client.agentID
+	t.client.mu.Unlock()
+	return ctx
 }
 
 // sanitizeHeaders removes sensitive headers from logging
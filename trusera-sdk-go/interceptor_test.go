@@ -1,6 +1,7 @@
 package trusera
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -129,7 +130,10 @@ func TestBlockModeRejectsRequests(t *testing.T) {
 		BlockPatterns: []string{"/blocked"},
 	})
 
-	resp, err := httpClient.Get(backend.URL + "/blocked/resource")
+	req, _ := http.NewRequest(http.MethodGet, backend.URL+"/blocked/resource?page=2", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := httpClient.Do(req)
 	if err == nil {
 		t.Error("expected error for blocked request")
 	}
@@ -138,6 +142,19 @@ func TestBlockModeRejectsRequests(t *testing.T) {
 		t.Errorf("expected policy error message, got: %v", err)
 	}
 
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected a *PolicyViolationError, got %T", err)
+	}
+	if violation.Reasons[0] != `URL matches block pattern "/blocked"` || violation.Method != "GET" || violation.Enforcement != EnforcementBlock {
+		t.Errorf("unexpected violation: %+v", violation)
+	}
+	ctx := violation.Context
+	if ctx.Scheme != "http" || ctx.Port == 0 || ctx.Path != "/blocked/resource" || ctx.Query.Get("page") != "2" ||
+		ctx.Headers.Get("X-Request-ID") != "abc" || ctx.Headers.Get("Authorization") != "Bearer [REDACTED]" {
+		t.Errorf("expected the full request context, got %+v", ctx)
+	}
+
 	if resp != nil {
 		resp.Body.Close()
 	}
@@ -0,0 +1,184 @@
+package trusera
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// policyBundleClockSkew is how far a bundle's issue time may lie in the
+// future, allowing for clocks that lag the release pipeline's
+const policyBundleClockSkew = 5 * time.Minute
+
+// Errors returned when a policy bundle is refused
+var (
+	ErrPolicyUnsigned  = errors.New("policy bundle is not signed")
+	ErrPolicySignature = errors.New("policy bundle signature is invalid")
+	ErrPolicyExpired   = errors.New("policy bundle has expired")
+	ErrPolicyRollback  = errors.New("policy bundle is older than the one in use")
+)
+
+// PolicyBundle is Cedar policy text with a version, validity period and a
+// detached ed25519 signature. Its JSON form is the bundle file format read
+// by WithPolicyPublicKey and served by the Trusera API:
+//
+//	{
+//	  "policy": "forbid (principal, action, resource) when { ... };",
+//	  "version": "2024-06-01.1",
+//	  "issued_at": "2024-06-01T12:00:00Z",
+//	  "expires_at": "2024-07-01T12:00:00Z",
+//	  "signature": "<base64 ed25519 signature>"
+//	}
+//
+// The signature covers the version, both timestamps and the SHA-256 hash of
+// the policy text (see SigningPayload), so none of them can be changed
+// without invalidating it.
+type PolicyBundle struct {
+	Policy    string    `json:"policy"`
+	Version   string    `json:"version"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// SignPolicyBundle creates a bundle of policy text valid from now for ttl
+// and signs it with key. The policy is parsed first so that a release
+// pipeline cannot sign a bundle every interceptor would reject.
+func SignPolicyBundle(policy, version string, ttl time.Duration, key ed25519.PrivateKey) (*PolicyBundle, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("bundle ttl must be positive, got %s", ttl)
+	}
+	if _, err := ParsePolicies(policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	bundle := &PolicyBundle{
+		Policy:    policy,
+		Version:   version,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := bundle.Sign(key); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// Sign sets the bundle's signature
+func (b *PolicyBundle) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key length %d", len(key))
+	}
+	payload, err := b.SigningPayload()
+	if err != nil {
+		return err
+	}
+	b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return nil
+}
+
+// SigningPayload returns the bytes the signature covers:
+//
+//	trusera-policy-bundle/v1
+//	version: <version>
+//	issued_at: <RFC 3339 UTC>
+//	expires_at: <RFC 3339 UTC>
+//	policy_sha256: <hex>
+func (b *PolicyBundle) SigningPayload() ([]byte, error) {
+	if strings.ContainsAny(b.Version, "\r\n") {
+		return nil, errors.New("bundle version must not contain line breaks")
+	}
+	sum := sha256.Sum256([]byte(b.Policy))
+	return []byte(fmt.Sprintf("trusera-policy-bundle/v1\nversion: %s\nissued_at: %s\nexpires_at: %s\npolicy_sha256: %s\n",
+		b.Version,
+		b.IssuedAt.UTC().Format(time.RFC3339Nano),
+		b.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		hex.EncodeToString(sum[:]),
+	)), nil
+}
+
+// Verify checks the bundle's signature against key and that now lies
+// within its validity period, allowing a few minutes of clock skew before
+// the issue time. The returned error wraps ErrPolicyUnsigned,
+// ErrPolicySignature or ErrPolicyExpired when the bundle is refused for
+// that reason.
+func (b *PolicyBundle) Verify(key ed25519.PublicKey, now time.Time) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid ed25519 public key length %d", len(key))
+	}
+	if b.Signature == "" {
+		return ErrPolicyUnsigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPolicySignature, err)
+	}
+	payload, err := b.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPolicySignature, err)
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return ErrPolicySignature
+	}
+
+	if b.ExpiresAt.IsZero() || !now.Before(b.ExpiresAt) {
+		return fmt.Errorf("%w: version %q expired at %s", ErrPolicyExpired, b.Version, b.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if now.Add(policyBundleClockSkew).Before(b.IssuedAt) {
+		return fmt.Errorf("policy bundle version %q is not valid before %s", b.Version, b.IssuedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ParsePolicyBundle decodes a bundle from its JSON form without verifying it
+func ParsePolicyBundle(data []byte) (*PolicyBundle, error) {
+	var bundle PolicyBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse policy bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+// verifyPolicyBundle decodes and verifies a bundle
+func verifyPolicyBundle(data []byte, key ed25519.PublicKey) (*PolicyBundle, error) {
+	bundle, err := ParsePolicyBundle(data)
+	if err != nil {
+		// Plain Cedar text is an unsigned policy, not a malformed bundle
+		if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+			return nil, ErrPolicyUnsigned
+		}
+		return nil, err
+	}
+	if err := bundle.Verify(key, time.Now()); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// checkRollback refuses a bundle issued before newest, the issue time of the
+// bundle in use, so that an older bundle replayed within its validity
+// period cannot roll the policies back
+func (b *PolicyBundle) checkRollback(newest time.Time) error {
+	if b.IssuedAt.Before(newest) {
+		return fmt.Errorf("%w: version %q was issued at %s, before %s", ErrPolicyRollback, b.Version,
+			b.IssuedAt.UTC().Format(time.RFC3339), newest.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// expiredDecision denies a request because the bundle of the policies that
+// would decide it has expired, or reports false if it has not
+func (b *PolicyBundle) expiredDecision(now time.Time) (PolicyDecision, bool) {
+	if b == nil || now.Before(b.ExpiresAt) {
+		return PolicyDecision{}, false
+	}
+	reason := fmt.Sprintf("Policy bundle %q expired at %s", b.Version, b.ExpiresAt.UTC().Format(time.RFC3339))
+	return PolicyDecision{Decision: DecisionDeny, Reasons: []string{reason}, Matched: []string{}}, true
+}
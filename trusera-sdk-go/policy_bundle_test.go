@@ -0,0 +1,276 @@
+package trusera
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bundleTestKey returns a deterministic signing key for tests
+func bundleTestKey(seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	s := make([]byte, ed25519.SeedSize)
+	s[0] = seed
+	priv := ed25519.NewKeyFromSeed(s)
+	return priv.Public().(ed25519.PublicKey), priv
+}
+
+func TestSignAndVerifyPolicyBundle(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	bundle, err := SignPolicyBundle(noDeletePolicy, "v1", time.Hour, priv)
+	if err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	if !bundle.ExpiresAt.Equal(bundle.IssuedAt.Add(time.Hour)) {
+		t.Errorf("unexpected validity period: %s to %s", bundle.IssuedAt, bundle.ExpiresAt)
+	}
+
+	// Verification survives the JSON round trip
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatalf("failed to marshal bundle: %v", err)
+	}
+	parsed, err := ParsePolicyBundle(data)
+	if err != nil {
+		t.Fatalf("failed to parse bundle: %v", err)
+	}
+	if err := parsed.Verify(pub, time.Now()); err != nil {
+		t.Fatalf("expected valid bundle, got %v", err)
+	}
+
+	otherPub, _ := bundleTestKey(1)
+	tests := []struct {
+		name   string
+		modify func(b *PolicyBundle)
+		key    ed25519.PublicKey
+		now    time.Time
+		want   error
+	}{
+		{"unsigned", func(b *PolicyBundle) { b.Signature = "" }, pub, time.Now(), ErrPolicyUnsigned},
+		{"policy changed", func(b *PolicyBundle) { b.Policy += "\npermit (principal, action, resource);" }, pub, time.Now(), ErrPolicySignature},
+		{"expiry extended", func(b *PolicyBundle) { b.ExpiresAt = b.ExpiresAt.Add(time.Hour) }, pub, time.Now(), ErrPolicySignature},
+		{"version changed", func(b *PolicyBundle) { b.Version = "v0" }, pub, time.Now(), ErrPolicySignature},
+		{"bad encoding", func(b *PolicyBundle) { b.Signature = "not base64!" }, pub, time.Now(), ErrPolicySignature},
+		{"wrong key", func(b *PolicyBundle) {}, otherPub, time.Now(), ErrPolicySignature},
+		{"expired", func(b *PolicyBundle) {}, pub, time.Now().Add(2 * time.Hour), ErrPolicyExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := *bundle
+			tt.modify(&b)
+			if err := b.Verify(tt.key, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if err := bundle.Verify(pub, time.Now().Add(-time.Hour)); err == nil || !strings.Contains(err.Error(), "not valid before") {
+		t.Errorf("expected not-yet-valid error, got %v", err)
+	}
+}
+
+func TestSignPolicyBundleErrors(t *testing.T) {
+	_, priv := bundleTestKey(0)
+	if _, err := SignPolicyBundle("forbid (", "v1", time.Hour, priv); err == nil || !strings.Contains(err.Error(), "failed to parse policy") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+	if _, err := SignPolicyBundle(noDeletePolicy, "v1", 0, priv); err == nil {
+		t.Error("expected error for zero ttl")
+	}
+	if _, err := SignPolicyBundle(noDeletePolicy, "v1\nexpires_at: never", time.Hour, priv); err == nil {
+		t.Error("expected error for multi-line version")
+	}
+	if _, err := SignPolicyBundle(noDeletePolicy, "v1", time.Hour, priv[:10]); err == nil {
+		t.Error("expected error for short key")
+	}
+}
+
+// writeBundle writes a signed bundle of policy to path
+func writeBundle(t *testing.T, path, policy, version string, priv ed25519.PrivateKey) {
+	t.Helper()
+	bundle, err := SignPolicyBundle(policy, version, time.Hour, priv)
+	if err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	data, _ := json.Marshal(bundle)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+}
+
+func TestStandaloneInterceptorSignedPolicy(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	_, otherPriv := bundleTestKey(1)
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+
+	// Plain Cedar text is refused
+	if err := os.WriteFile(policyPath, []byte(noDeletePolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub))
+	if !errors.Is(err, ErrPolicyUnsigned) {
+		t.Fatalf("expected unsigned policy to be refused, got %v", err)
+	}
+
+	writeBundle(t, policyPath, noDeletePolicy, "v1", priv)
+	si, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("expected signed policy to load, got %v", err)
+	}
+	defer si.Close()
+
+	// A bundle signed with another key is refused on reload, keeping v1
+	writeBundle(t, policyPath, `permit (principal, action, resource);`, "v2", otherPriv)
+	if err := si.Reload(); !errors.Is(err, ErrPolicySignature) {
+		t.Errorf("expected bad signature to be refused, got %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, "https://api.example.com/users/1", nil)
+	if _, err := si.WrapClient(&http.Client{}).Do(req); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("expected the v1 policy to stay active, got %v", err)
+	}
+}
+
+// signBundleAt signs a bundle of policy issued at issued and valid until expires
+func signBundleAt(t *testing.T, policy, version string, issued, expires time.Time, priv ed25519.PrivateKey) *PolicyBundle {
+	t.Helper()
+	bundle := &PolicyBundle{Policy: policy, Version: version, IssuedAt: issued.UTC(), ExpiresAt: expires.UTC()}
+	if err := bundle.Sign(priv); err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	return bundle
+}
+
+func TestStandaloneInterceptorBundleRollback(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	now := time.Now()
+
+	writeBundle(t, policyPath, noDeletePolicy, "v2", priv)
+	si, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("expected signed policy to load, got %v", err)
+	}
+	defer si.Close()
+
+	// An older bundle, still valid, is refused
+	old := signBundleAt(t, `permit (principal, action, resource);`, "v1", now.Add(-time.Hour), now.Add(time.Hour), priv)
+	data, _ := json.Marshal(old)
+	if err := os.WriteFile(policyPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := si.Reload(); !errors.Is(err, ErrPolicyRollback) {
+		t.Errorf("expected the older bundle to be refused, got %v", err)
+	}
+
+	writeBundle(t, policyPath, noDeletePolicy, "v3", priv)
+	if err := si.Reload(); err != nil {
+		t.Errorf("expected a newer bundle to load, got %v", err)
+	}
+}
+
+func TestStandaloneInterceptorBundleExpiresWhileActive(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	now := time.Now()
+
+	bundle := signBundleAt(t, `permit (principal, action, resource);`, "v1", now, now.Add(100*time.Millisecond), priv)
+	data, _ := json.Marshal(bundle)
+	if err := os.WriteFile(policyPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	si, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("expected signed policy to load, got %v", err)
+	}
+	defer si.Close()
+
+	time.Sleep(150 * time.Millisecond)
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	_, err = si.WrapClient(&http.Client{}).Do(req)
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) || len(violation.Reasons) != 1 || !strings.Contains(violation.Reasons[0], `bundle "v1" expired`) {
+		t.Errorf("expected the expired bundle to deny requests, got %v", err)
+	}
+}
+
+func TestPolicyCacheRejectsRollback(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	ps := newPolicyServer(t)
+	now := time.Now()
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour), WithPolicySyncPublicKey(pub))
+	defer client.Close()
+	cache := client.PolicyCache()
+
+	ps.setBundle(signBundleAt(t, noDeletePolicy, "v2", now, now.Add(time.Hour), priv))
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("expected signed bundle to load, got %v", err)
+	}
+
+	ps.setBundle(signBundleAt(t, `permit (principal, action, resource);`, "v1", now.Add(-time.Minute), now.Add(time.Hour), priv))
+	if err := cache.Refresh(); !errors.Is(err, ErrPolicyRollback) {
+		t.Errorf("expected the older bundle to be refused, got %v", err)
+	}
+	if cache.Version() != "v2" {
+		t.Errorf("expected v2 to stay cached, got %q", cache.Version())
+	}
+
+	// The same bundle served again is not a rollback
+	ps.setBundle(signBundleAt(t, noDeletePolicy, "v2", now, now.Add(time.Hour), priv))
+	if err := cache.Refresh(); err != nil {
+		t.Errorf("expected the cached bundle to be accepted again, got %v", err)
+	}
+}
+
+func TestPolicyCacheSignedBundles(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(noDeletePolicy))
+
+	// Unsigned responses are refused
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour), WithPolicySyncPublicKey(pub))
+	defer client.Close()
+	cache := client.PolicyCache()
+	if err := cache.Refresh(); !errors.Is(err, ErrPolicyUnsigned) {
+		t.Fatalf("expected unsigned policies to be refused, got %v", err)
+	}
+
+	bundle, err := SignPolicyBundle(noDeletePolicy, "v1", time.Hour, priv)
+	if err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	ps.setBundle(bundle)
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("expected signed bundle to load, got %v", err)
+	}
+	if cache.Version() != "v1" || len(cache.Rules()) != 1 {
+		t.Errorf("expected bundle v1 to be cached, got version %q with %d rules", cache.Version(), len(cache.Rules()))
+	}
+
+	// Tampered bundles are refused and the cached bundle is kept
+	tampered := *bundle
+	tampered.Policy = `permit (principal, action, resource);`
+	ps.setBundle(&tampered)
+	if err := cache.Refresh(); !errors.Is(err, ErrPolicySignature) {
+		t.Errorf("expected tampered bundle to be refused, got %v", err)
+	}
+	if got := cache.Evaluate(RequestContext{Method: "DELETE"}); got.Decision != DecisionDeny {
+		t.Errorf("expected the cached bundle to stay active, got %+v", got)
+	}
+
+	// Cached policies become unavailable once their bundle expires
+	expired := *cache.state.Load()
+	expiredBundle := *expired.bundle
+	expiredBundle.ExpiresAt = time.Now().Add(-time.Second)
+	expired.bundle = &expiredBundle
+	cache.state.Store(&expired)
+	got := cache.Evaluate(RequestContext{Method: "DELETE"})
+	if got.Decision != DecisionAllow || got.Reasons[0] != `Remote policy bundle "v1" expired (fail-open)` {
+		t.Errorf("expected expired bundle to fail open, got %+v", got)
+	}
+}
@@ -0,0 +1,335 @@
+package trusera
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPolicyRefreshInterval = 60 * time.Second
+	defaultPolicyStaleTTL        = 5 * time.Minute
+	maxPolicyResponseSize        = 10 << 20
+)
+
+// PolicyFailMode decides requests when remote policies are unavailable:
+// before the first successful fetch, and once the cached policies are older
+// than the stale TTL
+type PolicyFailMode string
+
+const (
+	FailOpen   PolicyFailMode = "open"   // Allow requests, as if no remote policy applied
+	FailClosed PolicyFailMode = "closed" // Deny all requests
+)
+
+// WithPolicySync enables the remote policy cache (see PolicyCache), which
+// fetches Cedar policies from the Trusera API when the client is created and
+// then every interval
+func WithPolicySync(interval time.Duration) Option {
+	return func(c *Client) {
+		c.policySync = true
+		if interval > 0 {
+			c.policyInterval = interval
+		}
+	}
+}
+
+// WithPolicyStaleTTL sets how long cached policies are used after the last
+// successful fetch when refreshes fail; zero serves them indefinitely
+func WithPolicyStaleTTL(d time.Duration) Option {
+	return func(c *Client) {
+		c.policyStaleTTL = d
+	}
+}
+
+// WithPolicyFailMode sets how requests are decided when remote policies are
+// unavailable; the default is FailOpen
+func WithPolicyFailMode(mode PolicyFailMode) Option {
+	return func(c *Client) {
+		c.policyFailMode = mode
+	}
+}
+
+// WithPolicySyncPublicKey requires remote policies to arrive as a
+// PolicyBundle signed with the private key matching key. Unsigned, tampered
+// and expired bundles are refused and the cached policies are kept, as are
+// bundles issued before the cached one; cached policies also become
+// unavailable once their bundle expires.
+func WithPolicySyncPublicKey(key ed25519.PublicKey) Option {
+	return func(c *Client) {
+		c.policyPublicKey = key
+	}
+}
+
+// PolicyCache holds the Cedar policies of the Trusera API
+// (GET /api/v1/policies/cedar), compiled for local evaluation. It is
+// refreshed in the background, and a new policy set is only parsed when the
+// SHA-256 hash of the policy text changes. If refreshes fail, the last good
+// policies are served until the stale TTL passes, after which requests are
+// decided by the fail mode. It is safe for concurrent use.
+type PolicyCache struct {
+	client    *Client
+	staleTTL  time.Duration
+	failMode  PolicyFailMode
+	publicKey ed25519.PublicKey
+
+	refreshMu   sync.Mutex // serializes fetches
+	state       atomic.Pointer[policyCacheState]
+	lastSuccess atomic.Int64 // Unix nanoseconds of the last successful fetch
+
+	listenersMu sync.Mutex
+	listeners   map[int]func()
+	nextID      int
+}
+
+// policyCacheState is one version of the cached policies
+type policyCacheState struct {
+	hash     string
+	rules    []PolicyRule
+	compiled *CompiledPolicy
+	bundle   *PolicyBundle // the verified bundle, with a public key
+}
+
+// policiesResponse is the body of GET /api/v1/policies/cedar. Bundle holds
+// the enabled policies signed as one bundle, when the organization signs
+// its policies.
+type policiesResponse struct {
+	Policies []struct {
+		CedarDSL string `json:"cedar_dsl"`
+		Enabled  *bool  `json:"enabled"`
+	} `json:"policies"`
+	Bundle json.RawMessage `json:"bundle,omitempty"`
+}
+
+// newPolicyCache creates the policy cache of a client
+func newPolicyCache(c *Client) *PolicyCache {
+	return &PolicyCache{
+		client:    c,
+		staleTTL:  c.policyStaleTTL,
+		failMode:  c.policyFailMode,
+		publicKey: c.policyPublicKey,
+		listeners: map[int]func(){},
+	}
+}
+
+// PolicyCache returns the client's remote policy cache, or nil unless
+// WithPolicySync is set
+func (c *Client) PolicyCache() *PolicyCache {
+	return c.policyCache
+}
+
+// policyRefreshLoop refreshes the policy cache until the client is closed
+func (c *Client) policyRefreshLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.policyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.policyCache.RefreshContext(c.ctx); err != nil {
+				log.Printf("[trusera] policy cache refresh failed: %v", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Refresh fetches the policies now, replacing the cached set if they
+// changed. On error the cached policies are kept.
+func (pc *PolicyCache) Refresh() error {
+	return pc.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh with a context for the request
+func (pc *PolicyCache) RefreshContext(ctx context.Context) error {
+	pc.refreshMu.Lock()
+	defer pc.refreshMu.Unlock()
+
+	text, bundle, err := pc.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if current := pc.state.Load(); bundle != nil && current != nil && current.bundle != nil {
+		if err := bundle.checkRollback(current.bundle.IssuedAt); err != nil {
+			return fmt.Errorf("refusing remote policies: %w", err)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	hash := hex.EncodeToString(sum[:])
+	if current := pc.state.Load(); current != nil && current.hash == hash {
+		// Same policies, possibly re-signed with a later expiry
+		if bundle != nil {
+			pc.state.Store(&policyCacheState{hash: hash, rules: current.rules, compiled: current.compiled, bundle: bundle})
+		}
+		pc.lastSuccess.Store(time.Now().UnixNano())
+		return nil
+	}
+
+	rules, err := ParseCedarPolicy(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote policy: %w", err)
+	}
+	pc.state.Store(&policyCacheState{
+		hash:     hash,
+		rules:    rules,
+		compiled: CompilePolicy(rules),
+		bundle:   bundle,
+	})
+	pc.lastSuccess.Store(time.Now().UnixNano())
+
+	pc.listenersMu.Lock()
+	listeners := make([]func(), 0, len(pc.listeners))
+	for _, fn := range pc.listeners {
+		listeners = append(listeners, fn)
+	}
+	pc.listenersMu.Unlock()
+	for _, fn := range listeners {
+		fn()
+	}
+	return nil
+}
+
+// fetch downloads the enabled policies and joins their text. With a public
+// key, the policies are taken from the response's bundle once verified.
+func (pc *PolicyCache) fetch(ctx context.Context) (string, *PolicyBundle, error) {
+	c := pc.client
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/policies/cedar", nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch policies: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		return "", nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var result policiesResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPolicyResponseSize)).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if pc.publicKey != nil {
+		if len(result.Bundle) == 0 {
+			return "", nil, fmt.Errorf("refusing remote policies: %w", ErrPolicyUnsigned)
+		}
+		bundle, err := verifyPolicyBundle(result.Bundle, pc.publicKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("refusing remote policies: %w", err)
+		}
+		return bundle.Policy, bundle, nil
+	}
+
+	parts := make([]string, 0, len(result.Policies))
+	for _, p := range result.Policies {
+		if p.Enabled == nil || *p.Enabled {
+			parts = append(parts, p.CedarDSL)
+		}
+	}
+	return strings.Join(parts, "\n"), nil, nil
+}
+
+// Evaluate decides a request against the cached policies. Requests no policy
+// matches are allowed. When the policies are unavailable the fail mode
+// decides: FailOpen allows and FailClosed denies, with a reason saying why.
+func (pc *PolicyCache) Evaluate(ctx RequestContext) PolicyDecision {
+	if state, reason := pc.current(); state == nil {
+		return pc.failDecision(reason)
+	} else {
+		return state.compiled.Evaluate(ctx)
+	}
+}
+
+// current returns the cached policies, or nil and the reason they are
+// unavailable
+func (pc *PolicyCache) current() (*policyCacheState, string) {
+	state := pc.state.Load()
+	if state == nil {
+		return nil, "No remote policies loaded"
+	}
+	if state.bundle != nil && !time.Now().Before(state.bundle.ExpiresAt) {
+		return nil, fmt.Sprintf("Remote policy bundle %q expired", state.bundle.Version)
+	}
+	if pc.staleTTL > 0 {
+		if age := time.Since(time.Unix(0, pc.lastSuccess.Load())); age > pc.staleTTL {
+			return nil, fmt.Sprintf("Remote policies stale (%s > %s)", age.Round(time.Second), pc.staleTTL)
+		}
+	}
+	return state, ""
+}
+
+// failDecision is the decision for requests while policies are unavailable
+func (pc *PolicyCache) failDecision(reason string) PolicyDecision {
+	if pc.failMode == FailClosed {
+		return PolicyDecision{Decision: DecisionDeny, Reasons: []string{reason + " (fail-closed)"}, Matched: []string{}}
+	}
+	return PolicyDecision{Decision: DecisionAllow, Reasons: []string{reason + " (fail-open)"}, Matched: []string{}}
+}
+
+// Rules returns the cached policy rules, nil before the first successful fetch
+func (pc *PolicyCache) Rules() []PolicyRule {
+	if state := pc.state.Load(); state != nil {
+		return state.rules
+	}
+	return nil
+}
+
+// Hash returns the hex SHA-256 hash of the cached policy text, empty before
+// the first successful fetch
+func (pc *PolicyCache) Hash() string {
+	if state := pc.state.Load(); state != nil {
+		return state.hash
+	}
+	return ""
+}
+
+// Version returns the version of the cached policy bundle, empty without a
+// public key or before the first successful fetch
+func (pc *PolicyCache) Version() string {
+	if state := pc.state.Load(); state != nil && state.bundle != nil {
+		return state.bundle.Version
+	}
+	return ""
+}
+
+// LastSuccess returns the time of the last successful fetch, zero if none
+func (pc *PolicyCache) LastSuccess() time.Time {
+	if ns := pc.lastSuccess.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// subscribe registers fn to be called after the cached policies change and
+// returns a function that removes it
+func (pc *PolicyCache) subscribe(fn func()) func() {
+	pc.listenersMu.Lock()
+	defer pc.listenersMu.Unlock()
+	id := pc.nextID
+	pc.nextID++
+	pc.listeners[id] = fn
+	return func() {
+		pc.listenersMu.Lock()
+		defer pc.listenersMu.Unlock()
+		delete(pc.listeners, id)
+	}
+}
@@ -0,0 +1,337 @@
+package trusera
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// policyServer serves Cedar policies the way the Trusera API does
+type policyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	policies []map[string]any
+	bundle   *PolicyBundle
+	status   int
+	fetches  atomic.Int32
+}
+
+func newPolicyServer(t *testing.T) *policyServer {
+	t.Helper()
+	ps := &policyServer{status: http.StatusOK}
+	ps.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/policies/cedar" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		ps.fetches.Add(1)
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		if ps.status != http.StatusOK {
+			w.WriteHeader(ps.status)
+			return
+		}
+		body := map[string]any{"policies": ps.policies}
+		if ps.bundle != nil {
+			body["bundle"] = ps.bundle
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(ps.Close)
+	return ps
+}
+
+// set replaces the served policies
+func (ps *policyServer) set(policies ...map[string]any) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.policies = policies
+}
+
+// setBundle serves a signed bundle alongside the policies
+func (ps *policyServer) setBundle(bundle *PolicyBundle) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.bundle = bundle
+}
+
+func (ps *policyServer) fail(status int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.status = status
+}
+
+func cedarPolicy(dsl string) map[string]any {
+	return map[string]any{"cedar_dsl": dsl}
+}
+
+const noDeletePolicy = `forbid (principal, action, resource) when { resource.method == "DELETE" };`
+
+func TestPolicyCacheRefresh(t *testing.T) {
+	ps := newPolicyServer(t)
+	ps.set(
+		cedarPolicy(noDeletePolicy),
+		map[string]any{"cedar_dsl": `forbid (principal, action, resource) when { resource.method == "GET" };`, "enabled": false},
+	)
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour))
+	defer client.Close()
+
+	cache := client.PolicyCache()
+	if cache == nil {
+		t.Fatal("expected a policy cache")
+	}
+	if len(cache.Rules()) != 1 {
+		t.Fatalf("expected the disabled policy to be skipped, got %d rules", len(cache.Rules()))
+	}
+	if cache.LastSuccess().IsZero() {
+		t.Error("expected last success to be set")
+	}
+	if got := cache.Evaluate(RequestContext{Method: "DELETE"}); got.Decision != DecisionDeny {
+		t.Errorf("expected DELETE to be denied, got %+v", got)
+	}
+	if got := cache.Evaluate(RequestContext{Method: "GET"}); got.Decision != DecisionAllow {
+		t.Errorf("expected GET to be allowed, got %+v", got)
+	}
+
+	// Unchanged policies keep the same version
+	hash, rules := cache.Hash(), cache.Rules()
+	changes := 0
+	cache.subscribe(func() { changes++ })
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if cache.Hash() != hash || &cache.Rules()[0] != &rules[0] || changes != 0 {
+		t.Error("expected unchanged policies not to be reparsed")
+	}
+
+	ps.set(cedarPolicy(noDeletePolicy), cedarPolicy(`forbid (principal, action, resource) when { resource.method == "PUT" };`))
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if cache.Hash() == hash || len(cache.Rules()) != 2 || changes != 1 {
+		t.Errorf("expected new policies to be loaded, got %d rules and %d changes", len(cache.Rules()), changes)
+	}
+}
+
+func TestPolicyCacheKeepsPoliciesOnError(t *testing.T) {
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(noDeletePolicy))
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour))
+	defer client.Close()
+	cache := client.PolicyCache()
+	hash := cache.Hash()
+
+	ps.set(cedarPolicy(`forbid (principal, action, resource) when {`))
+	if err := cache.Refresh(); err == nil || !strings.Contains(err.Error(), "failed to parse remote policy") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+
+	ps.fail(http.StatusInternalServerError)
+	if err := cache.Refresh(); err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("expected status error, got %v", err)
+	}
+
+	if cache.Hash() != hash {
+		t.Error("expected the previous policies to be kept")
+	}
+	if got := cache.Evaluate(RequestContext{Method: "DELETE"}); got.Decision != DecisionDeny {
+		t.Errorf("expected DELETE to still be denied, got %+v", got)
+	}
+}
+
+func TestPolicyCacheFailModes(t *testing.T) {
+	ps := newPolicyServer(t)
+	ps.fail(http.StatusServiceUnavailable)
+
+	open := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour))
+	defer open.Close()
+	got := open.PolicyCache().Evaluate(RequestContext{Method: "GET"})
+	if got.Decision != DecisionAllow || !strings.Contains(got.Reasons[0], "(fail-open)") {
+		t.Errorf("expected fail-open allow, got %+v", got)
+	}
+
+	closed := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour), WithPolicyFailMode(FailClosed))
+	defer closed.Close()
+	got = closed.PolicyCache().Evaluate(RequestContext{Method: "GET"})
+	if got.Decision != DecisionDeny || got.Reasons[0] != "No remote policies loaded (fail-closed)" {
+		t.Errorf("expected fail-closed deny, got %+v", got)
+	}
+}
+
+func TestPolicyCacheStaleTTL(t *testing.T) {
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(noDeletePolicy))
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour),
+		WithPolicyStaleTTL(time.Minute), WithPolicyFailMode(FailClosed))
+	defer client.Close()
+	cache := client.PolicyCache()
+
+	if got := cache.Evaluate(RequestContext{Method: "GET"}); got.Decision != DecisionAllow {
+		t.Fatalf("expected fresh policies to allow GET, got %+v", got)
+	}
+
+	cache.lastSuccess.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	got := cache.Evaluate(RequestContext{Method: "GET"})
+	if got.Decision != DecisionDeny || !strings.HasPrefix(got.Reasons[0], "Remote policies stale") {
+		t.Errorf("expected stale policies to fail closed, got %+v", got)
+	}
+
+	// A successful fetch of the same policies makes them fresh again
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if got := cache.Evaluate(RequestContext{Method: "GET"}); got.Decision != DecisionAllow {
+		t.Errorf("expected refreshed policies to allow GET, got %+v", got)
+	}
+}
+
+func TestPolicyCacheBackgroundRefresh(t *testing.T) {
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(noDeletePolicy))
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(10*time.Millisecond))
+	defer client.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ps.fetches.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background refreshes, got %d fetches", ps.fetches.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	plain := NewClient("test-key", WithBaseURL(ps.URL))
+	defer plain.Close()
+	if plain.PolicyCache() != nil {
+		t.Error("expected no policy cache without WithPolicySync")
+	}
+}
+
+func TestInterceptorEnforcesRemotePolicies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(`@id("no-delete")
+forbid (principal, action, resource) when { resource.method == "DELETE" };
+
+@id("warn-billing")
+@enforcement("warn")
+forbid (principal == Agent::"billing-bot", action, resource) when { resource.method == "POST" };`))
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithAgentID("billing-bot"), WithPolicySync(time.Hour))
+	defer client.Close()
+	httpClient := WrapHTTPClient(&http.Client{}, client, InterceptorOptions{Enforcement: ModeBlock})
+
+	req, _ := http.NewRequest(http.MethodDelete, backend.URL+"/users/1", nil)
+	_, err := httpClient.Do(req)
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) || violation.Principal != "billing-bot" || violation.PolicyIDs[0] != "no-delete" {
+		t.Fatalf("expected DELETE to be blocked by no-delete, got %v", err)
+	}
+	if ctx := violation.Context; ctx.Principal != "billing-bot" || ctx.Action != EventHTTPRequest || ctx.Scheme != "http" || ctx.Port == 0 {
+		t.Errorf("expected the evaluated request context, got %+v", ctx)
+	}
+
+	resp, err := httpClient.Post(backend.URL+"/charges", "application/json", nil)
+	if err != nil {
+		t.Fatalf("expected warned POST to be forwarded, got %v", err)
+	}
+	resp.Body.Close()
+
+	client.mu.Lock()
+	events := append([]Event(nil), client.events...)
+	client.mu.Unlock()
+	if len(events) < 2 {
+		t.Fatalf("expected tracked events, got %d", len(events))
+	}
+	blocked := events[0]
+	if blocked.Payload["policy_decision"] != DecisionDeny || blocked.Payload["enforcement_action"] != "blocked" {
+		t.Errorf("unexpected blocked event payload: %v", blocked.Payload)
+	}
+	if ids, _ := blocked.Payload["policy_ids"].([]string); len(ids) != 1 || ids[0] != "no-delete" {
+		t.Errorf("expected no-delete policy id, got %v", blocked.Payload["policy_ids"])
+	}
+	if warned := events[1]; warned.Metadata["warning"] == nil {
+		t.Errorf("expected warned event, got %v", warned.Metadata)
+	}
+}
+
+func TestStandaloneInterceptorPolicyCache(t *testing.T) {
+	policyFile, backend := reloadTestSetup(t, `@id("local-no-delete")
+forbid (principal, action, resource) when { resource.method == "DELETE" };`)
+
+	ps := newPolicyServer(t)
+	ps.set(cedarPolicy(`@id("remote-no-put")
+forbid (principal, action, resource) when { resource.method == "PUT" };`))
+
+	client := NewClient("test-key", WithBaseURL(ps.URL), WithPolicySync(time.Hour), WithPolicyStaleTTL(time.Minute))
+	defer client.Close()
+	cache := client.PolicyCache()
+
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyFile),
+		WithEnforcement(EnforcementBlock),
+		WithPolicyCache(cache),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	httpClient := si.WrapClient(&http.Client{})
+
+	do := func(method string) error {
+		req, _ := http.NewRequest(method, backend.URL, nil)
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if do(http.MethodDelete) == nil || do(http.MethodPut) == nil {
+		t.Fatal("expected local and remote policies to be enforced")
+	}
+
+	// Remote updates are picked up on refresh
+	ps.set(cedarPolicy(`forbid (principal, action, resource) when { resource.method == "PATCH" };`))
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if do(http.MethodPut) != nil || do(http.MethodPatch) == nil {
+		t.Error("expected refreshed remote policies to be enforced")
+	}
+
+	// Remote IDs are namespaced apart from local ones
+	var violation *PolicyViolationError
+	if err := do(http.MethodPatch); !errors.As(err, &violation) || len(violation.PolicyIDs) != 1 || violation.PolicyIDs[0] != "remote:policy0" {
+		t.Errorf("expected the remote policy id remote:policy0, got %v", err)
+	}
+
+	// Stale remote policies fail open to the local policies
+	cache.lastSuccess.Store(time.Now().Add(-time.Hour).UnixNano())
+	if do(http.MethodPatch) != nil || do(http.MethodDelete) == nil {
+		t.Error("expected only local policies while remote policies are stale")
+	}
+
+	// Fail-closed denies everything
+	cache.failMode = FailClosed
+	if err := do(http.MethodGet); err == nil || !strings.Contains(err.Error(), "fail-closed") {
+		t.Errorf("expected fail-closed block, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package trusera
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// PolicyViolationError is returned by the interceptors' transports for a
+// request blocked by policy. http.Client wraps it in a *url.Error, so use
+// errors.As to tell a block from a network failure:
+//
+//	var violation *trusera.PolicyViolationError
+//	if errors.As(err, &violation) {
+//		log.Printf("blocked by %v: %s", violation.PolicyIDs, violation.Reasons)
+//	}
+type PolicyViolationError struct {
+	Decision    string            // DecisionDeny
+	PolicyIDs   []string          // IDs of the matched forbid rules, if any
+	Reasons     []string          // why each rule matched
+	Enforcement EnforcementAction // the mode that blocked the request, EnforcementBlock
+
+	// Summary of the blocked request
+	Method    string
+	URL       string
+	Hostname  string
+	Principal string
+	// IP is the address whose connection was refused when the request was
+	// blocked at dial time, after name resolution; otherwise invalid
+	IP netip.Addr
+	// Context is the request as the policies saw it, including its scheme,
+	// port, headers (with credentials redacted) and query
+	Context RequestContext
+
+	engine string // "Cedar policy" or "Trusera policy", for the message
+}
+
+func (e *PolicyViolationError) Error() string {
+	subject := "request"
+	if e.IP.IsValid() {
+		subject = "connection to " + e.IP.String()
+	}
+	msg := subject + " blocked by " + e.engine
+	if len(e.Reasons) > 0 {
+		msg += ": " + strings.Join(e.Reasons, "; ")
+	}
+	return msg
+}
+
+// newPolicyViolation builds the error for a request blocked by a decision
+func newPolicyViolation(engine string, ctx RequestContext, decision PolicyDecision, mode EnforcementAction) *PolicyViolationError {
+	return &PolicyViolationError{
+		Decision:    decision.Decision,
+		PolicyIDs:   decision.PolicyIDs,
+		Reasons:     decision.Reasons,
+		Enforcement: mode,
+		Method:      ctx.Method,
+		URL:         ctx.URL,
+		Hostname:    ctx.Hostname,
+		Principal:   ctx.Principal,
+		Context:     ctx,
+		engine:      engine,
+	}
+}
@@ -0,0 +1,264 @@
+package trusera
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize    = 10000
+	defaultBlockTimeout = 100 * time.Millisecond
+	defaultSampleRate   = 10
+	defaultFlushWorkers = 1
+)
+
+// OverflowPolicy decides what Track does when the event queue is full
+type OverflowPolicy string
+
+const (
+	OverflowDropNewest OverflowPolicy = "drop_newest" // drop the event being tracked
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // drop the oldest queued event to make room
+	OverflowBlock      OverflowPolicy = "block"       // wait up to BlockTimeout for room, then drop the event
+	OverflowSample     OverflowPolicy = "sample"      // keep one in SampleRate events, replacing the oldest
+)
+
+// QueueConfig configures the event queue, see WithQueue
+type QueueConfig struct {
+	// Size is the most events held in memory; default 10000
+	Size int
+	// Overflow is the policy applied when the queue is full; default
+	// OverflowDropNewest
+	Overflow OverflowPolicy
+	// BlockTimeout is how long Track waits for room with OverflowBlock;
+	// default 100ms
+	BlockTimeout time.Duration
+	// SampleRate is the N in "keep one in N" for OverflowSample; default 10
+	SampleRate int
+	// Workers is the number of goroutines sending full batches; default 1
+	Workers int
+}
+
+// WithQueue bounds the in-memory event queue and sets its overflow policy.
+// Track never sends events itself: once a batch is queued, it wakes a flush
+// worker and returns.
+func WithQueue(cfg QueueConfig) Option {
+	return func(c *Client) {
+		if cfg.Size > 0 {
+			c.queueSize = cfg.Size
+		}
+		if cfg.Overflow != "" {
+			c.overflow = cfg.Overflow
+		}
+		if cfg.BlockTimeout > 0 {
+			c.blockTimeout = cfg.BlockTimeout
+		}
+		if cfg.SampleRate > 0 {
+			c.sampleRate = cfg.SampleRate
+		}
+		if cfg.Workers > 0 {
+			c.flushWorkers = cfg.Workers
+		}
+	}
+}
+
+// QueueStats reports the state of the event queue and the events lost so
+// far, for monitoring and alerting
+type QueueStats struct {
+	Queued   int // events waiting to be sent
+	Capacity int // the queue size
+
+	// DroppedOverflow counts events dropped by the overflow policy,
+	// including events sampled out and block timeouts
+	DroppedOverflow uint64
+	// DroppedRequeue counts events of failed batches dropped because of the
+	// re-queue limit, see WithRequeueLimit
+	DroppedRequeue uint64
+	// DroppedRejected counts events the API rejected permanently
+	DroppedRejected uint64
+	// SpoolEvicted counts unsent events deleted from the spool because of
+	// its size cap, see SpoolConfig.MaxSize
+	SpoolEvicted uint64
+	// DroppedClosed counts events tracked after Shutdown
+	DroppedClosed uint64
+}
+
+// Dropped returns the total number of events lost
+func (s QueueStats) Dropped() uint64 {
+	return s.DroppedOverflow + s.DroppedRequeue + s.DroppedRejected + s.SpoolEvicted + s.DroppedClosed
+}
+
+// queueCounters are the drop counters behind QueueStats
+type queueCounters struct {
+	overflow atomic.Uint64
+	requeue  atomic.Uint64
+	rejected atomic.Uint64
+	closed   atomic.Uint64
+}
+
+// QueueStats returns the queue's current length and drop counters. With a
+// spool, events dropped because of the re-queue limit are still on disk and
+// are replayed by the next client opened on the same directory; events
+// dropped by the overflow policy are not.
+func (c *Client) QueueStats() QueueStats {
+	c.mu.Lock()
+	queued := len(c.events)
+	c.mu.Unlock()
+
+	stats := QueueStats{
+		Queued:          queued,
+		Capacity:        c.queueSize,
+		DroppedOverflow: c.dropped.overflow.Load(),
+		DroppedRequeue:  c.dropped.requeue.Load(),
+		DroppedRejected: c.dropped.rejected.Load(),
+		DroppedClosed:   c.dropped.closed.Load(),
+	}
+	if c.spool != nil {
+		stats.SpoolEvicted = c.spool.evictedCount()
+	}
+	return stats
+}
+
+// enqueue adds an event to the queue, applying the overflow policy when it
+// is full, and wakes a flush worker once a batch is ready. Events dropped
+// by the overflow policy are acknowledged in the spool, so they are not
+// replayed.
+func (c *Client) enqueue(event Event) {
+	var (
+		deadline *time.Timer
+		dropped  []Event
+	)
+	defer func() {
+		if deadline != nil {
+			deadline.Stop()
+		}
+		c.ackDropped(dropped)
+	}()
+
+	c.mu.Lock()
+	// Checked under mu, so an event is either queued before the final
+	// flush of Shutdown takes the queue or dropped
+	if c.closed.Load() {
+		c.mu.Unlock()
+		c.dropped.closed.Add(1)
+		return
+	}
+	for len(c.events) >= c.queueSize {
+		switch c.overflow {
+		case OverflowDropOldest:
+			dropped = append(dropped, c.events[0])
+			c.events = c.events[1:]
+			c.dropped.overflow.Add(1)
+
+		case OverflowSample:
+			c.overflowSeen++
+			if c.overflowSeen%uint64(c.sampleRate) != 0 {
+				c.mu.Unlock()
+				c.dropped.overflow.Add(1)
+				dropped = append(dropped, event)
+				return
+			}
+			dropped = append(dropped, c.events[0])
+			c.events = c.events[1:]
+			c.dropped.overflow.Add(1)
+
+		case OverflowBlock:
+			if deadline == nil {
+				deadline = time.NewTimer(c.blockTimeout)
+			}
+			space := c.space
+			c.mu.Unlock()
+			c.wakeFlush()
+			select {
+			case <-space:
+				c.mu.Lock()
+				continue
+			case <-deadline.C:
+			case <-c.done:
+			}
+			c.dropped.overflow.Add(1)
+			dropped = append(dropped, event)
+			return
+
+		default:
+			c.mu.Unlock()
+			c.dropped.overflow.Add(1)
+			dropped = append(dropped, event)
+			return
+		}
+	}
+	c.events = append(c.events, event)
+	ready := len(c.events) >= c.flushSize && !time.Now().Before(c.flushNotBefore)
+	c.mu.Unlock()
+
+	if ready {
+		c.wakeFlush()
+	}
+}
+
+// ackDropped acknowledges dropped events in the spool, so the segments
+// holding them can be deleted
+func (c *Client) ackDropped(events []Event) {
+	if c.spool == nil || len(events) == 0 {
+		return
+	}
+	if err := c.spool.ack(events); err != nil {
+		log.Printf("[trusera] %v", err)
+	}
+}
+
+// wakeFlush signals a flush worker without blocking
+func (c *Client) wakeFlush() {
+	select {
+	case c.flushReady <- struct{}{}:
+	default:
+	}
+}
+
+// takeEvents removes up to max events (all if max is zero) from the front
+// of the queue and wakes Track calls waiting for room
+func (c *Client) takeEvents(max int) []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.events)
+	if max > 0 && n > max {
+		n = max
+	}
+	if n == 0 {
+		return nil
+	}
+	events := make([]Event, n)
+	copy(events, c.events)
+	c.events = append(c.events[:0], c.events[n:]...)
+
+	close(c.space)
+	c.space = make(chan struct{})
+	return events
+}
+
+// flushWorker sends full batches whenever Track signals one is ready
+func (c *Client) flushWorker() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.flushReady:
+			for c.batchReady() {
+				if err := c.flush(c.ctx, c.flushSize); err != nil {
+					log.Printf("[trusera] flush failed: %v", err)
+					break
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// batchReady reports whether a full batch is queued and flushes are not
+// being held off after a failure
+func (c *Client) batchReady() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events) >= c.flushSize && !time.Now().Before(c.flushNotBefore)
+}
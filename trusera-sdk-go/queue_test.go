@@ -0,0 +1,150 @@
+package trusera
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// queuedNames returns the names of the queued events
+func queuedNames(c *Client) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, len(c.events))
+	for i, e := range c.events {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func TestTrackDoesNotWaitForAPI(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL), WithBatchSize(1))
+	defer client.Close()
+	defer close(release)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		client.Track(NewEvent(EventToolCall, "tool"))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected Track not to wait for the API, took %s", elapsed)
+	}
+}
+
+func TestQueueOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		want    []string
+		dropped uint64
+	}{
+		{OverflowDropNewest, []string{"e0", "e1", "e2"}, 2},
+		{OverflowDropOldest, []string{"e2", "e3", "e4"}, 2},
+		// Every second overflowing event replaces the oldest
+		{OverflowSample, []string{"e1", "e2", "e4"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL),
+				WithQueue(QueueConfig{Size: 3, Overflow: tt.policy, SampleRate: 2}))
+			defer client.Close()
+
+			for i := 0; i < 5; i++ {
+				client.Track(NewEvent(EventToolCall, fmt.Sprintf("e%d", i)))
+			}
+			if got := queuedNames(client); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected queue %v, got %v", tt.want, got)
+			}
+			stats := client.QueueStats()
+			if stats.DroppedOverflow != tt.dropped || stats.Queued != 3 || stats.Capacity != 3 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQueueOverflowBlock(t *testing.T) {
+	fs := newFlakyServer(t)
+	client := NewClient("test-key", WithBaseURL(fs.URL),
+		WithQueue(QueueConfig{Size: 2, Overflow: OverflowBlock, BlockTimeout: 50 * time.Millisecond}))
+	defer client.Close()
+
+	client.Track(NewEvent(EventToolCall, "e0"))
+	client.Track(NewEvent(EventToolCall, "e1"))
+
+	// Nothing makes room: the event is dropped after the timeout
+	start := time.Now()
+	client.Track(NewEvent(EventToolCall, "e2"))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected Track to wait for room, returned after %s", elapsed)
+	}
+	if got := client.QueueStats().DroppedOverflow; got != 1 {
+		t.Errorf("expected 1 dropped event, got %d", got)
+	}
+
+	// A flush making room lets the waiting event in
+	client = NewClient("test-key", WithBaseURL(fs.URL),
+		WithQueue(QueueConfig{Size: 2, Overflow: OverflowBlock, BlockTimeout: 5 * time.Second}))
+	defer client.Close()
+	client.Track(NewEvent(EventToolCall, "e0"))
+	client.Track(NewEvent(EventToolCall, "e1"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.Flush()
+	}()
+	client.Track(NewEvent(EventToolCall, "e2"))
+	if got := queuedNames(client); len(got) != 1 || got[0] != "e2" {
+		t.Errorf("expected e2 queued after the flush, got %v", got)
+	}
+	if got := client.QueueStats().DroppedOverflow; got != 0 {
+		t.Errorf("expected no dropped events, got %d", got)
+	}
+}
+
+func TestQueueWorkersSendBatches(t *testing.T) {
+	fs := newFlakyServer(t)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithBatchSize(5),
+		WithQueue(QueueConfig{Workers: 2}))
+	defer client.Close()
+
+	for i := 0; i < 20; i++ {
+		client.Track(NewEvent(EventToolCall, "tool"))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, received := fs.stats(); len(received) == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			_, received := fs.stats()
+			t.Fatalf("expected 20 events sent by the workers, got %d", len(received))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueStatsCountsLostEvents(t *testing.T) {
+	fs := newFlakyServer(t, http.StatusBadRequest, 500)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(1, time.Millisecond, time.Millisecond),
+		WithRequeueLimit(1))
+	defer client.Close()
+
+	client.Track(NewEvent(EventToolCall, "rejected"))
+	client.Flush()
+	client.Track(NewEvent(EventToolCall, "a"))
+	client.Track(NewEvent(EventToolCall, "b"))
+	client.Flush()
+
+	stats := client.QueueStats()
+	if stats.DroppedRejected != 1 || stats.DroppedRequeue != 1 || stats.Queued != 1 || stats.Dropped() != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
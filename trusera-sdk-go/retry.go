@@ -0,0 +1,213 @@
+package trusera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultRequeueLimit   = 10000
+)
+
+// WithRetry sets how often a failed flush is attempted before its batch is
+// re-queued for the next flush, and the bounds of the jittered exponential
+// backoff between attempts. maxAttempts of 1 disables retries.
+func WithRetry(maxAttempts int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		if maxAttempts > 0 {
+			c.retryAttempts = maxAttempts
+		}
+		if initialBackoff > 0 {
+			c.initialBackoff = initialBackoff
+		}
+		if maxBackoff > 0 {
+			c.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithRequeueLimit caps the events kept queued after failed flushes; when
+// re-queueing a batch would exceed it, the oldest events are dropped
+func WithRequeueLimit(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.requeueLimit = n
+		}
+	}
+}
+
+// APIError is returned when the Trusera API rejects a request
+type APIError struct {
+	StatusCode int
+	// RetryAfter is the delay the API asked for with a Retry-After header on
+	// a 429 or 503 response, zero if none
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API returned status %d", e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed if retried: on request
+// timeouts, rate limiting and server errors. Other 4xx responses are
+// permanent.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
+// newAPIError builds the error for a failed response
+func newAPIError(resp *http.Response) *APIError {
+	err := &APIError{StatusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date, returning zero if it is missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// IsRetryable reports whether a failed flush or API call may succeed if
+// retried. Network errors, timeouts and temporary API errors are retryable;
+// permanent API errors (most 4xx responses), encoding errors and
+// cancellations are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var encodeErr *encodeError
+	if errors.As(err, &encodeErr) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// encodeError marks a request that could not be built, which retrying
+// cannot fix
+type encodeError struct {
+	err error
+}
+
+func (e *encodeError) Error() string { return e.err.Error() }
+func (e *encodeError) Unwrap() error { return e.err }
+
+// backoff returns the delay before retry attempt n (1 for the first retry):
+// exponential from the initial backoff, capped at the maximum, with equal
+// jitter so that clients do not retry in lockstep
+func (c *Client) backoff(n int) time.Duration {
+	d := c.initialBackoff
+	for i := 1; i < n && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// sendWithRetry sends a batch, retrying retryable failures with backoff
+// until ctx is done. A Retry-After longer than the maximum backoff ends the
+// attempts early, so the batch waits for a later flush instead of blocking
+// this one. An attempt cut short by ctx returns the previous attempt's
+// error, which says more about why the batch was not sent.
+func (c *Client) sendWithRetry(ctx context.Context, events []Event) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		prev := err
+		err = c.send(ctx, events)
+		if err != nil && prev != nil && ctx.Err() != nil {
+			return prev
+		}
+		if err == nil || !IsRetryable(err) || attempt >= c.retryAttempts {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			if apiErr.RetryAfter > c.maxBackoff {
+				c.deferFlush(apiErr.RetryAfter)
+				return err
+			}
+			delay = apiErr.RetryAfter
+		}
+
+		// Once the client is closed, a flush without a deadline makes one
+		// last attempt without waiting, so Close does not block on backoff
+		var closing <-chan struct{}
+		if _, ok := ctx.Deadline(); !ok {
+			closing = c.done
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-closing:
+			timer.Stop()
+			return c.send(ctx, events)
+		}
+	}
+}
+
+// requeue puts a failed batch back in front of the queue, dropping the
+// oldest events beyond the re-queue limit or the queue size
+func (c *Client) requeue(events []Event) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit := c.requeueLimit
+	if c.queueSize < limit {
+		limit = c.queueSize
+	}
+	queue := make([]Event, 0, len(events)+len(c.events))
+	queue = append(append(queue, events...), c.events...)
+	dropped := 0
+	if len(queue) > limit {
+		dropped = len(queue) - limit
+		queue = queue[dropped:]
+	}
+	c.events = queue
+	return dropped
+}
+
+// deferFlush holds off flushes triggered by Track for d, so a failing API is
+// not retried on every tracked event
+func (c *Client) deferFlush(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until := time.Now().Add(d); until.After(c.flushNotBefore) {
+		c.flushNotBefore = until
+	}
+}
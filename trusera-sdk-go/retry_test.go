@@ -0,0 +1,207 @@
+package trusera
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers event posts with the given statuses in turn, then 200,
+// recording the events of accepted batches
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	attempts int
+	received []Event
+}
+
+func newFlakyServer(t *testing.T, statuses ...int) *flakyServer {
+	t.Helper()
+	fs := &flakyServer{statuses: statuses, header: http.Header{}}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.attempts++
+		if len(fs.statuses) > 0 {
+			status := fs.statuses[0]
+			fs.statuses = fs.statuses[1:]
+			for k, v := range fs.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		var payload struct {
+			Events []Event `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		fs.received = append(fs.received, payload.Events...)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *flakyServer) stats() (attempts int, received []Event) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.attempts, append([]Event(nil), fs.received...)
+}
+
+func TestFlushRetriesTransientErrors(t *testing.T) {
+	fs := newFlakyServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(3, time.Millisecond, 10*time.Millisecond))
+	defer client.Close()
+
+	client.Track(NewEvent(EventToolCall, "tool"))
+	if err := client.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed after retries, got %v", err)
+	}
+	if attempts, received := fs.stats(); attempts != 3 || len(received) != 1 {
+		t.Errorf("expected 3 attempts delivering 1 event, got %d attempts and %d events", attempts, len(received))
+	}
+}
+
+func TestFlushRequeuesAfterRetriesFail(t *testing.T) {
+	fs := newFlakyServer(t, 500, 500, 500)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(2, time.Millisecond, 10*time.Millisecond))
+	defer client.Close()
+
+	first := NewEvent(EventToolCall, "first")
+	client.Track(first)
+	err := client.Flush()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 500 || !IsRetryable(err) {
+		t.Fatalf("expected a retryable API error, got %v", err)
+	}
+
+	// The failed batch goes back in front of newer events
+	second := NewEvent(EventToolCall, "second")
+	client.Track(second)
+	client.mu.Lock()
+	queued := append([]Event(nil), client.events...)
+	client.mu.Unlock()
+	if len(queued) != 2 || queued[0].ID != first.ID || queued[1].ID != second.ID {
+		t.Fatalf("expected the failed batch to be re-queued in order, got %d events", len(queued))
+	}
+
+	// One more failure, then the API recovers within the retries
+	if err := client.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed, got %v", err)
+	}
+	if attempts, received := fs.stats(); attempts != 4 || len(received) != 2 || received[0].ID != first.ID {
+		t.Errorf("expected both events delivered in order after 4 attempts, got %d events after %d", len(received), attempts)
+	}
+}
+
+func TestFlushDropsPermanentFailures(t *testing.T) {
+	fs := newFlakyServer(t, http.StatusBadRequest)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(3, time.Millisecond, 10*time.Millisecond))
+	defer client.Close()
+
+	client.Track(NewEvent(EventToolCall, "tool"))
+	err := client.Flush()
+	if err == nil || IsRetryable(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+	attempts, _ := fs.stats()
+	client.mu.Lock()
+	queued := len(client.events)
+	client.mu.Unlock()
+	if attempts != 1 || queued != 0 {
+		t.Errorf("expected 1 attempt and nothing re-queued, got %d attempts and %d queued", attempts, queued)
+	}
+}
+
+func TestFlushHonorsRetryAfter(t *testing.T) {
+	fs := newFlakyServer(t, http.StatusTooManyRequests)
+	fs.header.Set("Retry-After", "1")
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(2, time.Millisecond, 2*time.Second))
+	defer client.Close()
+
+	client.Track(NewEvent(EventToolCall, "tool"))
+	start := time.Now()
+	if err := client.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed after waiting, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, waited %s", elapsed)
+	}
+
+	// A Retry-After beyond the maximum backoff re-queues without waiting
+	fs.mu.Lock()
+	fs.statuses = []int{http.StatusServiceUnavailable}
+	fs.header.Set("Retry-After", "120")
+	fs.mu.Unlock()
+	client.Track(NewEvent(EventToolCall, "tool"))
+	start = time.Now()
+	if err := client.Flush(); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no wait, waited %s", elapsed)
+	}
+	client.mu.Lock()
+	deferred := time.Until(client.flushNotBefore)
+	client.mu.Unlock()
+	if deferred < time.Minute {
+		t.Errorf("expected Track-triggered flushes to be deferred, got %s", deferred)
+	}
+}
+
+func TestRequeueLimit(t *testing.T) {
+	fs := newFlakyServer(t, 500)
+	client := NewClient("test-key", WithBaseURL(fs.URL), WithRetry(1, time.Millisecond, time.Millisecond), WithRequeueLimit(3))
+	defer client.Close()
+
+	events := make([]Event, 5)
+	for i := range events {
+		events[i] = NewEvent(EventToolCall, fmt.Sprintf("tool-%d", i))
+		client.Track(events[i])
+	}
+	client.Flush()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.events) != 3 || client.events[0].ID != events[2].ID {
+		t.Errorf("expected the 3 newest events to be kept, got %d", len(client.events))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-1", 0},
+		{"Mon, 01 Jan 2024 12:01:00 GMT", time.Minute},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for n, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := client.backoff(n); d < max/2 || d > max {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", n, d, max/2, max)
+			}
+		}
+	}
+}
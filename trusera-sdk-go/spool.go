@@ -0,0 +1,406 @@
+package trusera
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolSegmentSize  = 8 << 20
+	defaultSpoolMaxSize      = 256 << 20
+	defaultSpoolSyncInterval = time.Second
+	spoolSegmentPrefix       = "segment-"
+	spoolSegmentSuffix       = ".jsonl"
+)
+
+// SpoolSyncPolicy decides when spooled events are fsynced to disk
+type SpoolSyncPolicy string
+
+const (
+	SpoolSyncAlways   SpoolSyncPolicy = "always"   // fsync every write before Track returns
+	SpoolSyncInterval SpoolSyncPolicy = "interval" // fsync every SyncInterval
+	SpoolSyncNever    SpoolSyncPolicy = "never"    // leave flushing to the operating system
+)
+
+// SpoolConfig configures the on-disk event spool, see WithSpool
+type SpoolConfig struct {
+	// Dir holds the spool's segment files; it is created if missing
+	Dir string
+	// SegmentSize is the size in bytes at which a new segment file is
+	// started; default 8 MiB
+	SegmentSize int64
+	// MaxSize caps the total size of the segment files; beyond it the oldest
+	// segments are deleted, even if they hold unsent events. Default 256 MiB.
+	MaxSize int64
+	// Sync is the fsync policy; default SpoolSyncInterval
+	Sync SpoolSyncPolicy
+	// SyncInterval is the fsync period for SpoolSyncInterval; default 1s
+	SyncInterval time.Duration
+}
+
+// WithSpool writes every tracked event to an append-only spool on disk
+// before it is queued, so events survive crashes and long API outages. Sent
+// events, and events dropped by the overflow policy, are acknowledged in the
+// spool. Events never acknowledged are replayed through the queue by the
+// next NewClient with the same directory, de-duplicated by Event.ID. Segment files whose events have all been sent are deleted. If
+// the spool cannot be opened, NewClient logs the error and continues
+// without it.
+func WithSpool(cfg SpoolConfig) Option {
+	return func(c *Client) {
+		c.spoolConfig = &cfg
+	}
+}
+
+// spool is a write-ahead log of tracked events, split into segment files of
+// JSON lines. A line holds either an event or the IDs of sent events.
+type spool struct {
+	cfg SpoolConfig
+
+	mu        sync.Mutex
+	segments  []*spoolSegment // oldest first; the last one is written to
+	file      *os.File        // the last segment
+	nextSeq   uint64
+	totalSize int64
+	pending   map[string]*spoolSegment // unacknowledged event ID → its segment
+	dirty     bool
+	evicted   uint64 // unsent events deleted by the size cap
+}
+
+type spoolSegment struct {
+	seq  uint64
+	path string
+	size int64
+	ids  map[string]struct{} // unacknowledged events
+}
+
+// spoolRecord is one line of a segment file
+type spoolRecord struct {
+	Event *Event   `json:"event,omitempty"`
+	Ack   []string `json:"ack,omitempty"`
+}
+
+// openSpool opens the spool in cfg.Dir and returns the unacknowledged events
+// of previous runs, oldest first. Writes go to a new segment, so a segment
+// left half-written by a crash is never appended to.
+func openSpool(cfg SpoolConfig) (*spool, []Event, error) {
+	if cfg.Dir == "" {
+		return nil, nil, fmt.Errorf("spool directory is required")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = defaultSpoolSegmentSize
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultSpoolMaxSize
+	}
+	if cfg.Sync == "" {
+		cfg.Sync = SpoolSyncInterval
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = defaultSpoolSyncInterval
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &spool{cfg: cfg, pending: map[string]*spoolSegment{}}
+	replayed, err := s.replay()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.compact()
+	if err := s.roll(); err != nil {
+		return nil, nil, err
+	}
+	return s, replayed, nil
+}
+
+// replay loads the existing segments, returning their unacknowledged events
+func (s *spool) replay() ([]Event, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool segment: %w", err)
+		}
+		s.segments = append(s.segments, &spoolSegment{
+			seq:  seq,
+			path: filepath.Join(s.cfg.Dir, name),
+			size: info.Size(),
+			ids:  map[string]struct{}{},
+		})
+		s.totalSize += info.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	var order []string
+	events := map[string]Event{}
+	for _, seg := range s.segments {
+		if err := s.readSegment(seg, events, &order); err != nil {
+			return nil, err
+		}
+	}
+
+	replayed := make([]Event, 0, len(s.pending))
+	for _, id := range order {
+		if _, ok := s.pending[id]; ok {
+			replayed = append(replayed, events[id])
+		}
+	}
+	return replayed, nil
+}
+
+// readSegment applies the records of a segment. A line that cannot be
+// parsed, such as one cut short by a crash, is skipped.
+func (s *spool) readSegment(seg *spoolSegment, events map[string]Event, order *[]string) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var record spoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if e := record.Event; e != nil && e.ID != "" {
+			if _, seen := events[e.ID]; seen {
+				continue
+			}
+			events[e.ID] = *e
+			*order = append(*order, e.ID)
+			seg.ids[e.ID] = struct{}{}
+			s.pending[e.ID] = seg
+		}
+		for _, id := range record.Ack {
+			if owner, ok := s.pending[id]; ok {
+				delete(owner.ids, id)
+				delete(s.pending, id)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read spool segment %s: %w", seg.path, err)
+	}
+	return nil
+}
+
+// append writes events to the spool
+func (s *spool) append(events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg := s.segments[len(s.segments)-1]
+	for _, e := range events {
+		if err := s.write(spoolRecord{Event: &e}); err != nil {
+			return err
+		}
+		seg.ids[e.ID] = struct{}{}
+		s.pending[e.ID] = seg
+	}
+	return s.afterWrite()
+}
+
+// ack records events as sent and deletes the oldest segments once all of
+// their events are sent
+func (s *spool) ack(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		if seg, ok := s.pending[e.ID]; ok {
+			delete(seg.ids, e.ID)
+			delete(s.pending, e.ID)
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.write(spoolRecord{Ack: ids}); err != nil {
+		return err
+	}
+	s.compact()
+	return s.afterWrite()
+}
+
+// write appends a record to the last segment. Callers must hold mu.
+func (s *spool) write(record spoolRecord) error {
+	if s.file == nil {
+		return fmt.Errorf("spool is closed")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode spool record: %w", err)
+	}
+	data = append(data, '\n')
+	n, err := s.file.Write(data)
+	s.segments[len(s.segments)-1].size += int64(n)
+	s.totalSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	s.dirty = true
+	return nil
+}
+
+// afterWrite applies the sync policy, starts a new segment when the last
+// one is full and evicts the oldest segments beyond the size cap. Callers
+// must hold mu.
+func (s *spool) afterWrite() error {
+	if s.cfg.Sync == SpoolSyncAlways {
+		if err := s.syncLocked(); err != nil {
+			return err
+		}
+	}
+	if s.segments[len(s.segments)-1].size >= s.cfg.SegmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	for s.totalSize > s.cfg.MaxSize && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		s.evicted += uint64(len(oldest.ids))
+		if len(oldest.ids) > 0 {
+			log.Printf("[trusera] spool size limit reached, evicted %d unsent events", len(oldest.ids))
+		}
+		for id := range oldest.ids {
+			delete(s.pending, id)
+		}
+		s.remove()
+	}
+	return nil
+}
+
+// roll closes the current segment and starts a new one. Callers must hold
+// mu or own the spool.
+func (s *spool) roll() error {
+	if s.file != nil {
+		if err := s.syncLocked(); err != nil {
+			return err
+		}
+		s.file.Close()
+	}
+	seg := &spoolSegment{
+		seq:  s.nextSeq,
+		path: filepath.Join(s.cfg.Dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, s.nextSeq, spoolSegmentSuffix)),
+		ids:  map[string]struct{}{},
+	}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	s.nextSeq++
+	s.file = f
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+// compact deletes the oldest segments that have no unsent events, keeping
+// the one being written. Only a prefix is deleted, so an acknowledgement is
+// never lost while the event it refers to is still on disk.
+func (s *spool) compact() {
+	for len(s.segments) > 0 && len(s.segments[0].ids) == 0 && s.segments[0].path != s.activePath() {
+		s.remove()
+	}
+}
+
+func (s *spool) activePath() string {
+	if s.file == nil {
+		return ""
+	}
+	return s.file.Name()
+}
+
+// remove deletes the oldest segment
+func (s *spool) remove() {
+	oldest := s.segments[0]
+	if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("[trusera] failed to remove spool segment: %v", err)
+	}
+	s.totalSize -= oldest.size
+	s.segments = s.segments[1:]
+}
+
+// evictedCount returns the number of unsent events deleted by the size cap
+func (s *spool) evictedCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evicted
+}
+
+// sync fsyncs pending writes
+func (s *spool) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked()
+}
+
+func (s *spool) syncLocked() error {
+	if !s.dirty || s.file == nil {
+		return nil
+	}
+	s.dirty = false
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool: %w", err)
+	}
+	return nil
+}
+
+// close syncs and closes the current segment
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.syncLocked()
+	if s.file != nil {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+		s.file = nil
+	}
+	return err
+}
+
+// spoolSyncLoop fsyncs the spool periodically until the client is closed
+func (c *Client) spoolSyncLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.spool.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.spool.sync(); err != nil {
+				log.Printf("[trusera] %v", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
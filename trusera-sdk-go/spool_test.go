@@ -0,0 +1,175 @@
+package trusera
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolReplaysUnsentEvents(t *testing.T) {
+	dir := t.TempDir()
+	down := newFlakyServer(t, 500, 500, 500, 500)
+
+	// The API is down for the whole run: events stay in the spool
+	client := NewClient("test-key", WithBaseURL(down.URL), WithRetry(1, time.Millisecond, time.Millisecond),
+		WithSpool(SpoolConfig{Dir: dir, Sync: SpoolSyncAlways}))
+	tracked := []Event{NewEvent(EventToolCall, "a"), NewEvent(EventToolCall, "b"), NewEvent(EventToolCall, "c")}
+	for _, e := range tracked {
+		client.Track(e)
+	}
+	if err := client.Close(); err == nil {
+		t.Fatal("expected the final flush to fail")
+	}
+
+	// The next client replays them in order and acknowledges them once sent
+	up := newFlakyServer(t)
+	client = NewClient("test-key", WithBaseURL(up.URL), WithSpool(SpoolConfig{Dir: dir}))
+	client.mu.Lock()
+	queued := append([]Event(nil), client.events...)
+	client.mu.Unlock()
+	if len(queued) != 3 || queued[0].ID != tracked[0].ID || queued[2].ID != tracked[2].ID {
+		t.Fatalf("expected 3 replayed events in order, got %d", len(queued))
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, received := up.stats(); len(received) != 3 {
+		t.Errorf("expected 3 events delivered, got %d", len(received))
+	}
+
+	// Nothing is replayed twice, and sent segments are deleted
+	client = NewClient("test-key", WithBaseURL(up.URL), WithSpool(SpoolConfig{Dir: dir}))
+	defer client.Close()
+	client.mu.Lock()
+	replayed := len(client.events)
+	client.mu.Unlock()
+	if replayed != 0 {
+		t.Errorf("expected no events replayed, got %d", replayed)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.jsonl")); len(segments) != 1 {
+		t.Errorf("expected only the active segment to remain, got %v", segments)
+	}
+}
+
+func TestSpoolReplayRespectsQueueBound(t *testing.T) {
+	dir := t.TempDir()
+	down := newFlakyServer(t, 500, 500, 500, 500)
+
+	client := NewClient("test-key", WithBaseURL(down.URL), WithRetry(1, time.Millisecond, time.Millisecond),
+		WithSpool(SpoolConfig{Dir: dir, Sync: SpoolSyncAlways}))
+	for i := 0; i < 5; i++ {
+		client.Track(NewEvent(EventToolCall, fmt.Sprintf("tool-%d", i)))
+	}
+	if err := client.Close(); err == nil {
+		t.Fatal("expected the final flush to fail")
+	}
+
+	// The replay goes through the queue, whose overflow policy drops the rest
+	up := newFlakyServer(t)
+	client = NewClient("test-key", WithBaseURL(up.URL), WithSpool(SpoolConfig{Dir: dir}),
+		WithQueue(QueueConfig{Size: 2}))
+	if stats := client.QueueStats(); stats.Queued > 2 || stats.DroppedOverflow != 3 {
+		t.Errorf("expected 3 replayed events dropped by the overflow policy, got %+v", stats)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, received := up.stats(); len(received) != 2 {
+		t.Errorf("expected 2 events delivered, got %d", len(received))
+	}
+
+	// Dropped events were acknowledged, so nothing is left to replay
+	client = NewClient("test-key", WithBaseURL(up.URL), WithSpool(SpoolConfig{Dir: dir}))
+	defer client.Close()
+	if queued := client.QueueStats().Queued; queued != 0 {
+		t.Errorf("expected no events replayed, got %d", queued)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.jsonl")); len(segments) != 1 {
+		t.Errorf("expected only the active segment to remain, got %v", segments)
+	}
+}
+
+func TestSpoolReplayDeduplicatesAndSkipsTornLines(t *testing.T) {
+	dir := t.TempDir()
+	a, b := NewEvent(EventToolCall, "a"), NewEvent(EventToolCall, "b")
+	line := func(e Event) string {
+		return fmt.Sprintf(`{"event":{"id":%q,"type":"tool_call","name":%q,"payload":{},"timestamp":%q}}`, e.ID, e.Name, e.Timestamp)
+	}
+	segments := map[string]string{
+		"segment-00000000000000000001.jsonl": line(a) + "\n" + line(b) + "\n",
+		"segment-00000000000000000002.jsonl": line(a) + "\n" + `{"ack":["` + b.ID + `"]}` + "\n" + `{"event":{"id":"torn`,
+		"notes.txt":                          "ignored",
+	}
+	for name, content := range segments {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, replayed, err := openSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	defer s.close()
+	if len(replayed) != 1 || replayed[0].ID != a.ID || replayed[0].Name != "a" {
+		t.Fatalf("expected only event a to be replayed, got %+v", replayed)
+	}
+	if !strings.HasSuffix(s.file.Name(), "segment-00000000000000000003.jsonl") {
+		t.Errorf("expected writes to go to a new segment, got %s", s.file.Name())
+	}
+
+	// Acknowledging a deletes both old segments
+	if err := s.ack(replayed); err != nil {
+		t.Fatalf("ack failed: %v", err)
+	}
+	if len(s.segments) != 1 {
+		t.Errorf("expected old segments to be deleted, %d remain", len(s.segments))
+	}
+}
+
+func TestSpoolRollsAndEvictsSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openSpool(SpoolConfig{Dir: dir, SegmentSize: 1024, MaxSize: 4096, Sync: SpoolSyncNever})
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	defer s.close()
+
+	for i := 0; i < 100; i++ {
+		if err := s.append(NewEvent(EventToolCall, fmt.Sprintf("event-%d", i)).WithPayload("pad", strings.Repeat("x", 100))); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	var total int64
+	files, _ := filepath.Glob(filepath.Join(dir, "segment-*.jsonl"))
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		total += info.Size()
+	}
+	if len(files) < 2 || total > 4096 {
+		t.Errorf("expected several segments within the size cap, got %d files of %d bytes", len(files), total)
+	}
+	if s.evicted == 0 || uint64(len(s.pending))+s.evicted != 100 {
+		t.Errorf("expected the oldest events to be evicted, got %d evicted and %d pending", s.evicted, len(s.pending))
+	}
+}
+
+func TestSpoolOpenError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("test-key", WithBaseURL(newFlakyServer(t).URL), WithSpool(SpoolConfig{Dir: filepath.Join(file, "spool")}))
+	defer client.Close()
+	if client.spool != nil {
+		t.Error("expected the client to run without a spool")
+	}
+	client.Track(NewEvent(EventToolCall, "tool"))
+	if err := client.Flush(); err != nil {
+		t.Errorf("expected flush without spool to work, got %v", err)
+	}
+}
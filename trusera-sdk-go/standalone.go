@@ -1,14 +1,23 @@
 package trusera
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,9 +36,62 @@ type StandaloneInterceptor struct {
 	enforcement     EnforcementAction
 	logFile         string
 	excludePatterns []string
-	rules           []PolicyRule
+	defaultDecision string
+	principal       string
+	validate        bool
+	schema          *Schema
+	legacyAction    string
+	explain         bool
+	publicKey       ed25519.PublicKey
+	lookupIP        func(ctx context.Context, network, host string) ([]netip.Addr, error)
 	logMu           sync.Mutex
 	logWriter       *os.File
+
+	// policyMu serializes changes to the policy set; requests read the
+	// current version from active without locking
+	policyMu   sync.Mutex
+	fileRules  []PolicyRule
+	fileBundle *PolicyBundle
+	templates  []*Policy
+	linked     []*Policy
+	shadows    map[string]*shadowPolicy
+	active     atomic.Pointer[activePolicy]
+
+	// Shadow policy files, see WithShadowPolicyFile
+	shadowFiles map[string]string
+
+	// Remote policies merged into the policy set, see WithPolicyCache
+	policyCache *PolicyCache
+	unsubscribe func()
+
+	// Policy file reloading, see Reload
+	reloadInterval time.Duration
+	reloadOnSIGHUP bool
+	onReload       func(error)
+	reloadMu       sync.Mutex // serializes reloads and guards fileStamp and bundleIssuedAt
+	fileStamp      fileStamp
+	bundleIssuedAt time.Time // of the newest bundle loaded, see WithPolicyPublicKey
+	sighup         chan os.Signal
+	stop           chan struct{}
+	stopOnce       sync.Once
+	reloaders      sync.WaitGroup
+}
+
+// activePolicy is a version of the policy set requests are evaluated
+// against. It is replaced as a whole, so each request sees one consistent
+// version.
+type activePolicy struct {
+	rules      []PolicyRule
+	compiled   *CompiledPolicy
+	inspectsIP bool // some rule references resource.ip, so dials are checked
+	// bundle is the verified bundle of the policy file, with a public key;
+	// once it expires every request is denied
+	bundle *PolicyBundle
+	// local is the policy set without remote policies, evaluated while the
+	// policy cache fails open; nil without a policy cache
+	local *activePolicy
+	// shadows are evaluated alongside, without affecting requests
+	shadows []*shadowPolicy
 }
 
 // StandaloneOption configures a StandaloneInterceptor
@@ -63,44 +125,366 @@ func WithExcludePatterns(patterns ...string) StandaloneOption {
 	}
 }
 
+// WithPolicyDefault sets the decision for requests no policy matches.
+// DecisionDeny enforces a deny-by-default posture where only explicitly
+// permitted requests are allowed; the default is DecisionAllow.
+func WithPolicyDefault(decision string) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.defaultDecision = decision
+	}
+}
+
+// WithPrincipal sets the agent ID that requests are evaluated as, matched by
+// principal == Agent::"<id>" in policy heads
+func WithPrincipal(agentID string) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.principal = agentID
+	}
+}
+
+// WithPolicyValidation validates the policy file against a schema (nil for
+// DefaultSchema) when loading it; NewStandaloneInterceptor returns a
+// *PolicyValidationError instead of starting if any errors are found
+func WithPolicyValidation(schema *Schema) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.validate = true
+		si.schema = schema
+	}
+}
+
+// WithLegacyActionScope sets the placeholder action of older policy files,
+// "deploy" by default. Heads scoped to action == Action::"<action>" are
+// loaded as an unconstrained action with a deprecation warning; pass an
+// empty string to load them as an ordinary action constraint.
+func WithLegacyActionScope(action string) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.legacyAction = action
+	}
+}
+
+// WithExplainDenials adds an evaluation trace (see Explain) to the log entry
+// of every denied request, so unexpected denials can be debugged from the
+// JSONL log. Traces cover every rule and make denied entries much larger.
+func WithExplainDenials() StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.explain = true
+	}
+}
+
+// WithPolicyPublicKey requires the policy file to be a PolicyBundle signed
+// with the private key matching key. Unsigned, tampered and expired bundles
+// are refused, both when the interceptor is created and on reload, as are
+// reloaded bundles issued before the one in use. Once the active bundle
+// expires, every request is denied until a fresh one is loaded.
+func WithPolicyPublicKey(key ed25519.PublicKey) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.publicKey = key
+	}
+}
+
+// remotePolicyPrefix namespaces the IDs of remote policies in decisions
+const remotePolicyPrefix = "remote:"
+
+// WithPolicyCache adds the remote policies of a client's policy cache (see
+// WithPolicySync) to the policy set, updating it whenever the cache
+// refreshes. Their IDs carry a "remote:" prefix in decisions and logs. While the remote policies are unavailable, the cache's fail mode
+// applies: FailOpen evaluates the local policies alone and FailClosed denies
+// every request.
+func WithPolicyCache(cache *PolicyCache) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.policyCache = cache
+	}
+}
+
 // NewStandaloneInterceptor creates a standalone interceptor with Cedar policy evaluation
 func NewStandaloneInterceptor(opts ...StandaloneOption) (*StandaloneInterceptor, error) {
 	si := &StandaloneInterceptor{
 		enforcement:     EnforcementLog,
 		excludePatterns: []string{},
+		defaultDecision: DecisionAllow,
+		legacyAction:    legacyAction,
+		lookupIP:        net.DefaultResolver.LookupNetIP,
 	}
 
 	for _, opt := range opts {
 		opt(si)
 	}
 
+	if si.defaultDecision != DecisionAllow && si.defaultDecision != DecisionDeny {
+		return nil, fmt.Errorf("invalid default decision %q, use %q or %q", si.defaultDecision, DecisionAllow, DecisionDeny)
+	}
+
+	if si.policyCache != nil {
+		si.unsubscribe = si.policyCache.subscribe(func() {
+			si.policyMu.Lock()
+			defer si.policyMu.Unlock()
+			si.publish()
+		})
+	}
+
 	// Load policy file if specified
 	if si.policyFile != "" {
-		content, err := os.ReadFile(si.policyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read policy file: %w", err)
+		if err := si.readPolicyFile(); err != nil {
+			if si.unsubscribe != nil {
+				si.unsubscribe()
+			}
+			return nil, err
 		}
-
-		rules, err := ParseCedarPolicy(string(content))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse policy: %w", err)
+	} else {
+		if si.reloadInterval > 0 || si.reloadOnSIGHUP {
+			return nil, fmt.Errorf("policy reload requires a policy file")
 		}
+		si.policyMu.Lock()
+		si.publish()
+		si.policyMu.Unlock()
+	}
 
-		si.rules = rules
+	if err := si.loadShadowFiles(); err != nil {
+		if si.unsubscribe != nil {
+			si.unsubscribe()
+		}
+		return nil, err
 	}
 
 	// Open log file if specified
 	if si.logFile != "" {
 		f, err := os.OpenFile(si.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 		if err != nil {
+			if si.unsubscribe != nil {
+				si.unsubscribe()
+			}
 			return nil, fmt.Errorf("failed to open log file: %w", err)
 		}
 		si.logWriter = f
 	}
 
+	si.startReloaders()
+
 	return si, nil
 }
 
+// loadPolicy parses policy text, from bundle if the file was a verified
+// bundle, and makes it the active policy set. Policies linked from
+// templates are linked again against the new templates. On any error the
+// current policy set is kept.
+func (si *StandaloneInterceptor) loadPolicy(content string, bundle *PolicyBundle) error {
+	policies, err := ParsePolicies(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := si.preparePolicies(policies); err != nil {
+		return err
+	}
+
+	var (
+		rules     []PolicyRule
+		templates []*Policy
+		ids       = make(map[string]bool, len(policies))
+	)
+	for _, policy := range policies {
+		ids[policy.ID] = true
+		if policy.IsTemplate() {
+			templates = append(templates, policy)
+		} else {
+			rules = append(rules, ruleFromPolicy(policy))
+		}
+	}
+
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	linked := make([]*Policy, 0, len(si.linked))
+	for _, old := range si.linked {
+		if ids[old.ID] {
+			return fmt.Errorf("linked policy %q conflicts with a policy of the same id", old.ID)
+		}
+		var template *Policy
+		for _, t := range templates {
+			if t.ID == old.TemplateID {
+				template = t
+			}
+		}
+		if template == nil {
+			return fmt.Errorf("linked policy %q: template %q was removed", old.ID, old.TemplateID)
+		}
+		relinked, err := LinkTemplate(template, old.ID, old.SlotValues)
+		if err != nil {
+			return fmt.Errorf("linked policy %q: %w", old.ID, err)
+		}
+		if si.validate {
+			if errs := validationErrors(validatePolicies([]*Policy{relinked}, si.schema)); len(errs) > 0 {
+				return &PolicyValidationError{Issues: errs}
+			}
+		}
+		linked = append(linked, relinked)
+	}
+
+	si.fileRules, si.fileBundle, si.templates, si.linked = rules, bundle, templates, linked
+	si.publish()
+	return nil
+}
+
+// preparePolicies migrates parsed policies (see WithLegacyActionScope) and,
+// with WithPolicyValidation, validates them, returning a
+// *PolicyValidationError if they have errors
+func (si *StandaloneInterceptor) preparePolicies(policies []*Policy) error {
+	if si.legacyAction != "" {
+		migrateLegacyActionScope(policies, si.legacyAction)
+	}
+	if si.validate {
+		if errs := validationErrors(validatePolicies(policies, si.schema)); len(errs) > 0 {
+			return &PolicyValidationError{Issues: errs}
+		}
+	}
+	return nil
+}
+
+// publish compiles the file rules, linked policies and remote policies into
+// a new active policy. Callers must hold policyMu.
+func (si *StandaloneInterceptor) publish() {
+	rules := make([]PolicyRule, 0, len(si.fileRules)+len(si.linked))
+	rules = append(rules, si.fileRules...)
+	for _, policy := range si.linked {
+		rules = append(rules, ruleFromPolicy(policy))
+	}
+	if si.policyCache == nil {
+		policy := si.compile(rules)
+		policy.bundle = si.fileBundle
+		policy.shadows = si.shadowList()
+		si.active.Store(policy)
+		return
+	}
+
+	local := si.compile(rules)
+	local.bundle = si.fileBundle
+	remote := si.policyCache.Rules()
+	all := make([]PolicyRule, 0, len(rules)+len(remote))
+	all = append(all, rules...)
+	for _, rule := range remote {
+		// Remote IDs are namespaced, so positional IDs such as policy0
+		// never collide with those of the policy file
+		rule.ID = remotePolicyPrefix + rule.ID
+		all = append(all, rule)
+	}
+	policy := si.compile(all)
+	policy.bundle = si.fileBundle
+	policy.local = local
+	policy.shadows = si.shadowList()
+	si.active.Store(policy)
+}
+
+// compile builds a policy version from rules
+func (si *StandaloneInterceptor) compile(rules []PolicyRule) *activePolicy {
+	return &activePolicy{
+		rules:      rules,
+		compiled:   CompilePolicy(rules, WithDefaultDecision(si.defaultDecision)),
+		inspectsIP: rulesReferenceIP(rules),
+	}
+}
+
+// evaluate decides a request against a policy version and returns the
+// version that decided it. While the policy cache's remote policies are
+// unavailable, fail-open evaluates the local policies and fail-closed denies
+// with the cache's reason and no policy version. Once the policy file's
+// bundle expires, requests are denied the same way.
+func (si *StandaloneInterceptor) evaluate(policy *activePolicy, ctx RequestContext) (PolicyDecision, *activePolicy) {
+	if decision, expired := policy.bundle.expiredDecision(time.Now()); expired {
+		return decision, nil
+	}
+	if si.policyCache != nil {
+		if state, reason := si.policyCache.current(); state == nil {
+			if si.policyCache.failMode == FailClosed {
+				return si.policyCache.failDecision(reason), nil
+			}
+			policy = policy.local
+		}
+	}
+	return policy.compiled.Evaluate(ctx), policy
+}
+
+// LinkTemplate links a template from the policy file (see LinkTemplate) and
+// starts enforcing the result under linkID, which must not be used by any
+// other policy. With WithPolicyValidation the linked policy is validated
+// first, returning a *PolicyValidationError if it has errors. Requests
+// already being evaluated finish against the previous policy set.
+func (si *StandaloneInterceptor) LinkTemplate(templateID, linkID string, values map[SlotID]EntityRef) error {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	var template *Policy
+	for _, t := range si.templates {
+		if t.ID == templateID {
+			template = t
+		}
+	}
+	if template == nil {
+		return fmt.Errorf("unknown template %q", templateID)
+	}
+	if si.policyIDInUse(linkID) {
+		return fmt.Errorf("policy id %q is already in use", linkID)
+	}
+
+	linked, err := LinkTemplate(template, linkID, values)
+	if err != nil {
+		return err
+	}
+	if si.validate {
+		if errs := validationErrors(validatePolicies([]*Policy{linked}, si.schema)); len(errs) > 0 {
+			return &PolicyValidationError{Issues: errs}
+		}
+	}
+
+	si.linked = append(si.linked, linked)
+	si.publish()
+	return nil
+}
+
+// UnlinkPolicy stops enforcing a policy created by LinkTemplate
+func (si *StandaloneInterceptor) UnlinkPolicy(id string) error {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	for i, policy := range si.linked {
+		if policy.ID == id {
+			si.linked = append(si.linked[:i:i], si.linked[i+1:]...)
+			si.publish()
+			return nil
+		}
+	}
+	return fmt.Errorf("no linked policy %q", id)
+}
+
+// LinkedPolicies returns the policies created by LinkTemplate, in link order
+func (si *StandaloneInterceptor) LinkedPolicies() []*Policy {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+	return append([]*Policy(nil), si.linked...)
+}
+
+// Templates returns the templates of the policy file
+func (si *StandaloneInterceptor) Templates() []*Policy {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+	return append([]*Policy(nil), si.templates...)
+}
+
+// policyIDInUse reports whether a rule, template or linked policy has the ID
+func (si *StandaloneInterceptor) policyIDInUse(id string) bool {
+	for _, rule := range si.fileRules {
+		if rule.ID == id {
+			return true
+		}
+	}
+	for _, policies := range [][]*Policy{si.templates, si.linked} {
+		for _, policy := range policies {
+			if policy.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // WrapClient wraps an http.Client to intercept requests
 func (si *StandaloneInterceptor) WrapClient(client *http.Client) *http.Client {
 	if client == nil {
@@ -113,15 +497,228 @@ func (si *StandaloneInterceptor) WrapClient(client *http.Client) *http.Client {
 	}
 
 	client.Transport = &standaloneTransport{
-		base:        transport,
+		base:        si.guardTransport(transport),
 		interceptor: si,
 	}
 
 	return client
 }
 
-// Close flushes and closes the log file
+// guardTransport installs the dial-time policy check on an *http.Transport.
+// Other RoundTrippers are returned unchanged, as their dialing is not reachable.
+func (si *StandaloneInterceptor) guardTransport(rt http.RoundTripper) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok || t.DialTLSContext != nil {
+		return rt
+	}
+	t = t.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	t.DialContext = si.guardDial(dial)
+	if t.Proxy != nil {
+		t.Proxy = si.guardProxy(t.Proxy)
+	}
+	return t
+}
+
+// dialRequestKey carries the request being dialed for from RoundTrip to the dialer
+type dialRequestKey struct{}
+
+// dialRequest is the request context and request-time decision of a pending dial
+type dialRequest struct {
+	ctx      RequestContext
+	decision PolicyDecision
+	excluded bool        // the request matched WithExcludePatterns and is not checked
+	proxied  atomic.Bool // the target was checked by guardProxy, the dial goes to the proxy
+}
+
+// pendingDial returns the request being dialed for, or a request for host
+// if the dial did not come through RoundTrip
+func (si *StandaloneInterceptor) pendingDial(ctx context.Context, host string) *dialRequest {
+	if pending, ok := ctx.Value(dialRequestKey{}).(*dialRequest); ok {
+		return pending
+	}
+	return &dialRequest{
+		ctx:      RequestContext{Hostname: host, Principal: si.principal, Action: EventHTTPRequest},
+		decision: PolicyDecision{Decision: DecisionAllow},
+	}
+}
+
+// guardProxy wraps a transport's Proxy function. A proxied request is
+// dialed to the proxy, whose address says nothing about the target, so the
+// target host is resolved and every address it resolves to is evaluated as
+// resource.ip here instead, before the proxy is used. The proxy resolves the
+// name again and may get a different answer, so rules on resource.ip are
+// weaker behind a proxy than with a direct connection.
+func (si *StandaloneInterceptor) guardProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		ctx := req.Context()
+		host := req.URL.Hostname()
+		pending := si.pendingDial(ctx, host)
+		if policy := si.active.Load(); policy.inspectsIP && !pending.excluded {
+			ips, err := si.lookupIP(ctx, "ip", host)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				if err := si.checkDialIP(policy, pending, ip); err != nil {
+					return nil, err
+				}
+			}
+		}
+		pending.proxied.Store(true)
+		return proxyURL, nil
+	}
+}
+
+// guardDial wraps a dial function so each resolved address is evaluated as
+// resource.ip before connecting. Only permitted addresses are dialed, which
+// also closes the gap between checking a name and the address it resolves to.
+// Dials to a proxy were checked by guardProxy and are not evaluated, nor are
+// dials for excluded requests.
+func (si *StandaloneInterceptor) guardDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		policy := si.active.Load()
+		if !policy.inspectsIP {
+			return dial(ctx, network, addr)
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		pending := si.pendingDial(ctx, host)
+		if pending.excluded || pending.proxied.Load() {
+			return dial(ctx, network, addr)
+		}
+
+		ips, err := si.lookupIP(ctx, lookupNetwork(network), host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			if err := si.checkDialIP(policy, pending, ip); err != nil {
+				lastErr = err
+				continue
+			}
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses found for %s", host)
+		}
+		return nil, lastErr
+	}
+}
+
+// checkDialIP evaluates a request resolved to ip, logging a denial and
+// returning a *PolicyViolationError if it is blocked. The dial-time decision
+// is enforced on its own: a request RoundTrip only logged or warned about
+// is still blocked here if a policy on its address blocks it.
+func (si *StandaloneInterceptor) checkDialIP(policy *activePolicy, pending *dialRequest, ip netip.Addr) error {
+	reqCtx := pending.ctx
+	reqCtx.IP = ip.Unmap()
+	decision, decidedBy := si.evaluate(policy, reqCtx)
+	if decision.Decision != DecisionDeny {
+		return nil
+	}
+	mode := si.enforcementFor(decision)
+	enforcementAction := enforcementOutcome(mode)
+
+	// The same denial without a block was already logged by RoundTrip
+	if enforcementAction != "blocked" && pending.decision.Decision == DecisionDeny &&
+		slices.Equal(decision.PolicyIDs, pending.decision.PolicyIDs) {
+		return nil
+	}
+	si.logEvent(eventLog{
+		Timestamp:         time.Now().UTC().Format(time.RFC3339),
+		Method:            reqCtx.Method,
+		URL:               reqCtx.URL,
+		Hostname:          reqCtx.Hostname,
+		Path:              reqCtx.Path,
+		IP:                reqCtx.IP.String(),
+		Principal:         reqCtx.Principal,
+		Action:            string(reqCtx.Action),
+		PolicyDecision:    decision.Decision,
+		EnforcementAction: enforcementAction,
+		Reasons:           strings.Join(decision.Reasons, "; "),
+		PolicyIDs:         decision.PolicyIDs,
+		Trace:             si.explainDenial(decidedBy, reqCtx, decision),
+	})
+	if enforcementAction != "blocked" {
+		return nil
+	}
+	violation := newPolicyViolation("Cedar policy", reqCtx, decision, mode)
+	violation.IP = reqCtx.IP
+	return violation
+}
+
+// lookupNetwork maps a dial network to the matching resolver network
+func lookupNetwork(network string) string {
+	switch network {
+	case "tcp4", "udp4":
+		return "ip4"
+	case "tcp6", "udp6":
+		return "ip6"
+	}
+	return "ip"
+}
+
+// addrString formats an address for the event log, leaving unset addresses empty
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.String()
+}
+
+// rulesReferenceIP reports whether any rule condition reads resource.ip
+func rulesReferenceIP(rules []PolicyRule) bool {
+	found := false
+	for _, rule := range rules {
+		if rule.Field == "ip" {
+			return true
+		}
+		if rule.Policy == nil {
+			continue
+		}
+		for _, cond := range rule.Policy.Conditions {
+			for _, clause := range cond.Clauses {
+				walkExpr(clause, func(e Expr) {
+					switch e := e.(type) {
+					case *AttrExpr:
+						found = found || e.Attr == "ip"
+					case *HasExpr:
+						found = found || e.Attr == "ip"
+					}
+				})
+			}
+		}
+	}
+	return found
+}
+
+// Close stops policy reloading and policy cache updates, and flushes and
+// closes the log file
 func (si *StandaloneInterceptor) Close() error {
+	si.stopReloaders()
+	if si.unsubscribe != nil {
+		si.unsubscribe()
+	}
+
 	si.logMu.Lock()
 	defer si.logMu.Unlock()
 
@@ -140,78 +737,80 @@ type standaloneTransport struct {
 
 // eventLog represents a JSONL log entry
 type eventLog struct {
-	Timestamp         string  `json:"timestamp"`
-	Method            string  `json:"method"`
-	URL               string  `json:"url"`
-	Hostname          string  `json:"hostname"`
-	Path              string  `json:"path"`
-	Status            int     `json:"status,omitempty"`
-	DurationMs        float64 `json:"duration_ms"`
-	PolicyDecision    string  `json:"policy_decision"`
-	EnforcementAction string  `json:"enforcement_action"`
-	Reasons           string  `json:"reasons,omitempty"`
+	Timestamp         string       `json:"timestamp"`
+	Method            string       `json:"method"`
+	URL               string       `json:"url"`
+	Hostname          string       `json:"hostname"`
+	Path              string       `json:"path"`
+	IP                string       `json:"ip,omitempty"`
+	Principal         string       `json:"principal,omitempty"`
+	Action            string       `json:"action,omitempty"`
+	Status            int          `json:"status,omitempty"`
+	DurationMs        float64      `json:"duration_ms"`
+	PolicyDecision    string       `json:"policy_decision"`
+	EnforcementAction string       `json:"enforcement_action"`
+	Reasons           string       `json:"reasons,omitempty"`
+	PolicyIDs         []string     `json:"policy_ids,omitempty"`
+	Trace             *Explanation `json:"trace,omitempty"`
 }
 
 // RoundTrip intercepts HTTP requests and evaluates Cedar policies
 func (t *standaloneTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Check if URL should be excluded
 	if t.shouldExclude(req.URL.String()) {
+		// Excluded requests skip the dial-time check as well
+		req = req.WithContext(context.WithValue(req.Context(), dialRequestKey{}, &dialRequest{excluded: true}))
 		return t.base.RoundTrip(req)
 	}
 
 	startTime := time.Now()
 
 	// Build request context
-	ctx := RequestContext{
-		URL:      req.URL.String(),
-		Method:   req.Method,
-		Hostname: req.URL.Hostname(),
-		Path:     req.URL.Path,
-	}
+	ctx := newRequestContext(req)
+	ctx.Principal = t.interceptor.principal
 
 	// Evaluate policy
-	decision := EvaluatePolicy(ctx, t.interceptor.rules)
+	policy := t.interceptor.active.Load()
+	shadows := policy.shadows
+	decision, policy := t.interceptor.evaluate(policy, ctx)
 
 	// Determine enforcement action
-	var enforcementAction string
-	var blockRequest bool
-
-	if decision.Decision == "Deny" {
-		switch t.interceptor.enforcement {
-		case EnforcementBlock:
-			enforcementAction = "blocked"
-			blockRequest = true
-		case EnforcementWarn:
-			enforcementAction = "warned"
-			blockRequest = false
-		case EnforcementLog:
-			enforcementAction = "logged"
-			blockRequest = false
-		}
-	} else {
-		enforcementAction = "allowed"
-		blockRequest = false
+	enforcementAction := "allowed"
+	var mode EnforcementAction
+	if decision.Decision == DecisionDeny {
+		mode = t.interceptor.enforcementFor(decision)
+		enforcementAction = enforcementOutcome(mode)
 	}
+	if len(shadows) > 0 {
+		t.interceptor.evaluateShadows(shadows, ctx, decision, enforcementAction)
+	}
+	blockRequest := enforcementAction == "blocked"
 
 	// Handle blocking
 	if blockRequest {
 		duration := time.Since(startTime).Milliseconds()
-		t.logEvent(eventLog{
+		t.interceptor.logEvent(eventLog{
 			Timestamp:         time.Now().UTC().Format(time.RFC3339),
 			Method:            req.Method,
 			URL:               req.URL.String(),
 			Hostname:          req.URL.Hostname(),
 			Path:              req.URL.Path,
+			IP:                addrString(ctx.IP),
+			Principal:         ctx.Principal,
+			Action:            string(ctx.Action),
 			DurationMs:        float64(duration),
 			PolicyDecision:    decision.Decision,
 			EnforcementAction: enforcementAction,
 			Reasons:           strings.Join(decision.Reasons, "; "),
+			PolicyIDs:         decision.PolicyIDs,
+			Trace:             t.interceptor.explainDenial(policy, ctx, decision),
 		})
 
-		return nil, fmt.Errorf("request blocked by Cedar policy: %s", strings.Join(decision.Reasons, "; "))
+		return nil, newPolicyViolation("Cedar policy", ctx, decision, mode)
 	}
 
-	// Forward request
+	// Forward request, handing the context to the dial-time check
+	req = req.WithContext(context.WithValue(req.Context(), dialRequestKey{}, &dialRequest{ctx: ctx, decision: decision}))
 	resp, err := t.base.RoundTrip(req)
 
 	duration := time.Since(startTime).Milliseconds()
@@ -223,6 +822,9 @@ func (t *standaloneTransport) RoundTrip(req *http.Request) (*http.Response, erro
 		URL:               req.URL.String(),
 		Hostname:          req.URL.Hostname(),
 		Path:              req.URL.Path,
+		IP:                addrString(ctx.IP),
+		Principal:         ctx.Principal,
+		Action:            string(ctx.Action),
 		DurationMs:        float64(duration),
 		PolicyDecision:    decision.Decision,
 		EnforcementAction: enforcementAction,
@@ -231,16 +833,117 @@ func (t *standaloneTransport) RoundTrip(req *http.Request) (*http.Response, erro
 	if len(decision.Reasons) > 0 {
 		logEntry.Reasons = strings.Join(decision.Reasons, "; ")
 	}
+	logEntry.PolicyIDs = decision.PolicyIDs
+	logEntry.Trace = t.interceptor.explainDenial(policy, ctx, decision)
 
 	if resp != nil {
 		logEntry.Status = resp.StatusCode
 	}
 
-	t.logEvent(logEntry)
+	t.interceptor.logEvent(logEntry)
 
 	return resp, err
 }
 
+// newRequestContext builds the policy evaluation context of an HTTP request
+func newRequestContext(req *http.Request) RequestContext {
+	ctx := RequestContext{
+		URL:           req.URL.String(),
+		Method:        req.Method,
+		Hostname:      req.URL.Hostname(),
+		Path:          req.URL.Path,
+		Action:        EventHTTPRequest,
+		Scheme:        req.URL.Scheme,
+		Headers:       redactHeaders(req.Header),
+		Query:         req.URL.Query(),
+		ContentLength: req.ContentLength,
+	}
+
+	if ip, err := netip.ParseAddr(ctx.Hostname); err == nil {
+		ctx.IP = ip.Unmap()
+	}
+
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		ctx.Port = port
+	} else {
+		switch ctx.Scheme {
+		case "http", "ws":
+			ctx.Port = 80
+		case "https", "wss":
+			ctx.Port = 443
+		}
+	}
+
+	// For client requests a zero length with a body means the length is unknown
+	if ctx.ContentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		ctx.ContentLength = -1
+	}
+
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+			ctx.ContentType = mediaType
+		} else {
+			ctx.ContentType = strings.ToLower(strings.TrimSpace(ct))
+		}
+	}
+
+	return ctx
+}
+
+// redactedValue replaces credentials in request contexts
+const redactedValue = "[REDACTED]"
+
+// redactHeaders returns h with the credentials in Authorization,
+// Proxy-Authorization and Cookie headers replaced by redactedValue, copying
+// it only if it has any. The authorization scheme and cookie names are kept
+// for policies to test, but credentials cannot reach decision reasons, logs
+// or PolicyViolationError.
+func redactHeaders(h http.Header) http.Header {
+	var redacted http.Header
+	for name, values := range h {
+		var redact func(string) string
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case "Authorization", "Proxy-Authorization":
+			redact = redactCredentials
+		case "Cookie":
+			redact = redactCookies
+		default:
+			continue
+		}
+		if redacted == nil {
+			redacted = h.Clone()
+		}
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = redact(v)
+		}
+		redacted[name] = out
+	}
+	if redacted == nil {
+		return h
+	}
+	return redacted
+}
+
+// redactCredentials keeps the scheme of an Authorization value, such as
+// "Bearer", and redacts the rest
+func redactCredentials(v string) string {
+	if scheme, _, ok := strings.Cut(strings.TrimSpace(v), " "); ok {
+		return scheme + " " + redactedValue
+	}
+	return redactedValue
+}
+
+// redactCookies keeps the cookie names of a Cookie value and redacts their values
+func redactCookies(v string) string {
+	cookies := strings.Split(v, ";")
+	for i, cookie := range cookies {
+		name, _, _ := strings.Cut(strings.TrimSpace(cookie), "=")
+		cookies[i] = name + "=" + redactedValue
+	}
+	return strings.Join(cookies, "; ")
+}
+
 // shouldExclude checks if URL matches any exclude patterns
 func (t *standaloneTransport) shouldExclude(urlStr string) bool {
 	for _, pattern := range t.interceptor.excludePatterns {
@@ -252,14 +955,72 @@ func (t *standaloneTransport) shouldExclude(urlStr string) bool {
 	return false
 }
 
+// enforcementRank orders enforcement modes from most to least lenient
+var enforcementRank = map[EnforcementAction]int{
+	EnforcementLog:   1,
+	EnforcementWarn:  2,
+	EnforcementBlock: 3,
+}
+
+// enforcementFor returns the enforcement mode for a denied request: the
+// strictest mode among the matched forbid rules, where a rule's
+// @enforcement annotation overrides the interceptor's mode
+func (si *StandaloneInterceptor) enforcementFor(decision PolicyDecision) EnforcementAction {
+	return enforcementMode(decision, si.enforcement)
+}
+
+// enforcementMode returns the strictest enforcement mode among the matched
+// rules of a denied decision, with defaultMode for rules without an
+// @enforcement annotation
+func enforcementMode(decision PolicyDecision, defaultMode EnforcementAction) EnforcementAction {
+	if len(decision.Rules) == 0 {
+		return defaultMode
+	}
+	var mode EnforcementAction
+	for _, rule := range decision.Rules {
+		ruleMode := defaultMode
+		if v, ok := rule.Annotations["enforcement"]; ok {
+			ruleMode = EnforcementAction(v)
+		}
+		if enforcementRank[ruleMode] > enforcementRank[mode] {
+			mode = ruleMode
+		}
+	}
+	return mode
+}
+
+// enforcementOutcome returns the logged action for a denied request under an enforcement mode
+func enforcementOutcome(mode EnforcementAction) string {
+	switch mode {
+	case EnforcementBlock:
+		return "blocked"
+	case EnforcementWarn:
+		return "warned"
+	}
+	return "logged"
+}
+
+// explainDenial traces a denied request's evaluation when WithExplainDenials is set
+func (si *StandaloneInterceptor) explainDenial(policy *activePolicy, ctx RequestContext, decision PolicyDecision) *Explanation {
+	if !si.explain || decision.Decision != DecisionDeny || policy == nil {
+		return nil
+	}
+	return Explain(ctx, policy.rules, WithDefaultDecision(si.defaultDecision))
+}
+
 // logEvent writes an event to the JSONL log file
-func (t *standaloneTransport) logEvent(entry eventLog) {
-	if t.interceptor.logWriter == nil {
+func (si *StandaloneInterceptor) logEvent(entry eventLog) {
+	si.logRecord(entry)
+}
+
+// logRecord writes a record to the JSONL log file
+func (si *StandaloneInterceptor) logRecord(entry any) {
+	if si.logWriter == nil {
 		return
 	}
 
-	t.interceptor.logMu.Lock()
-	defer t.interceptor.logMu.Unlock()
+	si.logMu.Lock()
+	defer si.logMu.Unlock()
 
 	data, err := json.Marshal(entry)
 	if err != nil {
@@ -267,7 +1028,7 @@ func (t *standaloneTransport) logEvent(entry eventLog) {
 	}
 
 	data = append(data, '\n')
-	t.interceptor.logWriter.Write(data)
+	si.logWriter.Write(data)
 }
 
 // MustNewStandaloneInterceptor creates a standalone interceptor or panics on error
@@ -0,0 +1,167 @@
+package trusera
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// WithPolicyReload checks the policy file for changes every interval and
+// reloads it when its modification time or size changes (see Reload)
+func WithPolicyReload(interval time.Duration) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.reloadInterval = interval
+	}
+}
+
+// WithReloadOnSIGHUP reloads the policy file when the process receives
+// SIGHUP. Signals are ignored on platforms without SIGHUP.
+func WithReloadOnSIGHUP() StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.reloadOnSIGHUP = true
+	}
+}
+
+// WithReloadCallback sets a function called after every reload of the
+// policy file, with nil on success or the reason the previous policy set was
+// kept. Without a callback, failed background reloads are logged.
+func WithReloadCallback(fn func(err error)) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		si.onReload = fn
+	}
+}
+
+// fileStamp identifies a version of the policy file
+type fileStamp struct {
+	modTime int64 // Unix nanoseconds
+	size    int64
+}
+
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+// Reload reads the policy file again and atomically swaps in the new policy
+// set; requests already being evaluated finish against the previous one.
+// Templates are replaced and linked policies are linked again. If the file
+// cannot be read, parsed, validated (with WithPolicyValidation) or relinked,
+// the previous policy set stays active and the error is returned.
+func (si *StandaloneInterceptor) Reload() error {
+	if si.policyFile == "" {
+		return errors.New("no policy file configured")
+	}
+	err := si.readPolicyFile()
+	if si.onReload != nil {
+		si.onReload(err)
+	}
+	return err
+}
+
+// readPolicyFile loads the policy file, recording the version read so the
+// poller does not retry a file that failed to load until it changes again
+func (si *StandaloneInterceptor) readPolicyFile() error {
+	si.reloadMu.Lock()
+	defer si.reloadMu.Unlock()
+
+	if info, err := os.Stat(si.policyFile); err == nil {
+		si.fileStamp = stampOf(info)
+	}
+	content, err := os.ReadFile(si.policyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+	if si.publicKey != nil {
+		bundle, err := verifyPolicyBundle(content, si.publicKey)
+		if err == nil {
+			err = bundle.checkRollback(si.bundleIssuedAt)
+		}
+		if err != nil {
+			return fmt.Errorf("refusing policy file %s: %w", si.policyFile, err)
+		}
+		if err := si.loadPolicy(bundle.Policy, bundle); err != nil {
+			return err
+		}
+		si.bundleIssuedAt = bundle.IssuedAt
+		return nil
+	}
+	return si.loadPolicy(string(content), nil)
+}
+
+// startReloaders starts the policy file poller and SIGHUP handler
+func (si *StandaloneInterceptor) startReloaders() {
+	si.stop = make(chan struct{})
+
+	if si.reloadInterval > 0 {
+		si.reloaders.Add(1)
+		go si.pollPolicyFile()
+	}
+
+	if si.reloadOnSIGHUP {
+		si.sighup = make(chan os.Signal, 1)
+		signal.Notify(si.sighup, syscall.SIGHUP)
+		si.reloaders.Add(1)
+		go func() {
+			defer si.reloaders.Done()
+			for {
+				select {
+				case <-si.stop:
+					return
+				case <-si.sighup:
+					si.backgroundReload()
+				}
+			}
+		}()
+	}
+}
+
+// stopReloaders stops the reload goroutines and waits for them to exit
+func (si *StandaloneInterceptor) stopReloaders() {
+	si.stopOnce.Do(func() {
+		if si.sighup != nil {
+			signal.Stop(si.sighup)
+		}
+		if si.stop != nil {
+			close(si.stop)
+		}
+		si.reloaders.Wait()
+	})
+}
+
+// pollPolicyFile reloads the policy file whenever its stamp changes. A file
+// that is briefly missing, as while an editor replaces it, is skipped.
+func (si *StandaloneInterceptor) pollPolicyFile() {
+	defer si.reloaders.Done()
+
+	ticker := time.NewTicker(si.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-si.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(si.policyFile)
+		if err != nil {
+			continue
+		}
+		si.reloadMu.Lock()
+		changed := stampOf(info) != si.fileStamp
+		si.reloadMu.Unlock()
+		if changed {
+			si.backgroundReload()
+		}
+	}
+}
+
+// backgroundReload reloads the policy file, logging failures when no reload
+// callback is set
+func (si *StandaloneInterceptor) backgroundReload() {
+	if err := si.Reload(); err != nil && si.onReload == nil {
+		log.Printf("[trusera] policy reload failed, keeping previous policy: %v", err)
+	}
+}
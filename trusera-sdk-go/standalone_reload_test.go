@@ -0,0 +1,276 @@
+package trusera
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// reloadTestSetup writes a policy file and starts a backend, returning the
+// policy path and the backend
+func reloadTestSetup(t *testing.T, policy string) (string, *httptest.Server) {
+	t.Helper()
+	policyPath := filepath.Join(t.TempDir(), "policy.cedar")
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+	return policyPath, backend
+}
+
+// rewritePolicyFile replaces the policy file, moving its modification time forward
+// so pollers see the change even on coarse-grained file systems
+func rewritePolicyFile(t *testing.T, path, policy string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat policy file: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	next := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, next, next); err != nil {
+		t.Fatalf("failed to set policy file time: %v", err)
+	}
+}
+
+// blocked reports whether a GET of path is blocked by the interceptor
+func blocked(t *testing.T, client *http.Client, backend *httptest.Server, path string) bool {
+	t.Helper()
+	resp, err := client.Get(backend.URL + path)
+	if err != nil {
+		if !strings.Contains(err.Error(), "blocked by Cedar policy") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return true
+	}
+	resp.Body.Close()
+	return false
+}
+
+func TestStandaloneInterceptorReload(t *testing.T) {
+	policyPath, backend := reloadTestSetup(t, `forbid (principal, action, resource) when { resource.path == "/a" };`)
+
+	var reloadErrs []error
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithReloadCallback(func(err error) { reloadErrs = append(reloadErrs, err) }),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(&http.Client{})
+
+	if !blocked(t, client, backend, "/a") || blocked(t, client, backend, "/b") {
+		t.Fatal("expected only /a to be blocked")
+	}
+
+	rewritePolicyFile(t, policyPath, `forbid (principal, action, resource) when { resource.path == "/b" };`)
+	if err := si.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if blocked(t, client, backend, "/a") || !blocked(t, client, backend, "/b") {
+		t.Error("expected only /b to be blocked after reload")
+	}
+
+	// A broken policy keeps the previous one
+	rewritePolicyFile(t, policyPath, `forbid (principal, action, resource) when { resource.path == };`)
+	err = si.Reload()
+	if err == nil || !strings.Contains(err.Error(), "failed to parse policy") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+	if !blocked(t, client, backend, "/b") {
+		t.Error("expected previous policy to stay active")
+	}
+
+	if len(reloadErrs) != 2 || reloadErrs[0] != nil || reloadErrs[1] == nil {
+		t.Errorf("expected callback for each reload, got %v", reloadErrs)
+	}
+}
+
+func TestStandaloneInterceptorReloadRelinksTemplates(t *testing.T) {
+	policyPath, backend := reloadTestSetup(t, `
+@id("agent-path")
+forbid (principal == ?principal, action, resource) when { resource.path == "/a" };
+`)
+
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPrincipal("billing-bot"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(&http.Client{})
+
+	if err := si.LinkTemplate("agent-path", "billing", map[SlotID]EntityRef{SlotPrincipal: {Type: "Agent", ID: "billing-bot"}}); err != nil {
+		t.Fatalf("failed to link template: %v", err)
+	}
+	if !blocked(t, client, backend, "/a") {
+		t.Fatal("expected linked policy to block /a")
+	}
+
+	rewritePolicyFile(t, policyPath, `
+@id("agent-path")
+forbid (principal == ?principal, action, resource) when { resource.path == "/b" };
+`)
+	if err := si.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if blocked(t, client, backend, "/a") || !blocked(t, client, backend, "/b") {
+		t.Error("expected linked policy to follow the reloaded template")
+	}
+
+	rewritePolicyFile(t, policyPath, `forbid (principal, action, resource) when { resource.path == "/c" };`)
+	if err := si.Reload(); err == nil || !strings.Contains(err.Error(), `template "agent-path" was removed`) {
+		t.Errorf("expected removed template error, got %v", err)
+	}
+	if !blocked(t, client, backend, "/b") || blocked(t, client, backend, "/c") {
+		t.Error("expected previous policy to stay active")
+	}
+}
+
+func TestStandaloneInterceptorPolicyReloadPolling(t *testing.T) {
+	policyPath, backend := reloadTestSetup(t, `forbid (principal, action, resource) when { resource.path == "/a" };`)
+
+	reloads := make(chan error, 10)
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPolicyReload(10*time.Millisecond),
+		WithReloadCallback(func(err error) { reloads <- err }),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(&http.Client{})
+
+	rewritePolicyFile(t, policyPath, `forbid (principal, action, resource) when { resource.path == "/b" };`)
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("policy change was not picked up")
+	}
+	if !blocked(t, client, backend, "/b") {
+		t.Error("expected reloaded policy to block /b")
+	}
+
+	// A broken file is reported once, not on every poll
+	rewritePolicyFile(t, policyPath, `permit (`)
+	select {
+	case err := <-reloads:
+		if err == nil {
+			t.Fatal("expected reload error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("policy change was not picked up")
+	}
+	select {
+	case err := <-reloads:
+		t.Errorf("unexpected repeated reload: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStandaloneInterceptorReloadOnSIGHUP(t *testing.T) {
+	policyPath, backend := reloadTestSetup(t, `forbid (principal, action, resource) when { resource.path == "/a" };`)
+
+	reloads := make(chan error, 1)
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithReloadOnSIGHUP(),
+		WithReloadCallback(func(err error) { reloads <- err }),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+
+	rewritePolicyFile(t, policyPath, `forbid (principal, action, resource) when { resource.path == "/b" };`)
+	si.sighup <- syscall.SIGHUP
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP did not reload the policy")
+	}
+	if !blocked(t, si.WrapClient(&http.Client{}), backend, "/b") {
+		t.Error("expected reloaded policy to block /b")
+	}
+}
+
+func TestStandaloneInterceptorReloadDuringRequests(t *testing.T) {
+	policyA := `forbid (principal, action, resource) when { resource.path == "/x" };`
+	policyB := `forbid (principal, action, resource) when { resource.path == "/x" && resource.method == "GET" };`
+	policyPath, backend := reloadTestSetup(t, policyA)
+
+	si, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(&http.Client{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				// Both versions block GET /x
+				if !blocked(t, client, backend, "/x") {
+					t.Error("expected /x to be blocked by every policy version")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		policy := policyA
+		if i%2 == 0 {
+			policy = policyB
+		}
+		if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+			t.Fatalf("failed to write policy file: %v", err)
+		}
+		if err := si.Reload(); err != nil {
+			t.Fatalf("failed to reload: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestStandaloneInterceptorReloadRequiresPolicyFile(t *testing.T) {
+	if _, err := NewStandaloneInterceptor(WithPolicyReload(time.Second)); err == nil {
+		t.Error("expected error for reload without a policy file")
+	}
+	si, err := NewStandaloneInterceptor()
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	if err := si.Reload(); err == nil {
+		t.Error("expected Reload without a policy file to fail")
+	}
+}
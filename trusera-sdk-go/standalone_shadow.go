@@ -0,0 +1,227 @@
+package trusera
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WithShadowPolicyFile evaluates every request against the policy file at
+// path as the shadow policy set name, alongside the enforced policy. Shadow
+// sets never affect requests; requests they would handle differently are
+// logged as shadow_disagreement records and counted (see ShadowStats).
+// A shadow rule's @enforcement annotation sets the enforcement it would
+// have, so a policy meant for block mode can be trialed while the
+// interceptor only logs. The file is verified and validated like the
+// policy file, see SetShadowPolicy.
+func WithShadowPolicyFile(name, path string) StandaloneOption {
+	return func(si *StandaloneInterceptor) {
+		if si.shadowFiles == nil {
+			si.shadowFiles = map[string]string{}
+		}
+		si.shadowFiles[name] = filepath.Clean(path)
+	}
+}
+
+// ShadowStats counts the requests a shadow policy set was evaluated against
+type ShadowStats struct {
+	Evaluated     uint64 // requests evaluated
+	Disagreements uint64 // requests the shadow set would handle differently
+	WouldBlock    uint64 // disagreements the shadow set would have blocked
+	WouldAllow    uint64 // disagreements blocked by the enforced policy that the shadow set would have let through
+}
+
+// shadowPolicy is a compiled shadow policy set. Its counters are shared by
+// every version of the set, so they survive updates.
+type shadowPolicy struct {
+	name     string
+	compiled *CompiledPolicy
+	bundle   *PolicyBundle // the verified bundle, with a public key
+	counters *shadowCounters
+}
+
+type shadowCounters struct {
+	evaluated     atomic.Uint64
+	disagreements atomic.Uint64
+	wouldBlock    atomic.Uint64
+	wouldAllow    atomic.Uint64
+}
+
+// shadowLog is the JSONL record of a shadow disagreement
+type shadowLog struct {
+	Type              string   `json:"type"`
+	Timestamp         string   `json:"timestamp"`
+	Shadow            string   `json:"shadow"`
+	Method            string   `json:"method"`
+	URL               string   `json:"url"`
+	Hostname          string   `json:"hostname"`
+	Path              string   `json:"path"`
+	Principal         string   `json:"principal,omitempty"`
+	Action            string   `json:"action,omitempty"`
+	PolicyDecision    string   `json:"policy_decision"`
+	EnforcementAction string   `json:"enforcement_action"`
+	PolicyIDs         []string `json:"policy_ids,omitempty"`
+	ShadowDecision    string   `json:"shadow_decision"`
+	ShadowEnforcement string   `json:"shadow_enforcement_action"`
+	ShadowReasons     string   `json:"shadow_reasons,omitempty"`
+	ShadowPolicyIDs   []string `json:"shadow_policy_ids,omitempty"`
+}
+
+// SetShadowPolicy parses policy text and makes it the shadow policy set
+// name, replacing any set of that name; its counters carry over. Shadow
+// policies are checked like the policy file: with WithPolicyPublicKey the
+// text must be a signed PolicyBundle, and with WithPolicyValidation it is
+// validated, returning a *PolicyValidationError if it has errors.
+func (si *StandaloneInterceptor) SetShadowPolicy(name, policy string) error {
+	if name == "" {
+		return fmt.Errorf("shadow policy name must not be empty")
+	}
+	var bundle *PolicyBundle
+	if si.publicKey != nil {
+		var err error
+		if bundle, err = verifyPolicyBundle([]byte(policy), si.publicKey); err != nil {
+			return fmt.Errorf("refusing shadow policy %q: %w", name, err)
+		}
+		policy = bundle.Policy
+	}
+	policies, err := ParsePolicies(policy)
+	if err != nil {
+		return fmt.Errorf("failed to parse shadow policy %q: %w", name, err)
+	}
+	if err := si.preparePolicies(policies); err != nil {
+		return fmt.Errorf("shadow policy %q: %w", name, err)
+	}
+	rules := make([]PolicyRule, 0, len(policies))
+	for _, p := range policies {
+		if !p.IsTemplate() {
+			rules = append(rules, ruleFromPolicy(p))
+		}
+	}
+
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	if si.shadows == nil {
+		si.shadows = map[string]*shadowPolicy{}
+	}
+	counters := &shadowCounters{}
+	if old, ok := si.shadows[name]; ok {
+		counters = old.counters
+	}
+	si.shadows[name] = &shadowPolicy{
+		name:     name,
+		compiled: CompilePolicy(rules, WithDefaultDecision(si.defaultDecision)),
+		bundle:   bundle,
+		counters: counters,
+	}
+	si.publish()
+	return nil
+}
+
+// RemoveShadowPolicy stops evaluating the shadow policy set name
+func (si *StandaloneInterceptor) RemoveShadowPolicy(name string) error {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	if _, ok := si.shadows[name]; !ok {
+		return fmt.Errorf("no shadow policy %q", name)
+	}
+	delete(si.shadows, name)
+	si.publish()
+	return nil
+}
+
+// ShadowStats returns the counters of each shadow policy set by name
+func (si *StandaloneInterceptor) ShadowStats() map[string]ShadowStats {
+	si.policyMu.Lock()
+	defer si.policyMu.Unlock()
+
+	stats := make(map[string]ShadowStats, len(si.shadows))
+	for name, shadow := range si.shadows {
+		stats[name] = ShadowStats{
+			Evaluated:     shadow.counters.evaluated.Load(),
+			Disagreements: shadow.counters.disagreements.Load(),
+			WouldBlock:    shadow.counters.wouldBlock.Load(),
+			WouldAllow:    shadow.counters.wouldAllow.Load(),
+		}
+	}
+	return stats
+}
+
+// loadShadowFiles reads the shadow policy files given as options
+func (si *StandaloneInterceptor) loadShadowFiles() error {
+	for name, path := range si.shadowFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read shadow policy file: %w", err)
+		}
+		if err := si.SetShadowPolicy(name, string(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shadowList returns the shadow sets ordered by name, for publishing.
+// Callers must hold policyMu.
+func (si *StandaloneInterceptor) shadowList() []*shadowPolicy {
+	if len(si.shadows) == 0 {
+		return nil
+	}
+	list := make([]*shadowPolicy, 0, len(si.shadows))
+	for _, shadow := range si.shadows {
+		list = append(list, shadow)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// evaluateShadows evaluates a request against each shadow set, counting and
+// logging those that disagree with the enforced decision and action
+func (si *StandaloneInterceptor) evaluateShadows(shadows []*shadowPolicy, ctx RequestContext, decision PolicyDecision, enforcementAction string) {
+	for _, shadow := range shadows {
+		shadowDecision, expired := shadow.bundle.expiredDecision(time.Now())
+		if !expired {
+			shadowDecision = shadow.compiled.Evaluate(ctx)
+		}
+		shadowAction := "allowed"
+		if shadowDecision.Decision == DecisionDeny {
+			shadowAction = enforcementOutcome(si.enforcementFor(shadowDecision))
+		}
+
+		shadow.counters.evaluated.Add(1)
+		if shadowDecision.Decision == decision.Decision && shadowAction == enforcementAction {
+			continue
+		}
+		shadow.counters.disagreements.Add(1)
+		switch {
+		case shadowAction == "blocked" && enforcementAction != "blocked":
+			shadow.counters.wouldBlock.Add(1)
+		case shadowAction != "blocked" && enforcementAction == "blocked":
+			shadow.counters.wouldAllow.Add(1)
+		}
+
+		si.logRecord(shadowLog{
+			Type:              "shadow_disagreement",
+			Timestamp:         time.Now().UTC().Format(time.RFC3339),
+			Shadow:            shadow.name,
+			Method:            ctx.Method,
+			URL:               ctx.URL,
+			Hostname:          ctx.Hostname,
+			Path:              ctx.Path,
+			Principal:         ctx.Principal,
+			Action:            string(ctx.Action),
+			PolicyDecision:    decision.Decision,
+			EnforcementAction: enforcementAction,
+			PolicyIDs:         decision.PolicyIDs,
+			ShadowDecision:    shadowDecision.Decision,
+			ShadowEnforcement: shadowAction,
+			ShadowReasons:     strings.Join(shadowDecision.Reasons, "; "),
+			ShadowPolicyIDs:   shadowDecision.PolicyIDs,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package trusera
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStandaloneInterceptorShadowPolicy(t *testing.T) {
+	policyPath, backend := reloadTestSetup(t, `@id("no-admin")
+forbid (principal, action, resource) when { resource.path like "/admin*" };`)
+	dir := filepath.Dir(policyPath)
+	shadowPath := filepath.Join(dir, "strict.cedar")
+	if err := os.WriteFile(shadowPath, []byte(`@id("strict-no-internal")
+@enforcement("block")
+forbid (principal, action, resource) when { resource.path like "/internal*" };`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "events.jsonl")
+
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithLogFile(logPath),
+		WithShadowPolicyFile("strict", shadowPath),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(nil)
+
+	// The shadow set only observes: /internal goes through, /admin is blocked
+	if blocked(t, client, backend, "/internal/keys") {
+		t.Error("expected the shadow policy not to block requests")
+	}
+	if !blocked(t, client, backend, "/admin") {
+		t.Error("expected the enforced policy to block /admin")
+	}
+	if blocked(t, client, backend, "/public") {
+		t.Error("expected /public to be allowed")
+	}
+
+	want := ShadowStats{Evaluated: 3, Disagreements: 2, WouldBlock: 1, WouldAllow: 1}
+	if got := si.ShadowStats()["strict"]; got != want {
+		t.Errorf("expected stats %+v, got %+v", want, got)
+	}
+
+	// Updating the shadow set keeps its counters
+	if err := si.SetShadowPolicy("strict", `forbid (principal, action, resource) when { resource.path like "/public*" };`); err != nil {
+		t.Fatalf("failed to set shadow policy: %v", err)
+	}
+	blocked(t, client, backend, "/public")
+	if got := si.ShadowStats()["strict"]; got.Evaluated != 4 || got.Disagreements != 3 {
+		t.Errorf("expected counters to carry over, got %+v", got)
+	}
+
+	if err := si.RemoveShadowPolicy("strict"); err != nil {
+		t.Fatalf("failed to remove shadow policy: %v", err)
+	}
+	if len(si.ShadowStats()) != 0 {
+		t.Error("expected no shadow sets after removal")
+	}
+	if err := si.RemoveShadowPolicy("strict"); err == nil {
+		t.Error("expected error removing an unknown shadow set")
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer f.Close()
+	var records []shadowLog
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if !strings.Contains(scanner.Text(), `"type":"shadow_disagreement"`) {
+			continue
+		}
+		var record shadowLog
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("failed to parse log record: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 disagreement records, got %d", len(records))
+	}
+	first := records[0]
+	if first.Shadow != "strict" || first.Path != "/internal/keys" || first.EnforcementAction != "allowed" ||
+		first.ShadowEnforcement != "blocked" || len(first.ShadowPolicyIDs) != 1 || first.ShadowPolicyIDs[0] != "strict-no-internal" {
+		t.Errorf("unexpected disagreement record: %+v", first)
+	}
+	if second := records[1]; second.Path != "/admin" || second.EnforcementAction != "blocked" || second.ShadowDecision != DecisionAllow {
+		t.Errorf("unexpected disagreement record: %+v", second)
+	}
+}
+
+func TestShadowPolicyErrors(t *testing.T) {
+	_, err := NewStandaloneInterceptor(WithShadowPolicyFile("missing", filepath.Join(t.TempDir(), "missing.cedar")))
+	if err == nil || !strings.Contains(err.Error(), "failed to read shadow policy file") {
+		t.Errorf("expected read error, got %v", err)
+	}
+
+	si := MustNewStandaloneInterceptor()
+	defer si.Close()
+	if err := si.SetShadowPolicy("bad", "forbid ("); err == nil || !strings.Contains(err.Error(), `shadow policy "bad"`) {
+		t.Errorf("expected parse error, got %v", err)
+	}
+	if err := si.SetShadowPolicy("", noDeletePolicy); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
+
+func TestShadowPolicyVerifiedAndValidated(t *testing.T) {
+	pub, priv := bundleTestKey(0)
+	_, otherPriv := bundleTestKey(1)
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.json")
+	shadowPath := filepath.Join(tmpDir, "shadow.cedar")
+	writeBundle(t, policyPath, noDeletePolicy, "v1", priv)
+
+	// Plain Cedar shadow files are refused once the policy file must be signed
+	if err := os.WriteFile(shadowPath, []byte(noDeletePolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub), WithShadowPolicyFile("next", shadowPath))
+	if !errors.Is(err, ErrPolicyUnsigned) {
+		t.Fatalf("expected unsigned shadow policy to be refused, got %v", err)
+	}
+
+	writeBundle(t, shadowPath, noDeletePolicy, "v2", priv)
+	si, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyPublicKey(pub), WithShadowPolicyFile("next", shadowPath))
+	if err != nil {
+		t.Fatalf("expected signed shadow policy to load, got %v", err)
+	}
+	defer si.Close()
+
+	bundle, err := SignPolicyBundle(noDeletePolicy, "v3", time.Hour, otherPriv)
+	if err != nil {
+		t.Fatalf("failed to sign bundle: %v", err)
+	}
+	data, _ := json.Marshal(bundle)
+	if err := si.SetShadowPolicy("next", string(data)); !errors.Is(err, ErrPolicySignature) {
+		t.Errorf("expected a bundle signed with another key to be refused, got %v", err)
+	}
+
+	// Shadow policies are validated like the policy file
+	validated := MustNewStandaloneInterceptor(WithPolicyValidation(nil))
+	defer validated.Close()
+	err = validated.SetShadowPolicy("typo", `forbid (principal, action, resource) when { resource.hostnme == "x" };`)
+	var validationErr *PolicyValidationError
+	if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), `shadow policy "typo"`) {
+		t.Errorf("expected a *PolicyValidationError for the shadow policy, got %v", err)
+	}
+	if stats := validated.ShadowStats(); len(stats) != 0 {
+		t.Errorf("expected the invalid shadow policy not to be installed, got %v", stats)
+	}
+}
@@ -2,9 +2,16 @@ package trusera
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -44,8 +51,8 @@ when {
 		t.Errorf("expected enforcement mode block, got %s", si.enforcement)
 	}
 
-	if len(si.rules) != 1 {
-		t.Errorf("expected 1 rule loaded, got %d", len(si.rules))
+	if len(si.active.Load().rules) != 1 {
+		t.Errorf("expected 1 rule loaded, got %d", len(si.active.Load().rules))
 	}
 
 	if si.logWriter == nil {
@@ -114,6 +121,17 @@ when {
 		t.Errorf("unexpected error message: %v", err)
 	}
 
+	var urlErr *url.Error
+	var violation *PolicyViolationError
+	if !errors.As(err, &urlErr) || !errors.As(err, &violation) {
+		t.Fatalf("expected a *PolicyViolationError inside a *url.Error, got %T", err)
+	}
+	if violation.Decision != DecisionDeny || len(violation.PolicyIDs) != 1 || violation.PolicyIDs[0] != "policy0" ||
+		violation.Enforcement != EnforcementBlock || violation.Method != "GET" || violation.Hostname != "blocked.example.com" ||
+		len(violation.Reasons) != 1 || violation.IP.IsValid() {
+		t.Errorf("unexpected violation: %+v", violation)
+	}
+
 	if resp != nil {
 		resp.Body.Close()
 		t.Error("expected nil response for blocked request")
@@ -598,3 +616,786 @@ when {
 		t.Errorf("expected status 200, got %d", logEntry.Status)
 	}
 }
+
+func TestStandaloneInterceptorDefaultDeny(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+permit ( principal, action, resource )
+when { resource.path == "/allowed" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPolicyDefault(DecisionDeny),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	client := si.WrapClient(&http.Client{})
+
+	resp, err := client.Get(backend.URL + "/allowed")
+	if err != nil {
+		t.Fatalf("permitted request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(backend.URL + "/other")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected unpermitted request to be blocked")
+	}
+}
+
+func TestStandaloneInterceptorInvalidPolicyDefault(t *testing.T) {
+	_, err := NewStandaloneInterceptor(WithPolicyDefault("Maybe"))
+	if err == nil {
+		t.Error("expected error for invalid default decision")
+	}
+}
+
+func TestStandaloneInterceptorPrincipalScope(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+	logPath := filepath.Join(tmpDir, "events.jsonl")
+
+	policy := `
+forbid ( principal == Agent::"billing-bot", action == Action::"http_request", resource )
+when { resource.path == "/payments" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	billing, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPrincipal("billing-bot"),
+		WithLogFile(logPath),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer billing.Close()
+
+	support, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPrincipal("support-bot"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer support.Close()
+
+	if _, err := billing.WrapClient(&http.Client{}).Get(backend.URL + "/payments"); err == nil {
+		t.Error("expected billing-bot request to be blocked")
+	}
+
+	resp, err := support.WrapClient(&http.Client{}).Get(backend.URL + "/payments")
+	if err != nil {
+		t.Fatalf("expected support-bot request to pass: %v", err)
+	}
+	resp.Body.Close()
+
+	logData, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+
+	var logEntry eventLog
+	if err := json.Unmarshal(logData, &logEntry); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+
+	if logEntry.Principal != "billing-bot" || logEntry.Action != "http_request" {
+		t.Errorf("expected principal and action in log, got %q/%q", logEntry.Principal, logEntry.Action)
+	}
+}
+
+func TestStandaloneInterceptorBlocksResolvedAddresses(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+	logPath := filepath.Join(tmpDir, "events.jsonl")
+
+	policy := `
+forbid ( principal, action, resource )
+when { resource.ip.isLoopback() || resource.ip.isInRange(ip("10.0.0.0/8")) };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	interceptor, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithLogFile(logPath),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	// Resolve names through a fixed table instead of DNS
+	interceptor.lookupIP = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		switch host {
+		case "internal.test":
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		case "mixed.test":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("127.0.0.1")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	client := interceptor.WrapClient(&http.Client{})
+
+	// The IP literal is checked before the request is sent
+	if _, err := client.Get(backend.URL); err == nil || !strings.Contains(err.Error(), "request blocked") {
+		t.Errorf("expected IP literal request to be blocked, got %v", err)
+	}
+
+	// A hostname is checked against the address it resolves to at dial time
+	_, err = client.Get("http://internal.test:" + port + "/")
+	if err == nil || !strings.Contains(err.Error(), "connection to 127.0.0.1 blocked") {
+		t.Errorf("expected dial to loopback to be blocked, got %v", err)
+	}
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected a *PolicyViolationError, got %T", err)
+	}
+	if violation.IP != netip.MustParseAddr("127.0.0.1") || violation.Hostname != "internal.test" || violation.Enforcement != EnforcementBlock {
+		t.Errorf("unexpected dial violation: %+v", violation)
+	}
+
+	logData, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	// blocked IP literal, blocked dial, then the failed request itself
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log entries, got %d", len(lines))
+	}
+
+	var logEntry eventLog
+	if err := json.Unmarshal([]byte(lines[1]), &logEntry); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if logEntry.Hostname != "internal.test" || logEntry.IP != "127.0.0.1" || logEntry.EnforcementAction != "blocked" {
+		t.Errorf("unexpected dial log entry: %+v", logEntry)
+	}
+
+	// Warn mode logs the forbidden address but still connects
+	warn, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithEnforcement(EnforcementWarn))
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer warn.Close()
+	warn.lookupIP = interceptor.lookupIP
+
+	resp, err := warn.WrapClient(&http.Client{}).Get("http://internal.test:" + port + "/")
+	if err != nil {
+		t.Fatalf("expected warn mode to connect: %v", err)
+	}
+	resp.Body.Close()
+
+	// Forbidden addresses are skipped in favour of permitted ones
+	privatePath := filepath.Join(tmpDir, "private.cedar")
+	privatePolicy := `forbid ( principal, action, resource ) when { resource.ip.isInRange(ip("10.0.0.0/8")) };`
+	if err := os.WriteFile(privatePath, []byte(privatePolicy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	private, err := NewStandaloneInterceptor(WithPolicyFile(privatePath), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer private.Close()
+	private.lookupIP = interceptor.lookupIP
+
+	resp, err = private.WrapClient(&http.Client{}).Get("http://mixed.test:" + port + "/")
+	if err != nil {
+		t.Fatalf("expected dial to fall through to the permitted address: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestStandaloneInterceptorDialCheckAfterLoggedDenial(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+@id("audit-root")
+@enforcement("log")
+forbid ( principal, action, resource )
+when { resource.path == "/" };
+
+@id("no-loopback")
+forbid ( principal, action, resource )
+when { resource.ip.isLoopback() };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	interceptor, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithExcludePatterns("localhost"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	interceptor.lookupIP = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		switch host {
+		case "internal.test", "localhost":
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	client := interceptor.WrapClient(&http.Client{})
+
+	// A denial that is only logged does not waive the dial-time check
+	_, err = client.Get("http://internal.test:" + port + "/")
+	if err == nil || !strings.Contains(err.Error(), "connection to 127.0.0.1 blocked") {
+		t.Errorf("expected dial to loopback to be blocked, got %v", err)
+	}
+
+	// Excluded requests skip every check, including the dial-time one
+	resp, err := client.Get("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("expected excluded request to connect: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestStandaloneInterceptorChecksProxiedTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+forbid ( principal, action, resource )
+when { resource.ip.isLoopback() || resource.ip.isInRange(ip("10.0.0.0/8")) };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	// The proxy listens on loopback, which the policy forbids as a target
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	interceptor, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithEnforcement(EnforcementBlock))
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	interceptor.lookupIP = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		switch host {
+		case "internal.test":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil
+		case "public.test":
+			return []netip.Addr{netip.MustParseAddr("203.0.113.7")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	client := interceptor.WrapClient(&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}})
+
+	// The target is evaluated, not the proxy's address
+	resp, err := client.Get("http://public.test/")
+	if err != nil {
+		t.Fatalf("expected proxied request to a public address to pass: %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = client.Get("http://internal.test/")
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected proxied request to a private address to be blocked, got %v", err)
+	}
+	if violation.IP != netip.MustParseAddr("10.0.0.1") || violation.Hostname != "internal.test" {
+		t.Errorf("unexpected violation: %+v", violation)
+	}
+	if len(proxied) != 1 || proxied[0] != "public.test" {
+		t.Errorf("expected only public.test to reach the proxy, got %v", proxied)
+	}
+}
+
+func TestStandaloneInterceptorSkipsDialCheckWithoutIPRules(t *testing.T) {
+	interceptor, err := NewStandaloneInterceptor()
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	interceptor.lookupIP = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		t.Errorf("unexpected lookup of %s", host)
+		return nil, nil
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	resp, err := interceptor.WrapClient(&http.Client{}).Get(backend.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestStandaloneInterceptorRequestAttributes(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+forbid ( principal, action, resource )
+when { resource.method == "POST" && resource.body_size > 16 };
+
+forbid ( principal, action, resource )
+when { resource.header has "x-debug" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	interceptor, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	client := interceptor.WrapClient(&http.Client{})
+
+	resp, err := client.Post(backend.URL, "text/plain", strings.NewReader("small"))
+	if err != nil {
+		t.Fatalf("expected small body to pass: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := client.Post(backend.URL, "text/plain", strings.NewReader(strings.Repeat("x", 64))); err == nil {
+		t.Error("expected large body to be blocked")
+	}
+
+	req, _ := http.NewRequest("GET", backend.URL, nil)
+	req.Header.Set("X-Debug", "true")
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected request with X-Debug header to be blocked")
+	}
+}
+
+func TestNewRequestContext(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://api.example.com/v1/upload?mode=fast&mode=slow", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "Application/JSON; charset=utf-8")
+
+	ctx := newRequestContext(req)
+
+	if ctx.Scheme != "https" || ctx.Port != 443 {
+		t.Errorf("expected https on 443, got %s on %d", ctx.Scheme, ctx.Port)
+	}
+	if ctx.ContentLength != 2 {
+		t.Errorf("expected content length 2, got %d", ctx.ContentLength)
+	}
+	if ctx.ContentType != "application/json" {
+		t.Errorf("expected media type application/json, got %q", ctx.ContentType)
+	}
+	if ctx.Query.Get("mode") != "fast" {
+		t.Errorf("expected query mode=fast, got %q", ctx.Query.Get("mode"))
+	}
+
+	// Credentials are redacted, keeping the scheme and cookie names
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	req.Header.Set("Cookie", "session=abc; theme=dark")
+	req.Header.Set("X-Debug", "1")
+	ctx = newRequestContext(req)
+	if got := ctx.Headers.Get("Authorization"); got != "Bearer [REDACTED]" {
+		t.Errorf("expected Authorization to be redacted, got %q", got)
+	}
+	if got := ctx.Headers.Get("Cookie"); got != "session=[REDACTED]; theme=[REDACTED]" {
+		t.Errorf("expected Cookie to be redacted, got %q", got)
+	}
+	if got := ctx.Headers.Get("X-Debug"); got != "1" {
+		t.Errorf("expected other headers to be kept, got %q", got)
+	}
+	if req.Header.Get("Authorization") != "Bearer sk-secret" {
+		t.Error("expected the request's own headers to be left alone")
+	}
+
+	req, _ = http.NewRequest("PUT", "http://localhost:8080/", io.NopCloser(strings.NewReader("stream")))
+	ctx = newRequestContext(req)
+	if ctx.Port != 8080 {
+		t.Errorf("expected explicit port 8080, got %d", ctx.Port)
+	}
+	if ctx.ContentLength >= 0 {
+		t.Errorf("expected unknown content length for streamed body, got %d", ctx.ContentLength)
+	}
+}
+
+func TestStandaloneInterceptorEnforcementOverride(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+	logPath := filepath.Join(tmpDir, "events.jsonl")
+
+	policy := `
+@id("noisy-post-check")
+@enforcement("warn")
+forbid ( principal, action, resource )
+when { resource.method == "POST" };
+
+@id("no-delete")
+forbid ( principal, action, resource )
+when { resource.method == "DELETE" };
+
+@id("audit-only")
+@enforcement("log")
+forbid ( principal, action, resource )
+when { resource.path == "/audit" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	interceptor, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithLogFile(logPath),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	client := interceptor.WrapClient(&http.Client{})
+
+	// The warn override lets the request through
+	resp, err := client.Post(backend.URL, "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("expected warn-mode rule to let POST through: %v", err)
+	}
+	resp.Body.Close()
+
+	// Rules without an override still block
+	req, _ := http.NewRequest("DELETE", backend.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected DELETE to be blocked")
+	}
+
+	// The strictest matching rule wins
+	req, _ = http.NewRequest("DELETE", backend.URL+"/audit", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected DELETE /audit to be blocked")
+	}
+
+	logData, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log entries, got %d", len(lines))
+	}
+
+	var warned, blocked eventLog
+	if err := json.Unmarshal([]byte(lines[0]), &warned); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &blocked); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+
+	if warned.EnforcementAction != "warned" || len(warned.PolicyIDs) != 1 || warned.PolicyIDs[0] != "noisy-post-check" {
+		t.Errorf("unexpected warned entry: %+v", warned)
+	}
+	if !strings.Contains(warned.Reasons, "[noisy-post-check]") {
+		t.Errorf("expected policy id in reasons, got %q", warned.Reasons)
+	}
+	if blocked.EnforcementAction != "blocked" || len(blocked.PolicyIDs) != 2 {
+		t.Errorf("unexpected blocked entry: %+v", blocked)
+	}
+}
+
+func TestStandaloneInterceptorPolicyValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+forbid ( principal, action, resource )
+when { resource.hostnme == "pastebin.com" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	// Without validation the typo loads and silently never fires
+	interceptor, err := NewStandaloneInterceptor(WithPolicyFile(policyPath))
+	if err != nil {
+		t.Fatalf("expected unvalidated policy to load: %v", err)
+	}
+	interceptor.Close()
+
+	_, err = NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyValidation(nil))
+	var validationErr *PolicyValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected *PolicyValidationError, got %v", err)
+	}
+	if len(validationErr.Issues) != 1 || validationErr.Issues[0].Pos.Line != 3 {
+		t.Errorf("unexpected issues: %v", validationErr.Issues)
+	}
+	if !strings.Contains(err.Error(), "unknown attribute resource.hostnme") {
+		t.Errorf("expected attribute in error message, got %q", err.Error())
+	}
+}
+
+func TestStandaloneInterceptorLegacyActionScope(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+forbid ( principal, action == Action::"deploy", resource )
+when { resource.hostname == "blocked.example.com" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	// Without the placeholder, deploy is an ordinary, unknown action
+	_, err := NewStandaloneInterceptor(WithPolicyFile(policyPath), WithPolicyValidation(nil), WithLegacyActionScope(""))
+	if err == nil || !strings.Contains(err.Error(), `unknown action "deploy"`) {
+		t.Fatalf("expected the deploy action to fail validation, got %v", err)
+	}
+
+	// By default the placeholder matches every action, as in older versions
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithPolicyValidation(nil),
+		WithEnforcement(EnforcementBlock),
+	)
+	if err != nil {
+		t.Fatalf("expected the legacy policy to load: %v", err)
+	}
+	defer si.Close()
+
+	req, _ := http.NewRequest("GET", "https://blocked.example.com/", nil)
+	resp, err := si.WrapClient(&http.Client{}).Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	var violation *PolicyViolationError
+	if !errors.As(err, &violation) {
+		t.Errorf("expected the legacy policy to block HTTP requests, got %v", err)
+	}
+}
+
+func TestStandaloneInterceptorExplainDenials(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+	logPath := filepath.Join(tmpDir, "events.jsonl")
+
+	policy := `
+@id("no-admin")
+forbid (principal, action, resource)
+when { resource.path like "/admin*" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	interceptor, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithLogFile(logPath),
+		WithExplainDenials(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer interceptor.Close()
+
+	client := interceptor.WrapClient(&http.Client{})
+	if _, err := client.Get(backend.URL + "/admin/users"); err == nil {
+		t.Error("expected request to be blocked")
+	}
+	resp, err := client.Get(backend.URL + "/health")
+	if err != nil {
+		t.Fatalf("expected request to pass: %v", err)
+	}
+	resp.Body.Close()
+
+	logData, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(lines))
+	}
+
+	var blocked, allowed eventLog
+	if err := json.Unmarshal([]byte(lines[0]), &blocked); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &allowed); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+
+	if blocked.Trace == nil || len(blocked.Trace.Rules) != 1 || !blocked.Trace.Rules[0].Matched {
+		t.Fatalf("expected trace of the matched rule, got %+v", blocked.Trace)
+	}
+	if clause := blocked.Trace.Rules[0].Conditions[0].Clauses[0]; clause.Children[0].Value != `"/admin/users"` {
+		t.Errorf("expected path lookup in trace, got %+v", clause)
+	}
+	if allowed.Trace != nil {
+		t.Error("expected no trace for allowed request")
+	}
+}
+
+func TestStandaloneInterceptorLinkTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	policyPath := filepath.Join(tmpDir, "policy.cedar")
+
+	policy := `
+@id("agent-host-block")
+forbid ( principal == ?principal, action, resource == ?resource )
+when { resource.path == "/payments" };
+`
+
+	if err := os.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	si, err := NewStandaloneInterceptor(
+		WithPolicyFile(policyPath),
+		WithEnforcement(EnforcementBlock),
+		WithPrincipal("billing-bot"),
+		WithPolicyValidation(nil),
+	)
+	if err != nil {
+		t.Fatalf("failed to create interceptor: %v", err)
+	}
+	defer si.Close()
+	client := si.WrapClient(&http.Client{})
+
+	get := func() error {
+		resp, err := client.Get(backend.URL + "/payments")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if len(si.Templates()) != 1 {
+		t.Fatalf("expected 1 template, got %d", len(si.Templates()))
+	}
+	if err := get(); err != nil {
+		t.Fatalf("expected unlinked template not to apply: %v", err)
+	}
+
+	values := map[SlotID]EntityRef{
+		SlotPrincipal: {Type: "Agent", ID: "billing-bot"},
+		SlotResource:  {Type: "Host", ID: backendURL.Hostname()},
+	}
+	if err := si.LinkTemplate("agent-host-block", "billing-local", values); err != nil {
+		t.Fatalf("failed to link template: %v", err)
+	}
+	if err := get(); err == nil || !strings.Contains(err.Error(), "[billing-local]") {
+		t.Errorf("expected request to be blocked by the linked policy, got %v", err)
+	}
+
+	if err := si.LinkTemplate("agent-host-block", "billing-local", values); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("expected duplicate id error, got %v", err)
+	}
+	if err := si.LinkTemplate("agent-host-block", "agent-host-block", values); err == nil {
+		t.Error("expected linking under the template's own id to fail")
+	}
+	if err := si.LinkTemplate("missing", "x", values); err == nil || !strings.Contains(err.Error(), `unknown template "missing"`) {
+		t.Errorf("expected unknown template error, got %v", err)
+	}
+
+	linked := si.LinkedPolicies()
+	if len(linked) != 1 || linked[0].ID != "billing-local" || linked[0].TemplateID != "agent-host-block" {
+		t.Fatalf("unexpected linked policies: %v", linked)
+	}
+
+	if err := si.UnlinkPolicy("billing-local"); err != nil {
+		t.Fatalf("failed to unlink policy: %v", err)
+	}
+	if err := get(); err != nil {
+		t.Errorf("expected request to pass after unlinking: %v", err)
+	}
+	if err := si.UnlinkPolicy("billing-local"); err == nil {
+		t.Error("expected unlinking twice to fail")
+	}
+}
@@ -2,6 +2,8 @@ package trusera
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,6 +40,47 @@ type Client struct {
 	ticker     *time.Ticker
 	wg         sync.WaitGroup
 
+	// shutdownOnce runs Shutdown once; later calls return shutdownErr
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// ctx scopes the background goroutines' requests; cancel is called on
+	// shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	traceExtractor TraceExtractor
+
+	// exporter sends the queued events, to the Trusera API unless replaced
+	// (see WithPrimaryExporter); exporters are the additional sinks, see
+	// WithExporter
+	exporter    Exporter
+	exporterSet bool
+	exporters   []*exportPipeline
+
+	// Flush retries, see WithRetry
+	retryAttempts  int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	requeueLimit   int
+	flushNotBefore time.Time // guarded by mu
+
+	// Bounded event queue, see WithQueue
+	queueSize    int
+	overflow     OverflowPolicy
+	blockTimeout time.Duration
+	sampleRate   int
+	flushWorkers int
+	overflowSeen uint64        // guarded by mu
+	space        chan struct{} // closed when events leave the queue; guarded by mu
+	flushReady   chan struct{}
+	dropped      queueCounters
+	closed       atomic.Bool // set by Shutdown; later events are dropped
+
+	// Durable event spool, see WithSpool
+	spoolConfig *SpoolConfig
+	spool       *spool
+
 	// Fleet auto-registration
 	autoRegister      bool
 	agentName         string
@@ -44,6 +88,14 @@ type Client struct {
 	environment       string
 	heartbeatInterval time.Duration
 	fleetAgentID      string
+
+	// Remote policy cache
+	policySync      bool
+	policyInterval  time.Duration
+	policyStaleTTL  time.Duration
+	policyFailMode  PolicyFailMode
+	policyPublicKey ed25519.PublicKey
+	policyCache     *PolicyCache
 }
 
 // Option configures a Client
@@ -171,10 +223,26 @@ func NewClient(apiKey string, opts ...Option) *Client {
 		done:              make(chan struct{}),
 		ticker:            time.NewTicker(defaultFlushInterval),
 		heartbeatInterval: defaultHeartbeatInterval,
+		retryAttempts:     defaultRetryAttempts,
+		initialBackoff:    defaultInitialBackoff,
+		maxBackoff:        defaultMaxBackoff,
+		requeueLimit:      defaultRequeueLimit,
+		queueSize:         defaultQueueSize,
+		overflow:          OverflowDropNewest,
+		blockTimeout:      defaultBlockTimeout,
+		sampleRate:        defaultSampleRate,
+		flushWorkers:      defaultFlushWorkers,
+		space:             make(chan struct{}),
+		flushReady:        make(chan struct{}, 1),
 		agentName:         envOrDefault("TRUSERA_AGENT_NAME", hostname),
 		agentType:         os.Getenv("TRUSERA_AGENT_TYPE"),
 		environment:       os.Getenv("TRUSERA_ENVIRONMENT"),
+		policyInterval:    defaultPolicyRefreshInterval,
+		policyStaleTTL:    defaultPolicyStaleTTL,
+		policyFailMode:    FailOpen,
+		traceExtractor:    TraceFromContext,
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	for _, opt := range opts {
 		opt(c)
@@ -188,6 +256,15 @@ func NewClient(apiKey string, opts ...Option) *Client {
 		log.Printf("[trusera] WARNING: API key is empty, API calls will fail")
 	}
 
+	if !c.exporterSet {
+		c.exporter = &HTTPExporter{
+			URL:        c.baseURL + "/v1/events",
+			APIKey:     c.apiKey,
+			HTTPClient: c.httpClient,
+			AgentID:    c.currentAgentID,
+		}
+	}
+
 	// Env var override for auto-register
 	envAuto := os.Getenv("TRUSERA_AUTO_REGISTER")
 	if envAuto == "true" || envAuto == "1" {
@@ -196,13 +273,40 @@ func NewClient(apiKey string, opts ...Option) *Client {
 		c.autoRegister = false
 	}
 
+	var replayed []Event
+	if c.spoolConfig != nil {
+		sp, unsent, err := openSpool(*c.spoolConfig)
+		if err != nil {
+			log.Printf("[trusera] WARNING: event spool disabled: %v", err)
+		} else {
+			c.spool, replayed = sp, unsent
+			c.wg.Add(1)
+			go c.spoolSyncLoop()
+		}
+	}
+
 	// Fleet auto-registration
 	if c.autoRegister {
-		c.registerWithFleet()
+		c.registerWithFleet(c.ctx)
 	}
 
-	c.wg.Add(1)
+	c.wg.Add(1 + c.flushWorkers)
 	go c.backgroundFlusher()
+	for i := 0; i < c.flushWorkers; i++ {
+		go c.flushWorker()
+	}
+	c.wg.Add(len(c.exporters))
+	for _, p := range c.exporters {
+		go p.run()
+	}
+
+	// Replay events left unsent by previous runs through the bounded queue,
+	// so the overflow policy applies to them as to tracked events
+	if c.exporter != nil {
+		for _, event := range replayed {
+			c.enqueue(event)
+		}
+	}
 
 	// Start heartbeat if fleet registration succeeded
 	if c.fleetAgentID != "" {
@@ -210,6 +314,16 @@ func NewClient(apiKey string, opts ...Option) *Client {
 		go c.heartbeatLoop()
 	}
 
+	// Remote policy sync: fetch eagerly so policies apply from the first request
+	if c.policySync {
+		c.policyCache = newPolicyCache(c)
+		if err := c.policyCache.RefreshContext(c.ctx); err != nil {
+			log.Printf("[trusera] initial policy fetch failed: %v", err)
+		}
+		c.wg.Add(1)
+		go c.policyRefreshLoop()
+	}
+
 	return c
 }
 
@@ -219,75 +333,113 @@ func (c *Client) backgroundFlusher() {
 	for {
 		select {
 		case <-c.ticker.C:
-			_ = c.Flush()
+			if err := c.FlushContext(c.ctx); err != nil {
+				log.Printf("[trusera] flush failed: %v", err)
+			}
 		case <-c.done:
 			return
 		}
 	}
 }
 
-// Track queues an event for sending
+// Track queues an event for sending without waiting for the API. When the
+// queue is full, the overflow policy decides what is dropped (see
+// WithQueue). With a spool, the event is written to disk first. The event
+// is also queued for each exporter added with WithExporter. Events tracked
+// after Shutdown are dropped and counted in QueueStats.
 func (c *Client) Track(event Event) {
-	c.mu.Lock()
-	c.events = append(c.events, event)
-	shouldFlush := len(c.events) >= c.flushSize
-	c.mu.Unlock()
+	if c.exporter != nil && c.closed.Load() {
+		c.dropped.closed.Add(1)
+	} else if c.exporter != nil {
+		if c.spool != nil {
+			if event.ID == "" {
+				event.ID = generateID()
+			}
+			if err := c.spool.append(event); err != nil {
+				log.Printf("[trusera] %v", err)
+			}
+		}
+		c.enqueue(event)
+	}
 
-	if shouldFlush {
-		// Flush synchronously to avoid unbounded goroutine accumulation.
-		// The background flusher handles periodic async flushes.
-		_ = c.Flush()
+	for _, p := range c.exporters {
+		p.add(event)
 	}
 }
 
-// Flush sends all queued events to the API
+// Flush sends all queued events to the API and to the exporters added with
+// WithExporter. Retryable failures are retried with backoff (see
+// WithRetry); if they persist, the API's batch is re-queued for the next
+// flush and the error returned. Batches the API rejects permanently are
+// dropped.
 func (c *Client) Flush() error {
-	c.mu.Lock()
-	if len(c.events) == 0 {
-		c.mu.Unlock()
-		return nil
-	}
-
-	events := make([]Event, len(c.events))
-	copy(events, c.events)
-	c.events = c.events[:0]
-	c.mu.Unlock()
+	return c.FlushContext(context.Background())
+}
 
-	payload := map[string]interface{}{
-		"agent_id": c.agentID,
-		"events":   events,
+// FlushContext is like Flush but stops retrying and re-queues the batch
+// when ctx is done. After Shutdown it returns ErrClientClosed.
+func (c *Client) FlushContext(ctx context.Context) error {
+	if c.closed.Load() {
+		return ErrClientClosed
 	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal events: %w", err)
+	err := c.flush(ctx, 0)
+	for _, p := range c.exporters {
+		if perr := p.flush(ctx); perr != nil {
+			err = errors.Join(err, fmt.Errorf("exporter %s: %w", p.cfg.Name, perr))
+		}
 	}
+	return err
+}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/v1/events", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// flush sends up to max queued events (all if max is zero) as one batch
+func (c *Client) flush(ctx context.Context, max int) error {
+	events := c.takeEvents(max)
+	if len(events) == 0 {
+		return nil
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-
-	resp, err := c.httpClient.Do(req)
+	err := c.sendWithRetry(ctx, events)
+	if err != nil && (IsRetryable(err) || ctx.Err() != nil) {
+		if ctx.Err() == nil {
+			c.deferFlush(c.initialBackoff)
+		}
+		if dropped := c.requeue(events); dropped > 0 {
+			c.dropped.requeue.Add(uint64(dropped))
+			log.Printf("[trusera] re-queue limit reached, dropped %d oldest events", dropped)
+		}
+		return err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to send events: %w", err)
+		c.dropped.rejected.Add(uint64(len(events)))
 	}
-	defer resp.Body.Close()
-	// Drain body to allow connection reuse
-	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	// Sent, or rejected for good: either way the spool need not replay them
+	if c.spool != nil {
+		if ackErr := c.spool.ack(events); ackErr != nil {
+			log.Printf("[trusera] %v", ackErr)
+		}
 	}
+	return err
+}
+
+// send posts a batch of events to the API
+func (c *Client) send(ctx context.Context, events []Event) error {
+	return c.exporter.Export(ctx, events)
+}
 
-	return nil
+// currentAgentID returns the agent ID sent with each batch
+func (c *Client) currentAgentID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.agentID
 }
 
 // RegisterAgent registers an agent with Trusera, returns agent ID
 func (c *Client) RegisterAgent(name, framework string) (string, error) {
+	return c.RegisterAgentContext(context.Background(), name, framework)
+}
+
+// RegisterAgentContext is like RegisterAgent with a context for the request
+func (c *Client) RegisterAgentContext(ctx context.Context, name, framework string) (string, error) {
 	if name == "" {
 		return "", errors.New("agent name is required")
 	}
@@ -302,7 +454,7 @@ func (c *Client) RegisterAgent(name, framework string) (string, error) {
 		return "", fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/v1/agents", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/agents", bytes.NewReader(body))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -369,7 +521,7 @@ func (c *Client) getNetworkInfo() map[string]interface{} {
 	return info
 }
 
-func (c *Client) registerWithFleet() {
+func (c *Client) registerWithFleet(ctx context.Context) {
 	hostname, _ := os.Hostname()
 	payload := map[string]interface{}{
 		"name":             c.agentName,
@@ -392,7 +544,7 @@ func (c *Client) registerWithFleet() {
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/api/v1/fleet/register", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/fleet/register", bytes.NewReader(body))
 	if err != nil {
 		log.Printf("[trusera] fleet register request error: %v", err)
 		return
@@ -439,14 +591,14 @@ func (c *Client) heartbeatLoop() {
 	for {
 		select {
 		case <-hbTicker.C:
-			c.sendHeartbeat()
+			c.sendHeartbeat(c.ctx)
 		case <-c.done:
 			return
 		}
 	}
 }
 
-func (c *Client) sendHeartbeat() {
+func (c *Client) sendHeartbeat(ctx context.Context) {
 	c.mu.Lock()
 	fleetID := c.fleetAgentID
 	c.mu.Unlock()
@@ -465,7 +617,7 @@ func (c *Client) sendHeartbeat() {
 	}
 
 	url := fmt.Sprintf("%s/api/v1/fleet/%s/heartbeat", c.baseURL, fleetID)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		return
 	}
@@ -486,11 +638,9 @@ func (c *Client) sendHeartbeat() {
 	}
 }
 
-// Close flushes remaining events and stops background goroutine
+// Close flushes remaining events, stops background goroutines and closes
+// the spool. It is Shutdown without a deadline, except that a failing
+// final flush is retried only once, without backoff.
 func (c *Client) Close() error {
-	c.ticker.Stop()
-	close(c.done)
-	c.wg.Wait()
-
-	return c.Flush()
+	return c.Shutdown(context.Background())
 }